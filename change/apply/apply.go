@@ -0,0 +1,370 @@
+// change/apply/apply.go: applying changesets to a materialized Graph
+
+package apply
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// Applier applies changesets to a Graph, one changeset at a time
+// (not safe for concurrent use).
+type Applier struct {
+	g Graph
+
+	// ID of the last changeset applied (id.NoID if none)
+	lastCSetID id.IntID
+
+	// Undo actions for the records applied from the current changeset,
+	// in the order the records were applied
+	undo []func() error
+}
+
+// NewApplier returns an Applier for the given Graph;
+// the Graph is expected to reflect all the changesets before
+// the first one to be applied.
+func NewApplier(g Graph) *Applier {
+	return &Applier{g: g}
+}
+
+// Graph returns the Graph changesets are applied to.
+func (a *Applier) Graph() Graph {
+	return a.g
+}
+
+// LastCSetID returns the ID of the last changeset successfully applied,
+// or id.NoID if none.
+func (a *Applier) LastCSetID() id.IntID {
+	return a.lastCSetID
+}
+
+// ApplyAll pulls change records (expected in changeset order,
+// see change.RecPullSource implementations) and applies them,
+// one changeset at a time, until the source is exhausted;
+// returns the number of changesets applied.
+//
+// The records of one changeset are kept in memory until applied.
+// Stops at the first changeset that cannot be applied
+// (the Graph reflects all the changesets before it).
+//
+func (a *Applier) ApplyAll(src change.RecPullSource) (nApplied int, err error) {
+	var set change.Set
+
+	for {
+		rec, gotRec, err := src.GetNextChangeRec()
+		if err != nil {
+			return nApplied, errors.Wrapf(err, "Failed to pull change record after changeset %d",
+				a.lastCSetID)
+		}
+		if !gotRec {
+			break
+		}
+
+		if rec.ChangeSetID != set.ID {
+			if set.ID != id.NoID {
+				err = a.ApplySet(&set)
+				if err != nil {
+					return nApplied, err
+				}
+				nApplied++
+			}
+			if rec.ChangeSetID <= a.lastCSetID {
+				return nApplied, &OrderError{LastCSetID: a.lastCSetID, CSetID: rec.ChangeSetID}
+			}
+			set = change.Set{SetInfo: change.SetInfo{ID: rec.ChangeSetID}}
+		}
+		set.ChangeRecords = append(set.ChangeRecords, rec)
+	}
+
+	if set.ID != id.NoID {
+		err = a.ApplySet(&set)
+		if err != nil {
+			return nApplied, err
+		}
+		nApplied++
+	}
+	return nApplied, nil
+}
+
+// ApplySet applies the change records of the given changeset, in order,
+// atomically: if one record cannot be applied, the records already applied
+// are undone and the error (*ConflictError, *BadRecError or other)
+// is returned.
+//
+// The 'TestRT' records are evaluated first (see CheckPreconditions);
+// if any fails, nothing is applied and *PreconditionError is returned.
+//
+// Records without changeset ID (id.NoID) are taken as part of this changeset.
+// Unlike ApplyAll, the changeset order is not checked.
+//
+func (a *Applier) ApplySet(set *change.Set) error {
+	err := a.CheckPreconditions(set)
+	if err != nil {
+		return err
+	}
+
+	a.undo = a.undo[:0]
+
+	for i := range set.ChangeRecords {
+		rec := set.ChangeRecords[i]
+		if rec.ChangeSetID == id.NoID {
+			rec.ChangeSetID = set.ID
+		}
+
+		err = a.applyRec(i, &rec)
+		if err != nil {
+			return a.rollback(err)
+		}
+	}
+
+	a.undo = a.undo[:0]
+	if set.ID > a.lastCSetID {
+		a.lastCSetID = set.ID
+	}
+	return nil
+}
+
+func (a *Applier) rollback(cause error) error {
+	for i := len(a.undo) - 1; i >= 0; i-- {
+		err := a.undo[i]()
+		if err != nil {
+			a.undo = a.undo[:0]
+			return errors.Wrapf(err, "Failed to undo partially applied changeset (Graph left inconsistent) after: %v",
+				cause)
+		}
+	}
+	a.undo = a.undo[:0]
+	return cause
+}
+
+func (a *Applier) applyRec(i int, rec *change.Rec) error {
+	switch rec.Form {
+	case change.UsualTriple:
+		return a.applyTripleRec(i, rec)
+	case change.OrdContItem:
+		return a.applyOrdContRec(i, rec)
+	case change.IDLitBin:
+		return a.applyBinRec(i, rec)
+	default:
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "unsupported record form"}
+	}
+}
+
+// isNoOpType tells if records of the given type never change the state.
+func isNoOpType(recType change.RecTypeCode) bool {
+	return recType == change.TestRT || change.IsInfoRecType(recType)
+}
+
+func (a *Applier) applyTripleRec(i int, rec *change.Rec) error {
+	if isNoOpType(rec.ChangeRecType) {
+		return nil
+	}
+	propID, ok := rec.Prop.ID()
+	if !ok {
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "usual triple with position instead of property"}
+	}
+	t := Triple{SubjectID: rec.SubjectID, PropID: propID, Value: ValueOf(rec)}
+
+	switch rec.ChangeRecType {
+	case change.AddRT:
+		found, err := a.g.HasTriple(t)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to look up triple %+v", t)
+		}
+		if found {
+			return &ConflictError{Kind: TripleExistsCK, RecIndex: i, Rec: *rec}
+		}
+		err = a.g.AddTriple(t)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to add triple %+v", t)
+		}
+		a.undo = append(a.undo, func() error { return a.g.DelTriple(t) })
+	case change.DelRT:
+		found, err := a.g.HasTriple(t)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to look up triple %+v", t)
+		}
+		if !found {
+			return &ConflictError{Kind: TripleAbsentCK, RecIndex: i, Rec: *rec}
+		}
+		err = a.g.DelTriple(t)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to delete triple %+v", t)
+		}
+		a.undo = append(a.undo, func() error { return a.g.AddTriple(t) })
+	default:
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "record type not applicable to usual triple"}
+	}
+	return nil
+}
+
+func (a *Applier) applyOrdContRec(i int, rec *change.Rec) error {
+	if isNoOpType(rec.ChangeRecType) {
+		return nil
+	}
+	pos, ok := rec.Prop.Pos()
+	if !ok {
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "container item without position"}
+	}
+	subjectID := rec.SubjectID
+
+	n, err := a.g.ContainerLen(subjectID)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get length of container %d", subjectID)
+	}
+
+	switch rec.ChangeRecType {
+	case change.AppendRT, change.PrependRT, change.InsertAtRT:
+		switch rec.ChangeRecType {
+		case change.AppendRT:
+			pos = n
+		case change.PrependRT:
+			pos = 0
+		}
+		if pos < 0 || pos > n {
+			return &ConflictError{Kind: PosOutOfRangeCK, RecIndex: i, Rec: *rec,
+				Detail: lengthDetail(n)}
+		}
+		err = a.g.InsertItem(subjectID, pos, ValueOf(rec))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to insert item at %d in container %d", pos, subjectID)
+		}
+		a.undo = append(a.undo, func() error { return a.g.RemoveItem(subjectID, pos) })
+
+	case change.RemoveAtRT, change.ReplaceAtRT:
+		if pos < 0 || pos >= n {
+			return &ConflictError{Kind: PosOutOfRangeCK, RecIndex: i, Rec: *rec,
+				Detail: lengthDetail(n)}
+		}
+		old, err := a.g.ContainerItem(subjectID, pos)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get item at %d in container %d", pos, subjectID)
+		}
+
+		if rec.ChangeRecType == change.ReplaceAtRT {
+			err = a.g.ReplaceItem(subjectID, pos, ValueOf(rec))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to replace item at %d in container %d", pos, subjectID)
+			}
+			a.undo = append(a.undo, func() error { return a.g.ReplaceItem(subjectID, pos, old) })
+			return nil
+		}
+
+		// The removed value is optional in the record;
+		// when present, it must match the current item:
+		if v := ValueOf(rec); v != (Value{}) && v != old {
+			return &ConflictError{Kind: ItemMismatchCK, RecIndex: i, Rec: *rec}
+		}
+		err = a.g.RemoveItem(subjectID, pos)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to remove item at %d from container %d", pos, subjectID)
+		}
+		a.undo = append(a.undo, func() error { return a.g.InsertItem(subjectID, pos, old) })
+
+	default:
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "record type not applicable to container item"}
+	}
+	return nil
+}
+
+func (a *Applier) applyBinRec(i int, rec *change.Rec) error {
+	if isNoOpType(rec.ChangeRecType) {
+		return nil
+	}
+	off, ok := rec.Prop.Pos()
+	if !ok {
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "binary literal record without offset"}
+	}
+	subjectID := rec.SubjectID
+
+	data, found, err := a.g.BinLiteral(subjectID)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get binary literal %d", subjectID)
+	}
+	n := int64(len(data))
+	rangeEnd := off + int64(len(rec.BinVal))
+
+	var newData []byte
+
+	switch rec.ChangeRecType {
+	case change.AddRT:
+		if found {
+			return &ConflictError{Kind: BinLiteralExistsCK, RecIndex: i, Rec: *rec}
+		}
+		newData = append([]byte{}, rec.BinVal...)
+
+	case change.DelRT:
+		if !found {
+			return &ConflictError{Kind: BinLiteralAbsentCK, RecIndex: i, Rec: *rec}
+		}
+		// The deleted value is optional in the record:
+		if len(rec.BinVal) != 0 && !bytes.Equal(rec.BinVal, data) {
+			return &ConflictError{Kind: BinMismatchCK, RecIndex: i, Rec: *rec}
+		}
+		err = a.g.DelBinLiteral(subjectID)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to delete binary literal %d", subjectID)
+		}
+		a.undo = append(a.undo, func() error { return a.g.SetBinLiteral(subjectID, data) })
+		return nil
+
+	case change.AppendRT, change.PrependRT, change.InsertAtRT:
+		// A missing literal is taken as empty (and made by the insertion).
+		switch rec.ChangeRecType {
+		case change.AppendRT:
+			off = n
+		case change.PrependRT:
+			off = 0
+		}
+		if off < 0 || off > n {
+			return &ConflictError{Kind: PosOutOfRangeCK, RecIndex: i, Rec: *rec,
+				Detail: lengthDetail(n)}
+		}
+		newData = make([]byte, 0, len(data)+len(rec.BinVal))
+		newData = append(newData, data[:off]...)
+		newData = append(newData, rec.BinVal...)
+		newData = append(newData, data[off:]...)
+
+	case change.RemoveAtRT, change.ReplaceAtRT:
+		if !found {
+			return &ConflictError{Kind: BinLiteralAbsentCK, RecIndex: i, Rec: *rec}
+		}
+		if off < 0 || rangeEnd > n {
+			return &ConflictError{Kind: PosOutOfRangeCK, RecIndex: i, Rec: *rec,
+				Detail: lengthDetail(n)}
+		}
+		if rec.ChangeRecType == change.ReplaceAtRT {
+			newData = append([]byte{}, data...)
+			copy(newData[off:], rec.BinVal)
+			break
+		}
+		if !bytes.Equal(rec.BinVal, data[off:rangeEnd]) {
+			return &ConflictError{Kind: BinMismatchCK, RecIndex: i, Rec: *rec}
+		}
+		newData = make([]byte, 0, len(data)-len(rec.BinVal))
+		newData = append(newData, data[:off]...)
+		newData = append(newData, data[rangeEnd:]...)
+
+	default:
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: "record type not applicable to binary literal"}
+	}
+
+	err = a.g.SetBinLiteral(subjectID, newData)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to set binary literal %d", subjectID)
+	}
+	if found {
+		a.undo = append(a.undo, func() error { return a.g.SetBinLiteral(subjectID, data) })
+	} else {
+		a.undo = append(a.undo, func() error { return a.g.DelBinLiteral(subjectID) })
+	}
+	return nil
+}
+
+func lengthDetail(n int64) string {
+	return "current length " + strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,309 @@
+package apply
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/changetest"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// graphState = everything visible in a MemGraph, comparable with reflect.DeepEqual.
+type graphState struct {
+	Triples    []Triple
+	Containers map[id.IntID][]Value
+	BinLits    map[id.IntID]string
+}
+
+func stateOf(g *MemGraph) graphState {
+	s := graphState{
+		Triples:    g.Triples(),
+		Containers: make(map[id.IntID][]Value),
+		BinLits:    make(map[id.IntID]string),
+	}
+	for _, subjectID := range g.ContainerIDs() {
+		s.Containers[subjectID] = g.Container(subjectID)
+	}
+	for _, subjectID := range g.BinLiteralIDs() {
+		data, _, _ := g.BinLiteral(subjectID)
+		s.BinLits[subjectID] = string(data)
+	}
+	return s
+}
+
+// newTestApplier returns an Applier on a MemGraph with a triple
+// (1, 2, 3), a container 10 with items [100, 101] and a binary
+// literal 20 with "abcdef", applied as changeset 1.
+func newTestApplier(t *testing.T) (*Applier, *MemGraph) {
+	g := NewMemGraph()
+	a := NewApplier(g)
+	err := a.ApplySet(&change.Set{SetInfo: change.SetInfo{ID: 1}, ChangeRecords: []change.Rec{
+		changetest.TripleRec(change.AddRT, 1, 2, 3),
+		changetest.ItemRec(change.AppendRT, 10, 0, 100),
+		changetest.ItemRec(change.AppendRT, 10, 1, 101),
+		changetest.BinRec(change.AddRT, 20, 0, "abcdef"),
+	}})
+	if err != nil {
+		t.Fatalf("Failed to apply initial changeset: %v", err)
+	}
+	return a, g
+}
+
+func TestApplySetConflict(t *testing.T) {
+	// Each changeset starts with records that apply,
+	// so the rollback of the conflicting changeset is checked too.
+	applied := []change.Rec{
+		changetest.TripleRec(change.AddRT, 1, 2, 4),
+		changetest.ItemRec(change.InsertAtRT, 10, 1, 102),
+		changetest.BinRec(change.AppendRT, 20, 0, "gh"),
+		changetest.BinRec(change.AddRT, 21, 0, "new"),
+	}
+	tests := []struct {
+		name string
+		rec  change.Rec
+		kind ConflictKind
+	}{
+		{"add existing triple", changetest.TripleRec(change.AddRT, 1, 2, 3), TripleExistsCK},
+		{"delete missing triple", changetest.TripleRec(change.DelRT, 1, 2, 5), TripleAbsentCK},
+		{"insert past the end", changetest.ItemRec(change.InsertAtRT, 10, 4, 103), PosOutOfRangeCK},
+		{"remove past the end", changetest.ItemRec(change.RemoveAtRT, 10, 3, 0), PosOutOfRangeCK},
+		{"replace in missing container", changetest.ItemRec(change.ReplaceAtRT, 11, 0, 103), PosOutOfRangeCK},
+		{"remove other item", changetest.ItemRec(change.RemoveAtRT, 10, 0, 101), ItemMismatchCK},
+		{"add existing literal", changetest.BinRec(change.AddRT, 20, 0, "x"), BinLiteralExistsCK},
+		{"delete missing literal", changetest.BinRec(change.DelRT, 22, 0, ""), BinLiteralAbsentCK},
+		{"remove from missing literal", changetest.BinRec(change.RemoveAtRT, 22, 0, "a"), BinLiteralAbsentCK},
+		{"delete other bytes", changetest.BinRec(change.DelRT, 20, 0, "abcdef"), BinMismatchCK},
+		{"remove other bytes", changetest.BinRec(change.RemoveAtRT, 20, 1, "x"), BinMismatchCK},
+		{"remove past the end", changetest.BinRec(change.RemoveAtRT, 20, 7, "gh"), PosOutOfRangeCK},
+		{"insert past the end", changetest.BinRec(change.InsertAtRT, 20, 9, "x"), PosOutOfRangeCK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, g := newTestApplier(t)
+			before := stateOf(g)
+
+			recs := append(append([]change.Rec(nil), applied...), tt.rec)
+			err := a.ApplySet(&change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: recs})
+			ce, ok := err.(*ConflictError)
+			if !ok {
+				t.Fatalf("ApplySet returned %v, want *ConflictError", err)
+			}
+			if ce.Kind != tt.kind || ce.RecIndex != len(applied) || ce.Rec.ChangeSetID != 2 {
+				t.Errorf("Conflict %v at record #%d of changeset %d, want %v at #%d of changeset 2",
+					ce.Kind, ce.RecIndex, ce.Rec.ChangeSetID, tt.kind, len(applied))
+			}
+			if after := stateOf(g); !reflect.DeepEqual(after, before) {
+				t.Errorf("Not rolled back:\n got  %+v\n want %+v", after, before)
+			}
+			if a.LastCSetID() != 1 {
+				t.Errorf("LastCSetID() = %d after conflict, want 1", a.LastCSetID())
+			}
+		})
+	}
+}
+
+func TestApplySetBadRec(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  change.Rec
+	}{
+		{"triple with position", change.Rec{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+			SubjectID: 1, Prop: id.FromPos(0), ObjectID: 3}},
+		{"item without position", change.Rec{Form: change.OrdContItem, ChangeRecType: change.InsertAtRT,
+			SubjectID: 10, Prop: id.FromID(2), ObjectID: 3}},
+		{"append to triple", changetest.TripleRec(change.AppendRT, 1, 2, 5)},
+		{"add container item", changetest.ItemRec(change.AddRT, 10, 0, 103)},
+		{"unknown record type", changetest.BinRec(change.RecTypeCode(99), 20, 0, "a")},
+		{"unknown record form", change.Rec{Form: change.RecFormCode(99), ChangeRecType: change.AddRT,
+			SubjectID: 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, g := newTestApplier(t)
+			before := stateOf(g)
+
+			recs := []change.Rec{changetest.TripleRec(change.AddRT, 1, 2, 4), tt.rec}
+			err := a.ApplySet(&change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: recs})
+			bad, ok := err.(*BadRecError)
+			if !ok {
+				t.Fatalf("ApplySet returned %v, want *BadRecError", err)
+			}
+			if bad.RecIndex != 1 {
+				t.Errorf("Bad record #%d, want #1", bad.RecIndex)
+			}
+			if after := stateOf(g); !reflect.DeepEqual(after, before) {
+				t.Errorf("Not rolled back:\n got  %+v\n want %+v", after, before)
+			}
+		})
+	}
+}
+
+func TestApplySet(t *testing.T) {
+	a, g := newTestApplier(t)
+
+	err := a.ApplySet(&change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: []change.Rec{
+		changetest.TripleRec(change.TestRT, 1, 2, 3),
+		changetest.TripleRec(change.DelRT, 1, 2, 3),
+		changetest.TripleRec(change.AddRT, 1, 2, 4),
+		changetest.ItemRec(change.PrependRT, 10, 0, 99),
+		changetest.ItemRec(change.RemoveAtRT, 10, 1, 100),
+		changetest.ItemRec(change.ReplaceAtRT, 10, 1, 102),
+		changetest.BinRec(change.ReplaceAtRT, 20, 0, "AB"),
+		changetest.BinRec(change.RemoveAtRT, 20, 4, "ef"),
+		changetest.BinRec(change.InsertAtRT, 20, 2, "-"),
+	}})
+	if err != nil {
+		t.Fatalf("ApplySet failed: %v", err)
+	}
+
+	want := graphState{
+		Triples:    []Triple{{SubjectID: 1, PropID: 2, Value: Value{ObjectID: 4}}},
+		Containers: map[id.IntID][]Value{10: {{ObjectID: 99}, {ObjectID: 102}}},
+		BinLits:    map[id.IntID]string{20: "AB-cd"},
+	}
+	if got := stateOf(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("State after changeset 2:\n got  %+v\n want %+v", got, want)
+	}
+	if a.LastCSetID() != 2 {
+		t.Errorf("LastCSetID() = %d, want 2", a.LastCSetID())
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	tests := []struct {
+		name   string
+		recs   []change.Rec
+		failed []ConflictKind // in record order
+	}{
+		{"all hold", []change.Rec{
+			changetest.TripleRec(change.TestRT, 1, 2, 3),
+			changetest.ItemRec(change.TestRT, 10, 1, 101),
+			changetest.BinRec(change.TestRT, 20, 2, "cd"),
+			changetest.BinRec(change.TestRT, 20, 0, ""),
+		}, nil},
+		{"missing triple", []change.Rec{
+			changetest.TripleRec(change.TestRT, 1, 2, 4),
+		}, []ConflictKind{TripleAbsentCK}},
+		{"container items", []change.Rec{
+			changetest.ItemRec(change.TestRT, 10, 0, 101),
+			changetest.ItemRec(change.TestRT, 10, 2, 101),
+			changetest.ItemRec(change.TestRT, 11, 0, 101),
+		}, []ConflictKind{ItemMismatchCK, PosOutOfRangeCK, PosOutOfRangeCK}},
+		{"binary literals", []change.Rec{
+			changetest.BinRec(change.TestRT, 21, 0, ""),
+			changetest.BinRec(change.TestRT, 20, 5, "fg"),
+			changetest.BinRec(change.TestRT, 20, 0, "abd"),
+		}, []ConflictKind{BinLiteralAbsentCK, PosOutOfRangeCK, BinMismatchCK}},
+		{"tests before changes", []change.Rec{
+			// Evaluated against the state before the changeset:
+			changetest.TripleRec(change.DelRT, 1, 2, 3),
+			changetest.TripleRec(change.TestRT, 1, 2, 3),
+			changetest.TripleRec(change.AddRT, 1, 2, 4),
+			changetest.TripleRec(change.TestRT, 1, 2, 4),
+		}, []ConflictKind{TripleAbsentCK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, g := newTestApplier(t)
+			before := stateOf(g)
+			set := &change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: tt.recs}
+
+			err := a.CheckPreconditions(set)
+			var gotKinds []ConflictKind
+			if err != nil {
+				pe, ok := err.(*PreconditionError)
+				if !ok {
+					t.Fatalf("CheckPreconditions returned %v, want *PreconditionError", err)
+				}
+				for _, f := range pe.Failed {
+					if tt.recs[f.RecIndex].ChangeRecType != change.TestRT || f.Rec.ChangeSetID != 2 {
+						t.Errorf("Failed test reported for record #%d: %+v", f.RecIndex, f.Rec)
+					}
+					gotKinds = append(gotKinds, f.Kind)
+				}
+			}
+			if !reflect.DeepEqual(gotKinds, tt.failed) {
+				t.Errorf("Failed tests %v, want %v", gotKinds, tt.failed)
+			}
+
+			// ApplySet rejects the changeset as a whole:
+			err = a.ApplySet(set)
+			if _, ok := err.(*PreconditionError); ok != (tt.failed != nil) {
+				t.Errorf("ApplySet returned %v", err)
+			}
+			if tt.failed != nil {
+				if after := stateOf(g); !reflect.DeepEqual(after, before) {
+					t.Errorf("State changed by rejected changeset:\n got  %+v\n want %+v", after, before)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAllOrder(t *testing.T) {
+	recs := []change.Rec{
+		changetest.TripleRec(change.AddRT, 1, 2, 3),
+		changetest.TripleRec(change.AddRT, 1, 2, 4),
+		changetest.TripleRec(change.AddRT, 1, 2, 5),
+	}
+	recs[0].ChangeSetID, recs[1].ChangeSetID, recs[2].ChangeSetID = 2, 3, 3
+	src := &sliceSource{recs: recs}
+
+	a := NewApplier(NewMemGraph())
+	a.lastCSetID = 2
+	n, err := a.ApplyAll(src)
+	if _, ok := err.(*OrderError); !ok || n != 0 {
+		t.Errorf("ApplyAll after changeset 2 returned %d, %v; want 0, *OrderError", n, err)
+	}
+
+	a = NewApplier(NewMemGraph())
+	src.next = 0
+	n, err = a.ApplyAll(src)
+	if err != nil || n != 2 || a.LastCSetID() != 3 {
+		t.Errorf("ApplyAll returned %d, %v (last changeset %d); want 2, nil (3)", n, err, a.LastCSetID())
+	}
+}
+
+type sliceSource struct {
+	recs []change.Rec
+	next int
+}
+
+func (src *sliceSource) GetNextChangeRec() (rec change.Rec, gotRec bool, err error) {
+	if src.next >= len(src.recs) {
+		return rec, false, nil
+	}
+	src.next++
+	return src.recs[src.next-1], true, nil
+}
+
+func TestMemGraphRange(t *testing.T) {
+	g := NewMemGraph()
+	v := Value{ObjectID: 100}
+
+	if err := g.InsertItem(10, 1, v); err == nil {
+		t.Errorf("InsertItem past the end of an empty container succeeded")
+	}
+	if err := g.InsertItem(10, 0, v); err != nil {
+		t.Fatalf("InsertItem failed: %v", err)
+	}
+	if _, err := g.ContainerItem(10, 1); err == nil {
+		t.Errorf("ContainerItem past the end succeeded")
+	}
+	if err := g.ReplaceItem(10, -1, v); err == nil {
+		t.Errorf("ReplaceItem at -1 succeeded")
+	}
+	if err := g.RemoveItem(10, 1); err == nil {
+		t.Errorf("RemoveItem past the end succeeded")
+	}
+	if err := g.RemoveItem(10, 0); err != nil {
+		t.Fatalf("RemoveItem failed: %v", err)
+	}
+	if n, _ := g.ContainerLen(10); n != 0 || g.Container(10) != nil || len(g.ContainerIDs()) != 0 {
+		t.Errorf("Container not empty after removing its only item")
+	}
+}
@@ -0,0 +1,93 @@
+// change/apply/dryrun.go: applying a changeset without keeping the changes
+
+package apply
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// Check tells (by returning nil) whether the given changeset can be
+// applied to the given state; see Applier.ApplySet for the errors.
+// The Graph is left unchanged: the changeset is applied, then undone.
+func Check(g Graph, set *change.Set) error {
+	return NewApplier(g).dryRun(set, nil)
+}
+
+// ResolvePositions returns a copy of the given changeset where
+// the 'AppendRT' and 'PrependRT' records (container items and
+// binary literals) have the actual position or offset in 'Prop',
+// as it would be when applying the changeset to the given state.
+// The Graph is left unchanged: the changeset is applied, then undone.
+func ResolvePositions(g Graph, set *change.Set) (*change.Set, error) {
+	a := NewApplier(g)
+
+	resolved := &change.Set{
+		SetInfo:       set.SetInfo,
+		ChangeRecords: append([]change.Rec(nil), set.ChangeRecords...),
+	}
+	err := a.dryRun(set, func(i int, rec *change.Rec) error {
+		var n int64
+		switch {
+		case rec.ChangeRecType != change.AppendRT && rec.ChangeRecType != change.PrependRT:
+			return nil
+		case rec.ChangeRecType == change.PrependRT:
+			n = 0
+		case rec.Form == change.OrdContItem:
+			var err error
+			n, err = a.g.ContainerLen(rec.SubjectID)
+			if err != nil {
+				return err
+			}
+		case rec.Form == change.IDLitBin:
+			data, _, err := a.g.BinLiteral(rec.SubjectID)
+			if err != nil {
+				return err
+			}
+			n = int64(len(data))
+		default:
+			return nil
+		}
+		resolved.ChangeRecords[i].Prop = id.FromPos(n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// dryRun applies the given changeset, record by record, then undoes
+// all the changes. The preconditions are checked first.
+//
+// If not nil, 'visit' is called for each record that changes the state,
+// in the state just before the record is applied.
+//
+func (a *Applier) dryRun(set *change.Set, visit func(i int, rec *change.Rec) error) error {
+	err := a.CheckPreconditions(set)
+	if err != nil {
+		return err
+	}
+
+	a.undo = a.undo[:0]
+	for i := range set.ChangeRecords {
+		rec := set.ChangeRecords[i]
+		if rec.ChangeSetID == id.NoID {
+			rec.ChangeSetID = set.ID
+		}
+		if isNoOpType(rec.ChangeRecType) {
+			continue
+		}
+
+		if visit != nil {
+			err = visit(i, &rec)
+		}
+		if err == nil {
+			err = a.applyRec(i, &rec)
+		}
+		if err != nil {
+			return a.rollback(err)
+		}
+	}
+	return a.rollback(nil)
+}
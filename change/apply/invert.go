@@ -0,0 +1,252 @@
+// change/apply/invert.go: computing the inverse (undo) of a changeset
+
+package apply
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// Invert returns the inverse (undo) of the given changeset: applying
+// the inverse right after the changeset restores the state before it.
+//
+// 'before' must be the state the changeset applies to; it provides
+// the old values that the records do not carry (removed or replaced
+// container items, replaced bytes, deleted binary literals).
+// It is left unchanged: the changeset is applied, then undone.
+//
+// The inverse changeset has no header fields set, and its records
+// have no changeset ID. Records that do not change the state
+// ('TestRT', 'ContextRT', 'IdentRT', 'MetaRT') have no inverse;
+// the inverted records of an editing operation left incomplete
+// without them (a copy, whose only adding record is inverted into
+// a deletion) lose their operation flags and 'EditOpCID'.
+//
+// Fails with *BadRecError for a record that cannot be inverted, or
+// with the error of applying the changeset to 'before' (see ApplySet).
+//
+func Invert(set *change.Set, before Graph) (*change.Set, error) {
+	a := NewApplier(before)
+
+	inverted := make([]change.Rec, 0, len(set.ChangeRecords))
+	err := a.dryRun(set, func(i int, rec *change.Rec) error {
+		inv, err := a.invertRec(i, rec)
+		if err != nil {
+			return err
+		}
+		inverted = append(inverted, inv)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inverse := &change.Set{ChangeRecords: make([]change.Rec, len(inverted))}
+	for i := range inverted {
+		inverse.ChangeRecords[len(inverted)-1-i] = inverted[i]
+	}
+	UnlinkIncompleteEditOps(inverse.ChangeRecords)
+	linkReorderingPairs(inverse.ChangeRecords)
+	return inverse, nil
+}
+
+// InvertStored returns the inverse of a changeset from the store;
+// the state before the changeset is materialized (in memory) from
+// all the changesets with smaller IDs.
+//
+// Each call reads and applies the whole history before the changeset:
+// time and memory grow with the size of the store (nothing is cached).
+// For inverting several changesets, or when the state is already at hand,
+// use Invert with a Graph kept up to date (see Materialize and Applier).
+//
+func InvertStored(dop cstore.ReadingDop, csetID id.IntID) (*change.Set, error) {
+	set, found, err := cstore.ReadSet(dop, csetID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Errorf("Cannot invert changeset %d: not found", csetID)
+	}
+
+	before := NewMemGraph()
+	err = Materialize(dop, csetID-1, before)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot invert changeset %d", csetID)
+	}
+
+	inverse, err := Invert(set, before)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot invert changeset %d", csetID)
+	}
+	return inverse, nil
+}
+
+// Materialize applies to the given Graph all the changesets from the store
+// with IDs up to 'lastCSetID' (inclusive); the Graph should be empty.
+func Materialize(dop cstore.ReadingDop, lastCSetID id.IntID, g Graph) error {
+	if lastCSetID <= id.NoID {
+		return nil
+	}
+	src, err := dop.MakeCSetRangePullSourceCloser(id.NoID+1, lastCSetID)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = NewApplier(g).ApplyAll(src)
+	return err
+}
+
+// invertRec returns the inverse of the given record,
+// computed in the current state (before applying the record).
+//
+// Positions and offsets out of range are not reported here:
+// applying the record will fail anyway.
+//
+func (a *Applier) invertRec(i int, rec *change.Rec) (change.Rec, error) {
+	inv := *rec
+	inv.ChangeSetID = id.NoID
+	inv.OldProp = 0
+
+	badRec := func(descr string) error {
+		return &BadRecError{RecIndex: i, Rec: *rec, Descr: descr}
+	}
+
+	switch rec.Form {
+	case change.UsualTriple:
+		switch rec.ChangeRecType {
+		case change.AddRT:
+			inv.ChangeRecType = change.DelRT
+		case change.DelRT:
+			inv.ChangeRecType = change.AddRT
+		default:
+			return inv, badRec("record type cannot be inverted for usual triple")
+		}
+
+	case change.OrdContItem:
+		pos, ok := rec.Prop.Pos()
+		if !ok {
+			return inv, badRec("container item without position")
+		}
+		n, err := a.g.ContainerLen(rec.SubjectID)
+		if err != nil {
+			return inv, errors.Wrapf(err, "Failed to get length of container %d", rec.SubjectID)
+		}
+
+		switch rec.ChangeRecType {
+		case change.AppendRT:
+			pos = n
+		case change.PrependRT:
+			pos = 0
+		}
+
+		switch rec.ChangeRecType {
+		case change.AppendRT, change.PrependRT, change.InsertAtRT:
+			inv.ChangeRecType = change.RemoveAtRT
+			inv.Prop = id.FromPos(pos)
+		case change.RemoveAtRT, change.ReplaceAtRT:
+			if rec.ChangeRecType == change.RemoveAtRT {
+				inv.ChangeRecType = change.InsertAtRT
+			}
+			if pos >= 0 && pos < n {
+				old, err := a.g.ContainerItem(rec.SubjectID, pos)
+				if err != nil {
+					return inv, errors.Wrapf(err, "Failed to get item at %d in container %d",
+						pos, rec.SubjectID)
+				}
+				setValue(&inv, old)
+			}
+		default:
+			return inv, badRec("record type cannot be inverted for container item")
+		}
+
+	case change.IDLitBin:
+		off, ok := rec.Prop.Pos()
+		if !ok {
+			return inv, badRec("binary literal record without offset")
+		}
+		data, found, err := a.g.BinLiteral(rec.SubjectID)
+		if err != nil {
+			return inv, errors.Wrapf(err, "Failed to get binary literal %d", rec.SubjectID)
+		}
+
+		switch rec.ChangeRecType {
+		case change.AppendRT:
+			off = int64(len(data))
+		case change.PrependRT:
+			off = 0
+		}
+
+		switch rec.ChangeRecType {
+		case change.AddRT:
+			inv.ChangeRecType = change.DelRT
+			inv.BinVal = append([]byte{}, rec.BinVal...)
+		case change.DelRT:
+			inv.ChangeRecType = change.AddRT
+			inv.Prop = id.FromPos(0)
+			inv.BinVal = append([]byte{}, data...)
+		case change.AppendRT, change.PrependRT, change.InsertAtRT:
+			if !found {
+				// The insertion makes the literal; undo by deleting it:
+				inv.ChangeRecType = change.DelRT
+				inv.Prop = id.FromPos(0)
+			} else {
+				inv.ChangeRecType = change.RemoveAtRT
+				inv.Prop = id.FromPos(off)
+			}
+			inv.BinVal = append([]byte{}, rec.BinVal...)
+		case change.RemoveAtRT:
+			inv.ChangeRecType = change.InsertAtRT
+			inv.BinVal = append([]byte{}, rec.BinVal...)
+		case change.ReplaceAtRT:
+			rangeEnd := off + int64(len(rec.BinVal))
+			if off >= 0 && rangeEnd <= int64(len(data)) {
+				inv.BinVal = append([]byte{}, data[off:rangeEnd]...)
+			}
+		default:
+			return inv, badRec("record type cannot be inverted for binary literal")
+		}
+
+	default:
+		return inv, badRec("unsupported record form")
+	}
+	return inv, nil
+}
+
+func setValue(rec *change.Rec, v Value) {
+	rec.ValueTypeID = v.ValueTypeID
+	rec.ObjectID = v.ObjectID
+	rec.LangTag = v.LangTag
+	rec.StringVal = v.StringVal
+}
+
+// linkReorderingPairs sets the old position of the 'InsertAtRT' record
+// of each reordering pair (same container and editing operation,
+// 'ReorderingRF' flag set on both records) to the position
+// of the pair's 'RemoveAtRT' record.
+func linkReorderingPairs(recs []change.Rec) {
+	type pairKey struct {
+		subjectID id.IntID
+		editOpCID id.IntID
+	}
+	removePos := make(map[pairKey]id.PosOrID)
+
+	for i := range recs {
+		rec := &recs[i]
+		if rec.Form != change.OrdContItem || rec.EditOpCID == id.NoID ||
+			rec.ChangeRecFlags&change.ReorderingRF == 0 {
+			continue
+		}
+		key := pairKey{subjectID: rec.SubjectID, editOpCID: rec.EditOpCID}
+		switch rec.ChangeRecType {
+		case change.RemoveAtRT:
+			removePos[key] = rec.Prop
+		case change.InsertAtRT:
+			if pos, ok := removePos[key]; ok {
+				rec.OldProp = pos
+			}
+		}
+	}
+}
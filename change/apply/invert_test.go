@@ -0,0 +1,200 @@
+package apply
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/changetest"
+	"github.com/gimpldo/ba-prototype-go/cstoremem"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+func TestInvert(t *testing.T) {
+	// Applied to the state made by newTestApplier.
+	tests := []struct {
+		name  string
+		build func(b *change.SetBuilder)
+	}{
+		{"triples", func(b *change.SetBuilder) {
+			b.AddTriple(1, 2, 4).DeleteTriple(1, 2, 3).AddLangString(1, 2, "en", "x")
+		}},
+		{"container items", func(b *change.SetBuilder) {
+			b.Container(10).Append(102).Prepend(99).RemoveAt(1).ReplaceAt(0, 98)
+		}},
+		{"new container", func(b *change.SetBuilder) {
+			b.Container(11).Append(110).Append(111).InsertAt(1, 112)
+		}},
+		{"move", func(b *change.SetBuilder) {
+			b.Container(10).Append(102).Move(0, 2, 100).Move(1, 0, 102)
+		}},
+		{"swap", func(b *change.SetBuilder) {
+			b.Container(10).Swap(0, 100, 1, 101)
+		}},
+		{"text", func(b *change.SetBuilder) {
+			b.TextLiteral(20).InsertText(0, "x").RemoveText(1, "ab").ReplaceText(0, "yz").AppendText("!")
+		}},
+		{"new literal", func(b *change.SetBuilder) {
+			b.TextLiteral(21).Create("hi").AppendText("!").InsertText(0, "<")
+		}},
+		{"literal made by insertion", func(b *change.SetBuilder) {
+			b.TextLiteral(21).AppendText("made")
+		}},
+		{"deleted literal", func(b *change.SetBuilder) {
+			b.TextLiteral(20).Delete()
+		}},
+		{"copy", func(b *change.SetBuilder) {
+			cid := b.TakeEditOpCID()
+			b.Put(change.Rec{Form: change.UsualTriple, ChangeRecType: change.TestRT,
+				SubjectID: 1, Prop: id.FromID(2), ObjectID: 3,
+				ChangeRecFlags: change.CopyingRF, EditOpCID: cid})
+			b.Put(change.Rec{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+				SubjectID: 4, Prop: id.FromID(2), ObjectID: 3,
+				ChangeRecFlags: change.CopyingRF, EditOpCID: cid})
+		}},
+		{"with records not changing the state", func(b *change.SetBuilder) {
+			b.Put(changetest.TripleRec(change.TestRT, 1, 2, 3)).DeleteTriple(1, 2, 3)
+			b.Meta().Triple(1, 5, 6)
+			b.Ident().Triple(1, 2, 3)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, g := newTestApplier(t)
+			before := stateOf(g)
+			set := changetest.BuildSet(t, 2, tt.build)
+
+			inverse, err := Invert(set, g)
+			if err != nil {
+				t.Fatalf("Invert failed: %v", err)
+			}
+			if after := stateOf(g); !reflect.DeepEqual(after, before) {
+				t.Fatalf("State changed by Invert:\n got  %+v\n want %+v", after, before)
+			}
+			for _, rec := range inverse.ChangeRecords {
+				if isNoOpType(rec.ChangeRecType) || rec.ChangeSetID != id.NoID {
+					t.Errorf("Unexpected record in inverse: %+v", rec)
+				}
+			}
+			inverse.ID = 3
+			err = change.ValidateSet(inverse)
+			if err != nil {
+				t.Errorf("Invalid inverse: %v\n%+v", err, inverse.ChangeRecords)
+			}
+
+			err = a.ApplySet(set)
+			if err != nil {
+				t.Fatalf("ApplySet failed: %v", err)
+			}
+			err = a.ApplySet(inverse)
+			if err != nil {
+				t.Fatalf("ApplySet of inverse failed: %v\n%+v", err, inverse.ChangeRecords)
+			}
+			if after := stateOf(g); !reflect.DeepEqual(after, before) {
+				t.Errorf("State not restored by inverse:\n got  %+v\n want %+v", after, before)
+			}
+		})
+	}
+}
+
+func TestInvertMoveOldPos(t *testing.T) {
+	_, g := newTestApplier(t)
+	set := changetest.BuildSet(t, 2, func(b *change.SetBuilder) {
+		b.Container(10).Move(0, 1, 100)
+	})
+
+	inverse, err := Invert(set, g)
+	if err != nil {
+		t.Fatalf("Invert failed: %v", err)
+	}
+	recs := inverse.ChangeRecords
+	if len(recs) != 2 || recs[0].ChangeRecType != change.RemoveAtRT ||
+		recs[1].ChangeRecType != change.InsertAtRT || recs[1].OldProp != recs[0].Prop ||
+		recs[0].EditOpCID != recs[1].EditOpCID || recs[1].ChangeRecFlags&change.ReorderingRF == 0 {
+		t.Errorf("Inverse of a move is not a reordering pair: %+v", recs)
+	}
+}
+
+func TestInvertFailure(t *testing.T) {
+	_, g := newTestApplier(t)
+	before := stateOf(g)
+
+	conflicting := &change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: []change.Rec{
+		changetest.TripleRec(change.DelRT, 1, 2, 3),
+		changetest.TripleRec(change.DelRT, 1, 2, 3),
+	}}
+	_, err := Invert(conflicting, g)
+	if ce, ok := err.(*ConflictError); !ok || ce.Kind != TripleAbsentCK || ce.RecIndex != 1 {
+		t.Errorf("Invert of conflicting changeset returned %v", err)
+	}
+
+	bad := &change.Set{SetInfo: change.SetInfo{ID: 2}, ChangeRecords: []change.Rec{
+		changetest.TripleRec(change.AddRT, 1, 2, 4),
+		changetest.TripleRec(change.ReplaceAtRT, 1, 2, 3),
+	}}
+	_, err = Invert(bad, g)
+	if be, ok := err.(*BadRecError); !ok || be.RecIndex != 1 {
+		t.Errorf("Invert of bad changeset returned %v", err)
+	}
+
+	if after := stateOf(g); !reflect.DeepEqual(after, before) {
+		t.Errorf("State changed by failed Invert:\n got  %+v\n want %+v", after, before)
+	}
+}
+
+func TestInvertStored(t *testing.T) {
+	store := cstoremem.New()
+	sets := []*change.Set{
+		changetest.BuildSet(t, 1, func(b *change.SetBuilder) {
+			b.AddTriple(1, 2, 3).Container(10).Append(100).Append(101)
+		}),
+		changetest.BuildSet(t, 2, func(b *change.SetBuilder) {
+			b.DeleteTriple(1, 2, 3).Container(10).Move(1, 0, 101)
+			b.TextLiteral(20).Create("abc")
+		}),
+		changetest.BuildSet(t, 3, func(b *change.SetBuilder) {
+			b.Container(10).RemoveAt(0)
+			b.TextLiteral(20).RemoveText(1, "b")
+		}),
+	}
+	sink, err := store.MakeCRecPushSink(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSink failed: %v", err)
+	}
+	for _, set := range sets {
+		err = change.PutSet(sink, set)
+		if err != nil {
+			t.Fatalf("Failed to put changeset %d: %v", set.ID, err)
+		}
+	}
+
+	for _, set := range sets {
+		inverse, err := InvertStored(store, set.ID)
+		if err != nil {
+			t.Fatalf("InvertStored(%d) failed: %v", set.ID, err)
+		}
+
+		g, want := NewMemGraph(), NewMemGraph()
+		err = Materialize(store, set.ID, g)
+		if err == nil {
+			err = Materialize(store, set.ID-1, want)
+		}
+		if err != nil {
+			t.Fatalf("Materialize failed: %v", err)
+		}
+		err = NewApplier(g).ApplySet(inverse)
+		if err != nil {
+			t.Fatalf("ApplySet of inverse of changeset %d failed: %v", set.ID, err)
+		}
+		if got := stateOf(g); !reflect.DeepEqual(got, stateOf(want)) {
+			t.Errorf("Inverse of changeset %d does not restore the state before it:\n got  %+v\n want %+v",
+				set.ID, got, stateOf(want))
+		}
+	}
+
+	_, err = InvertStored(store, 4)
+	if err == nil {
+		t.Errorf("InvertStored succeeded for a missing changeset")
+	}
+}
@@ -0,0 +1,173 @@
+// change/apply/memgraph.go: in-memory materialized Graph
+
+package apply
+
+import (
+	"sort"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// MemGraph = in-memory Graph implementation, not safe for concurrent use.
+type MemGraph struct {
+	triples    map[Triple]struct{}
+	containers map[id.IntID][]Value
+	binLits    map[id.IntID][]byte
+}
+
+// Explicitly check that MemGraph implements the Graph interface.
+var _ Graph = (*MemGraph)(nil)
+
+// NewMemGraph returns an empty in-memory graph.
+func NewMemGraph() *MemGraph {
+	return &MemGraph{
+		triples:    make(map[Triple]struct{}),
+		containers: make(map[id.IntID][]Value),
+		binLits:    make(map[id.IntID][]byte),
+	}
+}
+
+func (g *MemGraph) HasTriple(t Triple) (bool, error) {
+	_, found := g.triples[t]
+	return found, nil
+}
+
+func (g *MemGraph) AddTriple(t Triple) error {
+	g.triples[t] = struct{}{}
+	return nil
+}
+
+func (g *MemGraph) DelTriple(t Triple) error {
+	delete(g.triples, t)
+	return nil
+}
+
+func (g *MemGraph) ContainerLen(subjectID id.IntID) (int64, error) {
+	return int64(len(g.containers[subjectID])), nil
+}
+
+func (g *MemGraph) ContainerItem(subjectID id.IntID, pos int64) (Value, error) {
+	items := g.containers[subjectID]
+	if pos < 0 || pos >= int64(len(items)) {
+		return Value{}, errors.Errorf("Position %d out of range for container %d (length %d)",
+			pos, subjectID, len(items))
+	}
+	return items[pos], nil
+}
+
+func (g *MemGraph) InsertItem(subjectID id.IntID, pos int64, v Value) error {
+	items := g.containers[subjectID]
+	if pos < 0 || pos > int64(len(items)) {
+		return errors.Errorf("Insert position %d out of range for container %d (length %d)",
+			pos, subjectID, len(items))
+	}
+	items = append(items, Value{})
+	copy(items[pos+1:], items[pos:])
+	items[pos] = v
+	g.containers[subjectID] = items
+	return nil
+}
+
+func (g *MemGraph) RemoveItem(subjectID id.IntID, pos int64) error {
+	items := g.containers[subjectID]
+	if pos < 0 || pos >= int64(len(items)) {
+		return errors.Errorf("Remove position %d out of range for container %d (length %d)",
+			pos, subjectID, len(items))
+	}
+	if len(items) == 1 {
+		delete(g.containers, subjectID)
+		return nil
+	}
+	g.containers[subjectID] = append(items[:pos], items[pos+1:]...)
+	return nil
+}
+
+func (g *MemGraph) ReplaceItem(subjectID id.IntID, pos int64, v Value) error {
+	items := g.containers[subjectID]
+	if pos < 0 || pos >= int64(len(items)) {
+		return errors.Errorf("Replace position %d out of range for container %d (length %d)",
+			pos, subjectID, len(items))
+	}
+	items[pos] = v
+	return nil
+}
+
+func (g *MemGraph) BinLiteral(subjectID id.IntID) ([]byte, bool, error) {
+	data, found := g.binLits[subjectID]
+	return data, found, nil
+}
+
+func (g *MemGraph) SetBinLiteral(subjectID id.IntID, data []byte) error {
+	g.binLits[subjectID] = data
+	return nil
+}
+
+func (g *MemGraph) DelBinLiteral(subjectID id.IntID) error {
+	delete(g.binLits, subjectID)
+	return nil
+}
+
+// Triples returns all the triples, sorted (by subject, property, then value).
+func (g *MemGraph) Triples() []Triple {
+	triples := make([]Triple, 0, len(g.triples))
+	for t := range g.triples {
+		triples = append(triples, t)
+	}
+	sort.Slice(triples, func(i, j int) bool {
+		return tripleLess(&triples[i], &triples[j])
+	})
+	return triples
+}
+
+// Container returns a copy of the items of the given container
+// (nil if there are no items).
+func (g *MemGraph) Container(subjectID id.IntID) []Value {
+	items := g.containers[subjectID]
+	if len(items) == 0 {
+		return nil
+	}
+	return append([]Value(nil), items...)
+}
+
+// ContainerIDs returns the subject IDs of the non-empty containers, sorted.
+func (g *MemGraph) ContainerIDs() []id.IntID {
+	ids := make([]id.IntID, 0, len(g.containers))
+	for subjectID := range g.containers {
+		ids = append(ids, subjectID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// BinLiteralIDs returns the subject IDs of the binary literals, sorted.
+func (g *MemGraph) BinLiteralIDs() []id.IntID {
+	ids := make([]id.IntID, 0, len(g.binLits))
+	for subjectID := range g.binLits {
+		ids = append(ids, subjectID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func tripleLess(a, b *Triple) bool {
+	switch {
+	case a.SubjectID != b.SubjectID:
+		return a.SubjectID < b.SubjectID
+	case a.PropID != b.PropID:
+		return a.PropID < b.PropID
+	}
+	return valueLess(&a.Value, &b.Value)
+}
+
+func valueLess(a, b *Value) bool {
+	switch {
+	case a.ValueTypeID != b.ValueTypeID:
+		return a.ValueTypeID < b.ValueTypeID
+	case a.ObjectID != b.ObjectID:
+		return a.ObjectID < b.ObjectID
+	case a.LangTag != b.LangTag:
+		return a.LangTag < b.LangTag
+	}
+	return a.StringVal < b.StringVal
+}
@@ -0,0 +1,120 @@
+// change/apply/precond.go: evaluating 'TestRT' records (preconditions)
+
+package apply
+
+import (
+	"bytes"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// CheckPreconditions evaluates all the 'TestRT' records of the given
+// changeset against the current state of the Graph (nothing is changed):
+//
+//   - usual triple: the triple exists;
+//   - order-preserving container item: there is an item at the position
+//     and it has the record's value;
+//   - binary literal: the literal exists and its bytes at the offset
+//     are the record's 'BinVal' (empty 'BinVal' tests only existence).
+//
+// Returns *PreconditionError listing every failed test,
+// *BadRecError for a test record that cannot be evaluated,
+// nil if all the tests hold (or there are none).
+//
+func (a *Applier) CheckPreconditions(set *change.Set) error {
+	var failed []*ConflictError
+
+	for i := range set.ChangeRecords {
+		rec := &set.ChangeRecords[i]
+		if rec.ChangeRecType != change.TestRT {
+			continue
+		}
+
+		kind, err := a.evalTest(rec)
+		if err != nil {
+			if bad, ok := err.(*BadRecError); ok {
+				bad.RecIndex = i
+				bad.Rec = *rec
+			}
+			return err
+		}
+		if kind != 0 {
+			f := &ConflictError{Kind: kind, RecIndex: i, Rec: *rec}
+			if f.Rec.ChangeSetID == id.NoID {
+				f.Rec.ChangeSetID = set.ID
+			}
+			failed = append(failed, f)
+		}
+	}
+
+	if len(failed) != 0 {
+		return &PreconditionError{CSetID: set.ID, Failed: failed}
+	}
+	return nil
+}
+
+// evalTest returns the reason why the given test record does not hold,
+// or 0 if it holds.
+func (a *Applier) evalTest(rec *change.Rec) (ConflictKind, error) {
+	switch rec.Form {
+	case change.UsualTriple:
+		propID, ok := rec.Prop.ID()
+		if !ok {
+			return 0, &BadRecError{Descr: "usual triple with position instead of property"}
+		}
+		t := Triple{SubjectID: rec.SubjectID, PropID: propID, Value: ValueOf(rec)}
+		found, err := a.g.HasTriple(t)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to look up triple %+v", t)
+		}
+		if !found {
+			return TripleAbsentCK, nil
+		}
+
+	case change.OrdContItem:
+		pos, ok := rec.Prop.Pos()
+		if !ok {
+			return 0, &BadRecError{Descr: "container item without position"}
+		}
+		n, err := a.g.ContainerLen(rec.SubjectID)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to get length of container %d", rec.SubjectID)
+		}
+		if pos < 0 || pos >= n {
+			return PosOutOfRangeCK, nil
+		}
+		item, err := a.g.ContainerItem(rec.SubjectID, pos)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to get item at %d in container %d", pos, rec.SubjectID)
+		}
+		if item != ValueOf(rec) {
+			return ItemMismatchCK, nil
+		}
+
+	case change.IDLitBin:
+		off, ok := rec.Prop.Pos()
+		if !ok {
+			return 0, &BadRecError{Descr: "binary literal record without offset"}
+		}
+		data, found, err := a.g.BinLiteral(rec.SubjectID)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to get binary literal %d", rec.SubjectID)
+		}
+		if !found {
+			return BinLiteralAbsentCK, nil
+		}
+		rangeEnd := off + int64(len(rec.BinVal))
+		if off < 0 || rangeEnd > int64(len(data)) {
+			return PosOutOfRangeCK, nil
+		}
+		if !bytes.Equal(rec.BinVal, data[off:rangeEnd]) {
+			return BinMismatchCK, nil
+		}
+
+	default:
+		return 0, &BadRecError{Descr: "unsupported record form"}
+	}
+	return 0, nil
+}
@@ -0,0 +1,429 @@
+// change/apply/squash.go: composing (squashing) consecutive changesets
+
+package apply
+
+import (
+	"bytes"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// Squasher composes consecutive changesets into one equivalent changeset:
+// applying the result gives the same state as applying the changesets
+// one after the other.
+//
+// The change records must be put in changeset order (as given by
+// a pull source); records that cancel or supersede each other are dropped:
+//
+//   - usual triple added then deleted, or deleted then added again;
+//   - container item inserted then removed at the same position
+//     (which merges consecutive moves = 'ReorderingRF' pairs of the same item);
+//   - successive 'ReplaceAtRT' at the same container position
+//     (only the last one is kept; a replacement of an inserted item
+//     becomes part of the insertion);
+//   - binary literal added then changed: the changes are folded into
+//     the 'AddRT' record; added then deleted: all records dropped;
+//     bytes inserted then the same bytes removed at the same offset.
+//
+// Only the 'TestRT' records of the first changeset are kept (the others
+// refer to intermediate states). Editing operation IDs ('EditOpCID')
+// are renumbered, being changeset-scoped; an editing operation left
+// incomplete by the dropped records (a move or swap with one of its
+// records cancelled) loses its operation flags and 'EditOpCID',
+// so the result passes change.ValidateSet.
+//
+type Squasher struct {
+	recs    []change.Rec
+	dropped []bool
+
+	firstCSetID id.IntID
+	lastCSetID  id.IntID
+
+	// Indexes (in 'recs') of the records kept so far, for each
+	// triple, container or binary literal, in the order they were put
+	tripleRecs map[Triple][]int
+	contRecs   map[id.IntID][]int
+	binLitRecs map[id.IntID][]int
+
+	editOpCIDs  map[editOpKey]id.IntID
+	editOpAlias map[id.IntID]id.IntID
+}
+
+type editOpKey struct {
+	csetID    id.IntID
+	editOpCID id.IntID
+}
+
+// Explicitly check that Squasher can be used as push sink.
+var _ change.RecPushSink = (*Squasher)(nil)
+
+// NewSquasher returns a Squasher with no change records put yet.
+func NewSquasher() *Squasher {
+	return &Squasher{
+		tripleRecs: make(map[Triple][]int),
+		contRecs:   make(map[id.IntID][]int),
+		binLitRecs: make(map[id.IntID][]int),
+
+		editOpCIDs:  make(map[editOpKey]id.IntID),
+		editOpAlias: make(map[id.IntID]id.IntID),
+	}
+}
+
+// Squash composes the given changesets (in order) into one changeset;
+// see Squasher. The result has the first changeset's parents and no
+// other header fields set; its records have no changeset ID.
+func Squash(sets ...*change.Set) (*change.Set, error) {
+	s := NewSquasher()
+	for _, set := range sets {
+		for _, rec := range set.ChangeRecords {
+			if rec.ChangeSetID == id.NoID {
+				rec.ChangeSetID = set.ID
+			}
+			err := s.PutChangeRec(rec)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	squashed := s.Result()
+	if len(sets) != 0 {
+		squashed.ParentIDs = append([]id.IntID(nil), sets[0].ParentIDs...)
+	}
+	return squashed, nil
+}
+
+// SquashStored composes the store's changesets with IDs
+// from 'firstCSetID' to 'lastCSetID' (inclusive); see Squash.
+func SquashStored(dop cstore.ReadingDop, firstCSetID, lastCSetID id.IntID) (*change.Set, error) {
+	src, err := dop.MakeCSetRangePullSourceCloser(firstCSetID, lastCSetID)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	s := NewSquasher()
+	for {
+		rec, gotRec, err := src.GetNextChangeRec()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read changesets %d..%d",
+				firstCSetID, lastCSetID)
+		}
+		if !gotRec {
+			break
+		}
+		err = s.PutChangeRec(rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	squashed := s.Result()
+	if s.firstCSetID != id.NoID {
+		info, _, err := dop.ReadCSetInfo(s.firstCSetID)
+		if err != nil {
+			return nil, err
+		}
+		squashed.ParentIDs = info.ParentIDs
+	}
+	return squashed, nil
+}
+
+func (s *Squasher) PutChangeRec(rec change.Rec) error {
+	if rec.ChangeSetID == id.NoID {
+		return errors.Errorf("Change record without changeset ID: %+v", rec)
+	}
+	if rec.ChangeSetID < s.lastCSetID {
+		return &OrderError{LastCSetID: s.lastCSetID, CSetID: rec.ChangeSetID}
+	}
+	if s.firstCSetID == id.NoID {
+		s.firstCSetID = rec.ChangeSetID
+	}
+	s.lastCSetID = rec.ChangeSetID
+
+	if rec.ChangeRecType == change.TestRT && rec.ChangeSetID != s.firstCSetID {
+		return nil
+	}
+	rec.EditOpCID = s.renumberEditOp(rec.ChangeSetID, rec.EditOpCID)
+
+	if isNoOpType(rec.ChangeRecType) {
+		s.keep(rec)
+		return nil
+	}
+
+	switch rec.Form {
+	case change.UsualTriple:
+		return s.putTripleRec(rec)
+	case change.OrdContItem:
+		return s.putOrdContRec(rec)
+	case change.IDLitBin:
+		return s.putBinRec(rec)
+	default:
+		return &BadRecError{RecIndex: -1, Rec: rec, Descr: "unsupported record form"}
+	}
+}
+
+// Result returns the composed changeset (without header fields);
+// the Squasher should not be used afterwards.
+func (s *Squasher) Result() *change.Set {
+	squashed := &change.Set{}
+	for i, rec := range s.recs {
+		if s.dropped[i] {
+			continue
+		}
+		rec.ChangeSetID = id.NoID
+		rec.EditOpCID = s.resolveEditOp(rec.EditOpCID)
+		squashed.ChangeRecords = append(squashed.ChangeRecords, rec)
+	}
+	UnlinkIncompleteEditOps(squashed.ChangeRecords)
+	linkReorderingPairs(squashed.ChangeRecords)
+	return squashed
+}
+
+// UnlinkIncompleteEditOps clears the operation flags and the 'EditOpCID'
+// of the records of each editing operation that is no longer complete
+// (see change.CheckFlagGroups; a reordering pair must also be consecutive
+// among the records of its container), and the old position of
+// the 'InsertAtRT' records of broken reordering pairs: the remaining
+// records are still right one by one, but no longer an operation
+// (for changesets made from parts of other changesets: squashed,
+// inverted or merged).
+func UnlinkIncompleteEditOps(recs []change.Rec) {
+	for _, group := range change.GroupByEditOp(recs) {
+		groupRecs := make([]change.Rec, len(group.RecIndexes))
+		for j, i := range group.RecIndexes {
+			groupRecs[j] = recs[i]
+		}
+		err := change.CheckFlagGroups(&change.Set{ChangeRecords: groupRecs})
+		if err == nil && !isSplitReorderingPair(recs, group.RecIndexes) {
+			continue
+		}
+
+		for _, i := range group.RecIndexes {
+			rec := &recs[i]
+			if rec.ChangeRecFlags&change.OperationRFs == 0 {
+				continue // not an operation checked by CheckFlagGroups
+			}
+			if rec.ChangeRecFlags&change.ReorderingRF != 0 {
+				rec.OldProp = 0
+			}
+			rec.ChangeRecFlags &^= change.OperationRFs
+			rec.EditOpCID = id.NoID
+		}
+	}
+}
+
+// isSplitReorderingPair tells if the given records (a complete
+// reordering pair, or another operation) are a reordering pair
+// with other records of the same container between them.
+func isSplitReorderingPair(recs []change.Rec, indexes []int) bool {
+	if len(indexes) != 2 || recs[indexes[0]].ChangeRecFlags&change.ReorderingRF == 0 {
+		return false
+	}
+	remove := &recs[indexes[0]]
+	for i := indexes[0] + 1; i < indexes[1]; i++ {
+		if recs[i].Form == change.OrdContItem && recs[i].SubjectID == remove.SubjectID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Squasher) keep(rec change.Rec) int {
+	s.recs = append(s.recs, rec)
+	s.dropped = append(s.dropped, false)
+	return len(s.recs) - 1
+}
+
+func (s *Squasher) renumberEditOp(csetID, editOpCID id.IntID) id.IntID {
+	if editOpCID == id.NoID {
+		return id.NoID
+	}
+	key := editOpKey{csetID: csetID, editOpCID: editOpCID}
+	newCID, ok := s.editOpCIDs[key]
+	if !ok {
+		newCID = id.IntID(len(s.editOpCIDs) + 1)
+		s.editOpCIDs[key] = newCID
+	}
+	return newCID
+}
+
+func (s *Squasher) resolveEditOp(editOpCID id.IntID) id.IntID {
+	for {
+		alias, ok := s.editOpAlias[editOpCID]
+		if !ok {
+			return editOpCID
+		}
+		editOpCID = alias
+	}
+}
+
+func (s *Squasher) putTripleRec(rec change.Rec) error {
+	propID, ok := rec.Prop.ID()
+	if !ok {
+		return &BadRecError{RecIndex: -1, Rec: rec, Descr: "usual triple with position instead of property"}
+	}
+	t := Triple{SubjectID: rec.SubjectID, PropID: propID, Value: ValueOf(&rec)}
+
+	kept := s.tripleRecs[t]
+	if n := len(kept); n != 0 {
+		last := &s.recs[kept[n-1]]
+		if (last.ChangeRecType == change.AddRT && rec.ChangeRecType == change.DelRT) ||
+			(last.ChangeRecType == change.DelRT && rec.ChangeRecType == change.AddRT) {
+			s.dropped[kept[n-1]] = true
+			s.tripleRecs[t] = kept[:n-1]
+			return nil
+		}
+	}
+	s.tripleRecs[t] = append(kept, s.keep(rec))
+	return nil
+}
+
+func (s *Squasher) putOrdContRec(rec change.Rec) error {
+	pos, ok := rec.Prop.Pos()
+	if !ok {
+		return &BadRecError{RecIndex: -1, Rec: rec, Descr: "container item without position"}
+	}
+	if rec.ChangeRecType == change.PrependRT {
+		pos = 0
+	}
+
+	kept := s.contRecs[rec.SubjectID]
+	for n := len(kept); n != 0; n = len(kept) {
+		last := &s.recs[kept[n-1]]
+		lastPos, _ := last.Prop.Pos()
+		if last.ChangeRecType == change.PrependRT {
+			lastPos = 0
+		}
+		if last.ChangeRecType == change.AppendRT || lastPos != pos {
+			break
+		}
+		lastInserts := last.ChangeRecType == change.InsertAtRT ||
+			last.ChangeRecType == change.PrependRT
+
+		switch {
+		case lastInserts && rec.ChangeRecType == change.RemoveAtRT:
+			// Inserted then removed: both cancelled; if both are part
+			// of moves, the two moves become one (same editing operation).
+			if last.ChangeRecFlags&change.ReorderingRF != 0 &&
+				rec.ChangeRecFlags&change.ReorderingRF != 0 &&
+				last.EditOpCID != id.NoID && rec.EditOpCID != id.NoID &&
+				last.EditOpCID != rec.EditOpCID {
+				s.editOpAlias[rec.EditOpCID] = s.resolveEditOp(last.EditOpCID)
+			}
+			s.dropped[kept[n-1]] = true
+			s.contRecs[rec.SubjectID] = kept[:n-1]
+			return nil
+
+		case lastInserts && rec.ChangeRecType == change.ReplaceAtRT &&
+			last.ChangeRecFlags&change.ReorderingRF == 0:
+			// (not into the insertion of a move: it would no longer
+			// move the same item)
+			setValue(last, ValueOf(&rec))
+			return nil
+
+		case last.ChangeRecType == change.ReplaceAtRT &&
+			(rec.ChangeRecType == change.ReplaceAtRT || rec.ChangeRecType == change.RemoveAtRT):
+			// The replaced value is superseded; for a removal,
+			// the original item (unknown here) gets removed:
+			s.dropped[kept[n-1]] = true
+			kept = kept[:n-1]
+			if rec.ChangeRecType == change.RemoveAtRT {
+				setValue(&rec, Value{})
+			}
+			continue
+		}
+		break
+	}
+	s.contRecs[rec.SubjectID] = append(kept, s.keep(rec))
+	return nil
+}
+
+func (s *Squasher) putBinRec(rec change.Rec) error {
+	off, ok := rec.Prop.Pos()
+	if !ok {
+		return &BadRecError{RecIndex: -1, Rec: rec, Descr: "binary literal record without offset"}
+	}
+
+	kept := s.binLitRecs[rec.SubjectID]
+	if n := len(kept); n != 0 {
+		first := &s.recs[kept[0]]
+		last := &s.recs[kept[n-1]]
+
+		switch {
+		case n == 1 && first.ChangeRecType == change.AddRT:
+			// The whole literal is known: fold the change into it.
+			if rec.ChangeRecType == change.DelRT {
+				s.dropped[kept[0]] = true
+				s.binLitRecs[rec.SubjectID] = kept[:0]
+				return nil
+			}
+			data, err := foldBinRec(first.BinVal, off, &rec)
+			if err != nil {
+				return err
+			}
+			first.BinVal = data
+			return nil
+
+		case rec.ChangeRecType == change.RemoveAtRT && last.ChangeRecType == change.InsertAtRT &&
+			last.Prop == rec.Prop && bytes.Equal(last.BinVal, rec.BinVal):
+			s.dropped[kept[n-1]] = true
+			s.binLitRecs[rec.SubjectID] = kept[:n-1]
+			return nil
+
+		case rec.ChangeRecType == change.ReplaceAtRT && last.ChangeRecType == change.ReplaceAtRT &&
+			last.Prop == rec.Prop && len(last.BinVal) == len(rec.BinVal):
+			s.dropped[kept[n-1]] = true
+			kept = kept[:n-1]
+		}
+	}
+	s.binLitRecs[rec.SubjectID] = append(kept, s.keep(rec))
+	return nil
+}
+
+// foldBinRec returns the binary data resulting from applying
+// the given record (not 'AddRT' or 'DelRT') to 'data'.
+func foldBinRec(data []byte, off int64, rec *change.Rec) ([]byte, error) {
+	n := int64(len(data))
+	rangeEnd := off + int64(len(rec.BinVal))
+
+	switch rec.ChangeRecType {
+	case change.AppendRT:
+		off = n
+	case change.PrependRT:
+		off = 0
+	}
+
+	switch rec.ChangeRecType {
+	case change.AppendRT, change.PrependRT, change.InsertAtRT:
+		if off < 0 || off > n {
+			return nil, &ConflictError{Kind: PosOutOfRangeCK, RecIndex: -1, Rec: *rec}
+		}
+		folded := make([]byte, 0, len(data)+len(rec.BinVal))
+		folded = append(folded, data[:off]...)
+		folded = append(folded, rec.BinVal...)
+		return append(folded, data[off:]...), nil
+	case change.RemoveAtRT, change.ReplaceAtRT:
+		if off < 0 || rangeEnd > n {
+			return nil, &ConflictError{Kind: PosOutOfRangeCK, RecIndex: -1, Rec: *rec}
+		}
+		if rec.ChangeRecType == change.ReplaceAtRT {
+			folded := append([]byte{}, data...)
+			copy(folded[off:], rec.BinVal)
+			return folded, nil
+		}
+		if !bytes.Equal(rec.BinVal, data[off:rangeEnd]) {
+			return nil, &ConflictError{Kind: BinMismatchCK, RecIndex: -1, Rec: *rec}
+		}
+		folded := make([]byte, 0, len(data)-len(rec.BinVal))
+		folded = append(folded, data[:off]...)
+		return append(folded, data[rangeEnd:]...), nil
+	case change.AddRT:
+		return nil, &ConflictError{Kind: BinLiteralExistsCK, RecIndex: -1, Rec: *rec}
+	default:
+		return nil, &BadRecError{RecIndex: -1, Rec: *rec, Descr: "record type not applicable to binary literal"}
+	}
+}
@@ -0,0 +1,215 @@
+package apply
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/changetest"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// checkSquash checks that the squashed changesets give the same state
+// as the changesets applied one after the other (to the state made
+// by newTestApplier), and that the result is a valid changeset;
+// returns the result.
+func checkSquash(t *testing.T, sets []*change.Set) *change.Set {
+	t.Helper()
+
+	a, g := newTestApplier(t)
+	for _, set := range sets {
+		err := a.ApplySet(set)
+		if err != nil {
+			t.Fatalf("ApplySet(%d) failed: %v", set.ID, err)
+		}
+	}
+	want := stateOf(g)
+
+	squashed, err := Squash(sets...)
+	if err != nil {
+		t.Fatalf("Squash failed: %v", err)
+	}
+	squashed.ID = 1000
+	err = change.ValidateSet(squashed)
+	if err != nil {
+		t.Errorf("Invalid squashed changeset: %v\n%+v", err, squashed.ChangeRecords)
+	}
+
+	a, g = newTestApplier(t)
+	err = a.ApplySet(squashed)
+	if err != nil {
+		t.Fatalf("ApplySet of squashed changeset failed: %v\n%+v", err, squashed.ChangeRecords)
+	}
+	if got := stateOf(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("Squashed changeset gives another state:\n got  %+v\n want %+v\n%+v",
+			got, want, squashed.ChangeRecords)
+	}
+	return squashed
+}
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		name   string
+		builds []func(b *change.SetBuilder)
+		nRecs  int // in the result
+	}{
+		{"triple added then deleted", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.AddTriple(1, 2, 4) },
+			func(b *change.SetBuilder) { b.DeleteTriple(1, 2, 4).DeleteTriple(1, 2, 3) },
+		}, 1},
+		{"consecutive moves", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).Move(0, 1, 100) },
+			func(b *change.SetBuilder) { b.Container(10).Move(1, 0, 100) },
+		}, 2},
+		{"move then removal", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).Move(0, 1, 100) },
+			func(b *change.SetBuilder) { b.Container(10).RemoveAt(1) },
+		}, 1},
+		{"insertion then move", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).InsertAt(1, 102) },
+			func(b *change.SetBuilder) { b.Container(10).Move(1, 2, 102) },
+		}, 1},
+		{"move then replacement", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).Move(0, 1, 100) },
+			func(b *change.SetBuilder) { b.Container(10).ReplaceAt(1, 102) },
+		}, 3},
+		{"swap then replacement", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).Swap(0, 100, 1, 101) },
+			func(b *change.SetBuilder) { b.Container(10).ReplaceAt(1, 102) },
+		}, 2},
+		{"insertion then replacement", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.Container(10).InsertAt(1, 102) },
+			func(b *change.SetBuilder) { b.Container(10).ReplaceAt(1, 103) },
+		}, 1},
+		{"literal made then changed", []func(b *change.SetBuilder){
+			func(b *change.SetBuilder) { b.TextLiteral(21).Create("hello") },
+			func(b *change.SetBuilder) { b.TextLiteral(21).AppendText("!").RemoveText(0, "h") },
+		}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sets []*change.Set
+			for i, build := range tt.builds {
+				sets = append(sets, changetest.BuildSet(t, id.IntID(2+i), build))
+			}
+			squashed := checkSquash(t, sets)
+			if len(squashed.ChangeRecords) != tt.nRecs {
+				t.Errorf("Squashed changeset has %d records, want %d:\n%+v",
+					len(squashed.ChangeRecords), tt.nRecs, squashed.ChangeRecords)
+			}
+		})
+	}
+}
+
+// Property test: random valid changesets, squashed.
+func TestSquashRandom(t *testing.T) {
+	for seed := int64(1); seed <= 1000; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		_, cur := newTestApplier(t)
+
+		var sets []*change.Set
+		nSets := 1 + r.Intn(4)
+		for csetID := id.IntID(2); csetID < id.IntID(2+nSets); csetID++ {
+			set := &change.Set{SetInfo: change.SetInfo{ID: csetID}}
+			b := change.NewSetBuilder(set)
+			for nOps := 1 + r.Intn(3); nOps > 0; nOps-- {
+				n := len(set.ChangeRecords)
+				randomOp(r, b, cur)
+				if b.Err() != nil {
+					t.Fatalf("Seed %d: failed to build changeset %d: %v", seed, csetID, b.Err())
+				}
+				// Keep the state up to date for the next operation:
+				err := NewApplier(cur).ApplySet(&change.Set{SetInfo: set.SetInfo,
+					ChangeRecords: set.ChangeRecords[n:]})
+				if err != nil {
+					t.Fatalf("Seed %d: bad random operation: %v", seed, err)
+				}
+			}
+			sets = append(sets, set)
+		}
+
+		t.Run("", func(t *testing.T) {
+			t.Logf("Seed %d", seed)
+			checkSquash(t, sets)
+		})
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+// randomOp puts the records of a random operation applicable to
+// the given state (triple 1 --2--> 3..6, containers 10 and 11,
+// text literals 20 and 21).
+func randomOp(r *rand.Rand, b *change.SetBuilder, g *MemGraph) {
+	newItem := id.IntID(200 + r.Intn(1000))
+
+	if r.Intn(5) == 0 {
+		objectID := id.IntID(3 + r.Intn(4))
+		if found, _ := g.HasTriple(Triple{SubjectID: 1, PropID: 2, Value: Value{ObjectID: objectID}}); found {
+			b.DeleteTriple(1, 2, objectID)
+		} else {
+			b.AddTriple(1, 2, objectID)
+		}
+		return
+	}
+
+	if r.Intn(3) == 0 {
+		subjectID := id.IntID(20 + r.Intn(2))
+		tb := b.TextLiteral(subjectID)
+		data, found, _ := g.BinLiteral(subjectID)
+		n := len(data)
+		switch {
+		case !found:
+			tb.Create(randomText(r))
+		case r.Intn(8) == 0:
+			tb.Delete()
+		case n == 0 || r.Intn(3) == 0:
+			tb.InsertText(int64(r.Intn(n+1)), randomText(r))
+		case r.Intn(2) == 0:
+			off := r.Intn(n)
+			tb.RemoveText(int64(off), string(data[off:off+1+r.Intn(n-off)]))
+		default:
+			off := r.Intn(n)
+			tb.ReplaceText(int64(off), randomText(r)[:1])
+		}
+		return
+	}
+
+	subjectID := id.IntID(10 + r.Intn(2))
+	cb := b.Container(subjectID)
+	items := g.Container(subjectID)
+	n := len(items)
+	switch op := r.Intn(7); {
+	case n == 0 || op == 0:
+		cb.InsertAt(int64(r.Intn(n+1)), newItem)
+	case op == 1:
+		cb.Append(newItem)
+	case op == 2:
+		cb.Prepend(newItem)
+	case op == 3:
+		cb.RemoveAt(int64(r.Intn(n)))
+	case op == 4:
+		cb.ReplaceAt(int64(r.Intn(n)), newItem)
+	case op == 5 || n == 1:
+		from := r.Intn(n)
+		cb.Move(int64(from), int64(r.Intn(n)), items[from].ObjectID)
+	default:
+		pos1, pos2 := r.Intn(n), r.Intn(n-1)
+		if pos2 >= pos1 {
+			pos2++
+		}
+		cb.Swap(int64(pos1), items[pos1].ObjectID, int64(pos2), items[pos2].ObjectID)
+	}
+}
+
+func randomText(r *rand.Rand) string {
+	const letters = "abcxyz"
+	text := make([]byte, 1+r.Intn(3))
+	for i := range text {
+		text[i] = letters[r.Intn(len(letters))]
+	}
+	return string(text)
+}
@@ -0,0 +1,185 @@
+// Package apply materializes the current state (triples, order-preserving
+// containers, binary literals) by applying changesets, in changeset order,
+// to a Graph.
+//
+// Changeset-level operations derived from the same semantics are here too:
+// inversion (undo), squashing of consecutive changesets.
+//
+// Each changeset is applied atomically: when a change record cannot be
+// applied (conflict with the current state, or unsupported record),
+// the records already applied from the same changeset are undone.
+//
+// The 'TestRT' records of a changeset are preconditions, all evaluated
+// against the state before the changeset; if any fails, the whole
+// changeset is rejected (optimistic concurrency control).
+//
+package apply
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// Value = the object part of a triple, or an order-preserving container item;
+// comparable (usable as map key).
+//
+// Exactly as in the change records: ObjectID for an IRI or blank node
+// (ValueTypeID = id.NoID), otherwise a literal with ValueTypeID as datatype
+// (LangTag only for datatype id.RDFLangStringID).
+type Value struct {
+	ValueTypeID id.IntID
+	ObjectID    id.IntID
+
+	LangTag   string
+	StringVal string
+}
+
+// Triple = (Subject, Property, Object/Value); comparable (usable as map key).
+type Triple struct {
+	SubjectID id.IntID
+	PropID    id.IntID
+
+	Value
+}
+
+// ValueOf returns the value carried by the given change record.
+func ValueOf(rec *change.Rec) Value {
+	return Value{
+		ValueTypeID: rec.ValueTypeID,
+		ObjectID:    rec.ObjectID,
+		LangTag:     rec.LangTag,
+		StringVal:   rec.StringVal,
+	}
+}
+
+// Graph = materialized state that changesets can be applied to.
+//
+// The Applier validates positions, offsets and presence before calling
+// the mutating methods, so implementations may assume valid arguments
+// (but are still free to check and return errors).
+//
+type Graph interface {
+	HasTriple(t Triple) (bool, error)
+	AddTriple(t Triple) error
+	DelTriple(t Triple) error
+
+	// Order-preserving containers (positions from 0 to length-1);
+	// a container that has no items is the same as a missing one.
+	ContainerLen(subjectID id.IntID) (int64, error)
+	ContainerItem(subjectID id.IntID, pos int64) (Value, error)
+	InsertItem(subjectID id.IntID, pos int64, v Value) error
+	RemoveItem(subjectID id.IntID, pos int64) error
+	ReplaceItem(subjectID id.IntID, pos int64, v Value) error
+
+	// Binary literals (identified by subject ID);
+	// the slices passed to and returned by these methods
+	// must not be modified by the receiver.
+	BinLiteral(subjectID id.IntID) (data []byte, found bool, err error)
+	SetBinLiteral(subjectID id.IntID, data []byte) error
+	DelBinLiteral(subjectID id.IntID) error
+}
+
+// ConflictKind tells why a change record does not fit the current state.
+type ConflictKind int
+
+// The trailing 'CK' in constant names stands for "Conflict Kind".
+const (
+	TripleExistsCK ConflictKind = iota + 1
+	TripleAbsentCK
+
+	PosOutOfRangeCK
+	ItemMismatchCK
+
+	BinLiteralExistsCK
+	BinLiteralAbsentCK
+	BinMismatchCK
+)
+
+var conflictKindNames = [...]string{
+	TripleExistsCK: "triple already exists",
+	TripleAbsentCK: "triple does not exist",
+
+	PosOutOfRangeCK: "position out of range",
+	ItemMismatchCK:  "container item differs",
+
+	BinLiteralExistsCK: "binary literal already exists",
+	BinLiteralAbsentCK: "binary literal does not exist",
+	BinMismatchCK:      "binary literal bytes differ",
+}
+
+func (k ConflictKind) String() string {
+	if k > 0 && int(k) < len(conflictKindNames) {
+		return conflictKindNames[k]
+	}
+	return fmt.Sprintf("ConflictKind(%d)", int(k))
+}
+
+// ConflictError = a change record could not be applied
+// because it does not fit the current state.
+type ConflictError struct {
+	Kind ConflictKind
+
+	// Index of the change record in its changeset
+	// (in the order the records were given to the Applier),
+	// -1 if not known
+	RecIndex int
+	Rec      change.Rec
+
+	// Extra information, may be empty
+	Detail string
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("Conflict applying changeset %d, record #%d (subject %d): %v",
+		e.Rec.ChangeSetID, e.RecIndex, e.Rec.SubjectID, e.Kind)
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+// BadRecError = a change record that cannot be applied whatever the state
+// (record type not applicable to the record form, missing position, ...).
+type BadRecError struct {
+	RecIndex int // -1 if not known
+	Rec      change.Rec
+
+	Descr string
+}
+
+func (e *BadRecError) Error() string {
+	return fmt.Sprintf("Cannot apply changeset %d, record #%d: %s: %+v",
+		e.Rec.ChangeSetID, e.RecIndex, e.Descr, e.Rec)
+}
+
+// OrderError = change records pulled out of changeset order
+// (or a changeset already applied by the same Applier).
+type OrderError struct {
+	LastCSetID id.IntID
+	CSetID     id.IntID
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("Changeset %d out of order: not after changeset %d",
+		e.CSetID, e.LastCSetID)
+}
+
+// PreconditionError = the changeset was rejected (nothing applied)
+// because some of its 'TestRT' records do not hold in the current state.
+type PreconditionError struct {
+	CSetID id.IntID
+
+	// All the failed tests, in record order
+	Failed []*ConflictError
+}
+
+func (e *PreconditionError) Error() string {
+	msg := fmt.Sprintf("Changeset %d rejected: %d failed precondition(s)", e.CSetID, len(e.Failed))
+	for _, f := range e.Failed {
+		msg += "; record #" + strconv.Itoa(f.RecIndex) + ": " + f.Kind.String()
+	}
+	return msg
+}
@@ -0,0 +1,302 @@
+package change
+
+import (
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// SetBuilder builds the change records of one changeset, filling in
+// the record form, type, position or property, value type, flags and
+// editing operation IDs; the records are appended to a Set, or put
+// into a push sink.
+//
+// The methods can be chained; the first error (a bad position, or
+// from the push sink) is kept and makes the following calls do nothing,
+// so it can be checked once at the end (see Err):
+//
+//   b := change.NewSetBuilder(set)
+//   b.AddTriple(s, p, o).AddLangString(s, label, "en", "Hello")
+//   b.Container(list).Append(item).Move(0, 2, first)
+//   b.Meta().LangString(csetNode, comment, "en", "Fix the list order")
+//   err := b.Err()
+//
+type SetBuilder struct {
+	set  *Set
+	sink RecPushSink
+
+	csetID  id.IntID
+	editOps EditOpCIDPool
+
+	// Context ID for the following records (see InContext)
+	contextID id.IntID
+
+	err error
+}
+
+// NewSetBuilder makes a builder appending records to the given changeset
+// (the editing operation IDs continue after those already used there).
+func NewSetBuilder(set *Set) *SetBuilder {
+	b := &SetBuilder{set: set}
+	b.editOps.Attach(set)
+	return b
+}
+
+// NewSinkSetBuilder makes a builder putting records into the given sink,
+// with the given changeset ID; the header is put first, if the sink
+// accepts headers (as done by PutSet).
+func NewSinkSetBuilder(sink RecPushSink, info SetInfo) (*SetBuilder, error) {
+	if infoSink, ok := sink.(SetInfoPushSink); ok {
+		err := infoSink.PutChangeSetInfo(info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &SetBuilder{sink: sink, csetID: info.ID}, nil
+}
+
+// Err returns the first error met while building (nil if none).
+func (b *SetBuilder) Err() error {
+	return b.err
+}
+
+// Put adds the given record as it is (except for the changeset ID,
+// and the context ID if not set, see InContext), for the cases
+// not covered by the other methods.
+func (b *SetBuilder) Put(rec Rec) *SetBuilder {
+	if b.err != nil {
+		return b
+	}
+	if rec.ChangeRecContextID == id.NoID && rec.ChangeRecType != ContextRT {
+		rec.ChangeRecContextID = b.contextID
+	}
+	if b.sink == nil {
+		rec.ChangeSetID = id.NoID // the changeset's, see PutSet
+		b.set.ChangeRecords = append(b.set.ChangeRecords, rec)
+		return b
+	}
+	rec.ChangeSetID = b.csetID
+	err := b.sink.PutChangeRec(rec)
+	if err != nil {
+		b.err = errors.Wrapf(err, "Changeset builder failed to put record")
+	}
+	return b
+}
+
+// TakeEditOpCID returns a new editing operation ID for this changeset,
+// for records put with Put.
+func (b *SetBuilder) TakeEditOpCID() id.IntID {
+	return b.editOps.Take()
+}
+
+func (b *SetBuilder) fail(format string, args ...interface{}) *SetBuilder {
+	if b.err == nil {
+		b.err = errors.Errorf(format, args...)
+	}
+	return b
+}
+
+func (b *SetBuilder) putTriple(recType RecTypeCode, subjectID, propID id.IntID, v Rec) *SetBuilder {
+	if propID == id.NoID {
+		return b.fail("Triple without property (subject %d)", subjectID)
+	}
+	v.Form = UsualTriple
+	v.ChangeRecType = recType
+	v.SubjectID = subjectID
+	v.Prop = id.FromID(propID)
+	return b.Put(v)
+}
+
+// AddTriple adds a triple with an IRI or blank node as object.
+func (b *SetBuilder) AddTriple(subjectID, propID, objectID id.IntID) *SetBuilder {
+	return b.putTriple(AddRT, subjectID, propID, Rec{ObjectID: objectID})
+}
+
+// DeleteTriple deletes a triple with an IRI or blank node as object.
+func (b *SetBuilder) DeleteTriple(subjectID, propID, objectID id.IntID) *SetBuilder {
+	return b.putTriple(DelRT, subjectID, propID, Rec{ObjectID: objectID})
+}
+
+// AddLangString adds a triple with a language-tagged string as object.
+func (b *SetBuilder) AddLangString(subjectID, propID id.IntID, langTag, text string) *SetBuilder {
+	return b.putTriple(AddRT, subjectID, propID, langStringValue(langTag, text))
+}
+
+// DeleteLangString deletes a triple with a language-tagged string as object.
+func (b *SetBuilder) DeleteLangString(subjectID, propID id.IntID, langTag, text string) *SetBuilder {
+	return b.putTriple(DelRT, subjectID, propID, langStringValue(langTag, text))
+}
+
+// AddTypedLiteral adds a triple with a literal of the given datatype
+// (not 'rdf:langString') as object.
+func (b *SetBuilder) AddTypedLiteral(subjectID, propID, datatypeID id.IntID, lexical string) *SetBuilder {
+	if datatypeID == id.NoID || datatypeID == id.RDFLangStringID {
+		return b.fail("Typed literal needs a datatype other than 'rdf:langString' (got %d)", datatypeID)
+	}
+	return b.putTriple(AddRT, subjectID, propID, Rec{ValueTypeID: datatypeID, StringVal: lexical})
+}
+
+// DeleteTypedLiteral deletes a triple with a literal of the given datatype
+// (not 'rdf:langString') as object.
+func (b *SetBuilder) DeleteTypedLiteral(subjectID, propID, datatypeID id.IntID, lexical string) *SetBuilder {
+	if datatypeID == id.NoID || datatypeID == id.RDFLangStringID {
+		return b.fail("Typed literal needs a datatype other than 'rdf:langString' (got %d)", datatypeID)
+	}
+	return b.putTriple(DelRT, subjectID, propID, Rec{ValueTypeID: datatypeID, StringVal: lexical})
+}
+
+func langStringValue(langTag, text string) Rec {
+	return Rec{ValueTypeID: id.RDFLangStringID, LangTag: langTag, StringVal: text}
+}
+
+// ContainerBuilder builds the records changing one order-preserving
+// container (items are IRIs or blank nodes); positions are those
+// of the state each record applies to (after the previous records).
+type ContainerBuilder struct {
+	b         *SetBuilder
+	subjectID id.IntID
+}
+
+// Container returns a builder for the records changing the given container.
+func (b *SetBuilder) Container(subjectID id.IntID) *ContainerBuilder {
+	return &ContainerBuilder{b: b, subjectID: subjectID}
+}
+
+func (cb *ContainerBuilder) put(recType RecTypeCode, pos int64, itemID id.IntID,
+	flags RecFlags, editOpCID id.IntID) *ContainerBuilder {
+
+	if pos < 0 {
+		cb.b.fail("Negative position %d in container %d", pos, cb.subjectID)
+		return cb
+	}
+	cb.b.Put(Rec{
+		Form:           OrdContItem,
+		ChangeRecType:  recType,
+		ChangeRecFlags: flags,
+		EditOpCID:      editOpCID,
+		SubjectID:      cb.subjectID,
+		Prop:           id.FromPos(pos),
+		ObjectID:       itemID,
+	})
+	return cb
+}
+
+// Append adds an item at the end of the container.
+func (cb *ContainerBuilder) Append(itemID id.IntID) *ContainerBuilder {
+	return cb.put(AppendRT, 0, itemID, 0, id.NoID)
+}
+
+// Prepend adds an item at the start of the container.
+func (cb *ContainerBuilder) Prepend(itemID id.IntID) *ContainerBuilder {
+	return cb.put(PrependRT, 0, itemID, 0, id.NoID)
+}
+
+// InsertAt inserts an item at the given position.
+func (cb *ContainerBuilder) InsertAt(pos int64, itemID id.IntID) *ContainerBuilder {
+	return cb.put(InsertAtRT, pos, itemID, 0, id.NoID)
+}
+
+// RemoveAt removes the item at the given position.
+func (cb *ContainerBuilder) RemoveAt(pos int64) *ContainerBuilder {
+	return cb.put(RemoveAtRT, pos, id.NoID, 0, id.NoID)
+}
+
+// ReplaceAt replaces the item at the given position.
+func (cb *ContainerBuilder) ReplaceAt(pos int64, itemID id.IntID) *ContainerBuilder {
+	return cb.put(ReplaceAtRT, pos, itemID, 0, id.NoID)
+}
+
+// Move moves the given item from position 'from' to position 'to'
+// (in the container without the item, as for the 'InsertAtRT' record):
+// a reordering pair of records, with a new editing operation ID.
+func (cb *ContainerBuilder) Move(from, to int64, itemID id.IntID) *ContainerBuilder {
+	if from < 0 || to < 0 {
+		cb.b.fail("Negative position in move %d -> %d in container %d", from, to, cb.subjectID)
+		return cb
+	}
+	cid := cb.b.TakeEditOpCID()
+	cb.put(RemoveAtRT, from, itemID, ReorderingRF, cid)
+	cb.b.Put(Rec{
+		Form:           OrdContItem,
+		ChangeRecType:  InsertAtRT,
+		ChangeRecFlags: ReorderingRF,
+		EditOpCID:      cid,
+		SubjectID:      cb.subjectID,
+		Prop:           id.FromPos(to),
+		ObjectID:       itemID,
+		OldProp:        id.FromPos(from),
+	})
+	return cb
+}
+
+// Swap interchanges the items at the two given positions ('item1' is
+// the item at 'pos1', 'item2' at 'pos2'): two 'ReplaceAtRT' records,
+// with a new editing operation ID.
+func (cb *ContainerBuilder) Swap(pos1 int64, item1 id.IntID, pos2 int64, item2 id.IntID) *ContainerBuilder {
+	if pos1 == pos2 {
+		cb.b.fail("Swap of position %d with itself in container %d", pos1, cb.subjectID)
+		return cb
+	}
+	cid := cb.b.TakeEditOpCID()
+	cb.put(ReplaceAtRT, pos1, item2, SwappingRF, cid)
+	return cb.put(ReplaceAtRT, pos2, item1, SwappingRF, cid)
+}
+
+// TextLiteralBuilder builds the records changing one identified literal
+// holding text (form IDLitBin, as UTF-8 bytes); offsets are in bytes,
+// in the text as it is when each record is applied.
+type TextLiteralBuilder struct {
+	b         *SetBuilder
+	subjectID id.IntID
+}
+
+// TextLiteral returns a builder for the records changing the given
+// identified text literal.
+func (b *SetBuilder) TextLiteral(subjectID id.IntID) *TextLiteralBuilder {
+	return &TextLiteralBuilder{b: b, subjectID: subjectID}
+}
+
+func (tb *TextLiteralBuilder) put(recType RecTypeCode, offset int64, text string) *TextLiteralBuilder {
+	if offset < 0 {
+		tb.b.fail("Negative offset %d in literal %d", offset, tb.subjectID)
+		return tb
+	}
+	tb.b.Put(Rec{
+		Form:          IDLitBin,
+		ChangeRecType: recType,
+		SubjectID:     tb.subjectID,
+		Prop:          id.FromPos(offset),
+		BinVal:        []byte(text),
+	})
+	return tb
+}
+
+// Create adds the literal, with the given text.
+func (tb *TextLiteralBuilder) Create(text string) *TextLiteralBuilder {
+	return tb.put(AddRT, 0, text)
+}
+
+// Delete deletes the literal (whatever its text).
+func (tb *TextLiteralBuilder) Delete() *TextLiteralBuilder {
+	return tb.put(DelRT, 0, "")
+}
+
+// AppendText adds text at the end.
+func (tb *TextLiteralBuilder) AppendText(text string) *TextLiteralBuilder {
+	return tb.put(AppendRT, 0, text)
+}
+
+// InsertText inserts text at the given offset.
+func (tb *TextLiteralBuilder) InsertText(offset int64, text string) *TextLiteralBuilder {
+	return tb.put(InsertAtRT, offset, text)
+}
+
+// RemoveText removes the given text, found at the given offset.
+func (tb *TextLiteralBuilder) RemoveText(offset int64, text string) *TextLiteralBuilder {
+	return tb.put(RemoveAtRT, offset, text)
+}
+
+// ReplaceText writes the given text over the same number of bytes
+// at the given offset.
+func (tb *TextLiteralBuilder) ReplaceText(offset int64, text string) *TextLiteralBuilder {
+	return tb.put(ReplaceAtRT, offset, text)
+}
@@ -0,0 +1,161 @@
+package change
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+func binRec(recType RecTypeCode, subjectID id.IntID, offset int64, data string) Rec {
+	return Rec{Form: IDLitBin, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromPos(offset), BinVal: []byte(data)}
+}
+
+// inSet returns the record as appended by the builder to a Set
+// (changeset ID left to the changeset).
+func inSet(rec Rec) Rec {
+	rec.ChangeSetID = id.NoID
+	return rec
+}
+
+func TestSetBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *SetBuilder)
+		want  []Rec
+	}{
+		{"triples",
+			func(b *SetBuilder) { b.AddTriple(1, 2, 3).DeleteTriple(1, 2, 4) },
+			[]Rec{inSet(tripleRec(AddRT, 1, 2, 3)), inSet(tripleRec(DelRT, 1, 2, 4))}},
+		{"literals", func(b *SetBuilder) {
+			b.AddLangString(1, 2, "en", "x").DeleteTypedLiteral(1, 2, 5, "42")
+		}, []Rec{
+			{Form: UsualTriple, ChangeRecType: AddRT, SubjectID: 1, Prop: id.FromID(2),
+				ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "x"},
+			{Form: UsualTriple, ChangeRecType: DelRT, SubjectID: 1, Prop: id.FromID(2),
+				ValueTypeID: 5, StringVal: "42"},
+		}},
+		{"container items", func(b *SetBuilder) {
+			b.Container(10).Append(100).Prepend(101).InsertAt(1, 102).RemoveAt(2).ReplaceAt(0, 103)
+		}, []Rec{
+			inSet(itemRec(AppendRT, 10, 0, 100)),
+			inSet(itemRec(PrependRT, 10, 0, 101)),
+			inSet(itemRec(InsertAtRT, 10, 1, 102)),
+			inSet(itemRec(RemoveAtRT, 10, 2, id.NoID)),
+			inSet(itemRec(ReplaceAtRT, 10, 0, 103)),
+		}},
+		{"move", func(b *SetBuilder) {
+			b.Container(10).Move(3, 1, 100)
+		}, []Rec{
+			inSet(withOp(itemRec(RemoveAtRT, 10, 3, 100), ReorderingRF, 1)),
+			func() Rec {
+				rec := inSet(withOp(itemRec(InsertAtRT, 10, 1, 100), ReorderingRF, 1))
+				rec.OldProp = id.FromPos(3)
+				return rec
+			}(),
+		}},
+		{"swap", func(b *SetBuilder) {
+			b.Container(10).Swap(0, 100, 2, 102)
+		}, []Rec{
+			inSet(withOp(itemRec(ReplaceAtRT, 10, 0, 102), SwappingRF, 1)),
+			inSet(withOp(itemRec(ReplaceAtRT, 10, 2, 100), SwappingRF, 1)),
+		}},
+		{"new editing operation ID for each operation", func(b *SetBuilder) {
+			b.Container(10).Swap(0, 100, 1, 101).Move(0, 1, 101)
+		}, []Rec{
+			inSet(withOp(itemRec(ReplaceAtRT, 10, 0, 101), SwappingRF, 1)),
+			inSet(withOp(itemRec(ReplaceAtRT, 10, 1, 100), SwappingRF, 1)),
+			inSet(withOp(itemRec(RemoveAtRT, 10, 0, 101), ReorderingRF, 2)),
+			func() Rec {
+				rec := inSet(withOp(itemRec(InsertAtRT, 10, 1, 101), ReorderingRF, 2))
+				rec.OldProp = id.FromPos(0)
+				return rec
+			}(),
+		}},
+		{"text", func(b *SetBuilder) {
+			b.TextLiteral(20).Create("abc").AppendText("é").InsertText(1, "x").
+				RemoveText(2, "bc").ReplaceText(0, "y").Delete()
+		}, []Rec{
+			binRec(AddRT, 20, 0, "abc"),
+			binRec(AppendRT, 20, 0, "é"),
+			binRec(InsertAtRT, 20, 1, "x"),
+			binRec(RemoveAtRT, 20, 2, "bc"),
+			binRec(ReplaceAtRT, 20, 0, "y"),
+			binRec(DelRT, 20, 0, ""),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := &Set{SetInfo: SetInfo{ID: testCSetID}}
+			b := NewSetBuilder(set)
+			tt.build(b)
+			if b.Err() != nil {
+				t.Fatalf("Builder failed: %v", b.Err())
+			}
+			if !reflect.DeepEqual(set.ChangeRecords, tt.want) {
+				t.Errorf("Records built:\n got  %+v\n want %+v", set.ChangeRecords, tt.want)
+			}
+			err := ValidateSet(set)
+			if err != nil {
+				t.Errorf("Invalid changeset built: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *SetBuilder)
+	}{
+		{"triple without property", func(b *SetBuilder) { b.AddTriple(1, id.NoID, 3) }},
+		{"typed literal as lang string", func(b *SetBuilder) { b.AddTypedLiteral(1, 2, id.RDFLangStringID, "x") }},
+		{"negative position", func(b *SetBuilder) { b.Container(10).InsertAt(-1, 100) }},
+		{"negative move position", func(b *SetBuilder) { b.Container(10).Move(0, -1, 100) }},
+		{"swap with itself", func(b *SetBuilder) { b.Container(10).Swap(1, 100, 1, 100) }},
+		{"negative offset", func(b *SetBuilder) { b.TextLiteral(20).RemoveText(-1, "x") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := &Set{SetInfo: SetInfo{ID: testCSetID}}
+			b := NewSetBuilder(set)
+			tt.build(b)
+			b.AddTriple(1, 2, 3) // ignored after the error
+			if b.Err() == nil {
+				t.Errorf("Builder did not fail")
+			}
+			if len(set.ChangeRecords) != 0 {
+				t.Errorf("Records built despite the error: %+v", set.ChangeRecords)
+			}
+		})
+	}
+}
+
+func TestSinkSetBuilder(t *testing.T) {
+	sink := &testSinkEnder{}
+	b, err := NewSinkSetBuilder(sink, SetInfo{ID: testCSetID})
+	if err != nil {
+		t.Fatalf("NewSinkSetBuilder failed: %v", err)
+	}
+	b.InContext(30).AddTriple(1, 2, 3)
+	b.Container(10).Move(0, 1, 100)
+	if b.Err() != nil {
+		t.Fatalf("Builder failed: %v", b.Err())
+	}
+
+	want := []Rec{
+		tripleRec(AddRT, 1, 2, 3),
+		withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+		withOp(itemRec(InsertAtRT, 10, 1, 100), ReorderingRF, 1),
+	}
+	want[2].OldProp = id.FromPos(0)
+	for i := range want {
+		want[i].ChangeRecContextID = 30
+	}
+	if !reflect.DeepEqual(sink.recs, want) {
+		t.Errorf("Records put:\n got  %+v\n want %+v", sink.recs, want)
+	}
+}
@@ -0,0 +1,52 @@
+// change/changetest/records.go: changesets and change records for tests
+
+/*
+Package changetest has the helpers shared by the tests of the packages
+working on changesets (apply, merge): building a changeset with
+change.SetBuilder, and making single records, also the ones the builder
+does not make (preconditions, invalid records).
+*/
+package changetest
+
+import (
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// BuildSet returns a changeset with the given ID and the records
+// put by the given function; fails the test if the builder fails.
+func BuildSet(t *testing.T, csetID id.IntID, build func(b *change.SetBuilder)) *change.Set {
+	t.Helper()
+	set := &change.Set{SetInfo: change.SetInfo{ID: csetID}}
+	b := change.NewSetBuilder(set)
+	build(b)
+	if b.Err() != nil {
+		t.Fatalf("Failed to build changeset %d: %v", csetID, b.Err())
+	}
+	return set
+}
+
+// The records below have no changeset ID (the changeset's, see change.Set).
+
+// TripleRec returns a record of the given type on the triple
+// (subjectID, propID, objectID).
+func TripleRec(recType change.RecTypeCode, subjectID, propID, objectID id.IntID) change.Rec {
+	return change.Rec{Form: change.UsualTriple, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromID(propID), ObjectID: objectID}
+}
+
+// ItemRec returns a record of the given type on the item 'objectID'
+// at the given position of container 'subjectID'.
+func ItemRec(recType change.RecTypeCode, subjectID id.IntID, pos int64, objectID id.IntID) change.Rec {
+	return change.Rec{Form: change.OrdContItem, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromPos(pos), ObjectID: objectID}
+}
+
+// BinRec returns a record of the given type on the given bytes
+// at offset 'off' of binary literal 'subjectID'.
+func BinRec(recType change.RecTypeCode, subjectID id.IntID, off int64, data string) change.Rec {
+	return change.Rec{Form: change.IDLitBin, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromPos(off), BinVal: []byte(data)}
+}
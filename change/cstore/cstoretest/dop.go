@@ -0,0 +1,394 @@
+// change/cstore/cstoretest/dop.go: conformance tests for any changes store Data Operator
+
+package cstoretest
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoremem"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// TestDop checks the given Data Operator, of an empty store:
+// the sample changesets (see SampleSets) are put into the store
+// and into the reference store (see package 'cstoremem'), then read back
+// from both and compared; the ID getters are checked too.
+//
+// The database behind the Data Operator (if any) is needed for
+// the transaction given to MakeCRecPushSink; when 'db' is nil,
+// MakeCRecPushSink is called without transaction.
+//
+// The store keeps the sample changesets; CheckSamples can check
+// them again, after reopening the store for example.
+//
+func TestDop(t *testing.T, dop cstore.Dop, db *sql.DB) {
+	ref := cstoremem.New()
+	PutSamples(t, ref, nil)
+
+	t.Run("PutSamples", func(t *testing.T) {
+		PutSamples(t, dop, db)
+	})
+	t.Run("CheckSamples", func(t *testing.T) {
+		CheckSamples(t, dop, ref)
+	})
+	t.Run("Abort", func(t *testing.T) {
+		testAbort(t, dop)
+	})
+	t.Run("RejectInvalid", func(t *testing.T) {
+		testRejectInvalid(t, dop)
+	})
+	t.Run("IDGetters", func(t *testing.T) {
+		testIDGetters(t, dop)
+	})
+}
+
+// PutSamples puts the sample changesets (see SampleSets) into the store:
+// the first one using a push sink made by MakeCRecPushSink (in a transaction
+// of the given database, if not nil), the others using a push sink made by
+// MakeCRecPushSinkEnder; the headerless changeset's records are put
+// without header.
+func PutSamples(t testing.TB, dop cstore.Dop, db *sql.DB) {
+	sets := SampleSets()
+
+	var tx *sql.Tx
+	if db != nil {
+		var err error
+		tx, err = db.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+	}
+	sink, err := dop.MakeCRecPushSink(tx)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSink failed: %v", err)
+	}
+	err = change.PutSet(sink, sets[0])
+	if err != nil {
+		t.Fatalf("Failed to put changeset %d: %v", sets[0].ID, err)
+	}
+	if finisher, ok := sink.(change.RecPushSinkFinisher); ok {
+		err = finisher.Finish()
+		if err != nil {
+			t.Fatalf("Changeset %d incomplete: %v", sets[0].ID, err)
+		}
+	}
+	if tx != nil {
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("Failed to commit changeset %d: %v", sets[0].ID, err)
+		}
+	}
+
+	sinkEnder, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	for _, set := range sets[1:] {
+		if set.ID == HeaderlessCSetID {
+			for _, rec := range set.ChangeRecords {
+				rec.ChangeSetID = set.ID
+				err = sinkEnder.PutChangeRec(rec)
+				if err != nil {
+					break
+				}
+			}
+		} else {
+			err = change.PutSet(sinkEnder, set)
+		}
+		if err != nil {
+			sinkEnder.Abort()
+			t.Fatalf("Failed to put changeset %d: %v", set.ID, err)
+		}
+	}
+	if finisher, ok := sinkEnder.(change.RecPushSinkFinisher); ok {
+		err = finisher.Finish()
+		if err != nil {
+			sinkEnder.Abort()
+			t.Fatalf("Changesets incomplete: %v", err)
+		}
+	}
+	sinkEnder.End()
+}
+
+// CheckSamples checks that the store has the sample changesets
+// (see PutSamples), as the given reference store has them.
+func CheckSamples(t testing.TB, dop, ref cstore.ReadingDop) {
+	sets := SampleSets()
+
+	for _, set := range sets {
+		info, found, err := dop.ReadCSetInfo(set.ID)
+		if err != nil || !found {
+			t.Fatalf("ReadCSetInfo(%d): found %v, error %v", set.ID, found, err)
+		}
+		refInfo, _, _ := ref.ReadCSetInfo(set.ID)
+		if !sameSetInfo(info, refInfo) {
+			t.Errorf("Changeset %d header differs:\n got  %+v\n want %+v", set.ID, info, refInfo)
+		}
+	}
+	_, found, err := dop.ReadCSetInfo(EmptyCSetID - 1)
+	if err != nil || found {
+		t.Errorf("ReadCSetInfo(%d) for missing changeset: found %v, error %v",
+			EmptyCSetID-1, found, err)
+	}
+
+	allRecs := pullAll(t, dop.MakeCRecPullSourceCloser)
+	checkOrder(t, allRecs)
+	refRecs := pullAll(t, ref.MakeCRecPullSourceCloser)
+	compareRecs(t, "all changesets", allRecs, refRecs)
+
+	for _, set := range sets {
+		csetID := set.ID
+		makeSrc := func() (change.RecPullSourceCloser, error) {
+			return dop.MakeCSetRangePullSourceCloser(csetID, csetID)
+		}
+		recs := pullAll(t, makeSrc)
+		compareRecs(t, fmt.Sprintf("changeset %d", csetID), recs, recordsOf(refRecs, csetID, csetID))
+	}
+
+	// Range with some changesets, ends not matching any changeset:
+	makeSrc := func() (change.RecPullSourceCloser, error) {
+		return dop.MakeCSetRangePullSourceCloser(FullCSetID+1, EmptyCSetID-1)
+	}
+	compareRecs(t, "changeset range", pullAll(t, makeSrc),
+		recordsOf(refRecs, FullCSetID+1, EmptyCSetID-1))
+}
+
+func testAbort(t *testing.T, dop cstore.Dop) {
+	const csetID = EmptyCSetID + 100
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	set := &change.Set{SetInfo: change.SetInfo{ID: csetID, Message: "aborted"}}
+	change.NewSetBuilder(set).AddTriple(1100, 1101, 1102)
+	err = change.PutSet(sink, set)
+	if err != nil {
+		t.Fatalf("Failed to put changeset %d: %v", csetID, err)
+	}
+	sink.Abort()
+
+	_, found, err := dop.ReadCSetInfo(csetID)
+	if err != nil || found {
+		t.Errorf("Aborted changeset %d: found %v, error %v", csetID, found, err)
+	}
+	recs := pullAll(t, func() (change.RecPullSourceCloser, error) {
+		return dop.MakeCSetRangePullSourceCloser(csetID, csetID)
+	})
+	if len(recs) != 0 {
+		t.Errorf("Aborted changeset %d: %d records stored", csetID, len(recs))
+	}
+}
+
+func testRejectInvalid(t *testing.T, dop cstore.Dop) {
+	const csetID = EmptyCSetID + 200
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	defer sink.Abort()
+
+	invalid := change.Rec{
+		ChangeSetID: csetID, Form: change.UsualTriple, ChangeRecType: change.InsertAtRT,
+		SubjectID: 1100, Prop: id.FromID(1101), ObjectID: 1102,
+	}
+	err = sink.PutChangeRec(invalid)
+	if _, ok := err.(*change.ValidationError); !ok {
+		t.Errorf("Invalid record put: want *change.ValidationError, got %v", err)
+	}
+}
+
+func testIDGetters(t *testing.T, dop cstore.Dop) {
+	seen := make(map[id.IntID]string)
+	check := func(intID id.IntID, what string) {
+		if intID <= id.MaxReservedID {
+			t.Errorf("%s: reserved ID %d", what, intID)
+		}
+		if prev, dup := seen[intID]; dup {
+			t.Errorf("%s: ID %d already given (%s)", what, intID, prev)
+		}
+		seen[intID] = what
+	}
+
+	for g := 0; g < 2; g++ {
+		getter, err := dop.MakeInternalIDGetCloser()
+		if err != nil {
+			t.Fatalf("MakeInternalIDGetCloser failed: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			intID, err := getter.GetNewInternalID()
+			if err != nil {
+				t.Fatalf("GetNewInternalID failed: %v", err)
+			}
+			check(intID, fmt.Sprintf("internal ID getter %d, ID %d", g, i))
+		}
+		err = getter.Close()
+		if err != nil {
+			t.Errorf("Internal ID getter Close failed: %v", err)
+		}
+	}
+
+	getter, err := dop.MakeExternalIDGetCloser()
+	if err != nil {
+		t.Fatalf("MakeExternalIDGetCloser failed: %v", err)
+	}
+	defer getter.Close()
+
+	const iri = "http://example.org/cstoretest#thing"
+	_, found, err := getter.LookupInternalIDForIRI(iri)
+	if err != nil || found {
+		t.Errorf("LookupInternalIDForIRI(%q) before use: found %v, error %v", iri, found, err)
+	}
+	intID, err := getter.GetInternalIDForIRI(iri)
+	if err != nil {
+		t.Fatalf("GetInternalIDForIRI(%q) failed: %v", iri, err)
+	}
+	check(intID, iri)
+	againID, err := getter.GetInternalIDForIRI(iri)
+	if err != nil || againID != intID {
+		t.Errorf("GetInternalIDForIRI(%q) again: ID %d, was %d (error %v)", iri, againID, intID, err)
+	}
+	againID, found, err = getter.LookupInternalIDForIRI(iri)
+	if err != nil || !found || againID != intID {
+		t.Errorf("LookupInternalIDForIRI(%q) after use: ID %d, was %d (found %v, error %v)",
+			iri, againID, intID, found, err)
+	}
+	otherID, err := getter.GetInternalIDForIRI(iri + "2")
+	if err != nil {
+		t.Fatalf("GetInternalIDForIRI(%q) failed: %v", iri+"2", err)
+	}
+	check(otherID, iri+"2")
+}
+
+// pullAll returns all the records from a pull source made by the given function.
+func pullAll(t testing.TB, makeSrc func() (change.RecPullSourceCloser, error)) []change.Rec {
+	src, err := makeSrc()
+	if err != nil {
+		t.Fatalf("Failed to make pull source: %v", err)
+	}
+	defer src.Close()
+
+	var recs []change.Rec
+	for {
+		rec, gotRec, err := src.GetNextChangeRec()
+		if err != nil {
+			t.Fatalf("GetNextChangeRec failed after %d records: %v", len(recs), err)
+		}
+		if !gotRec {
+			return recs
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// recordsOf returns the records of the changesets in the given range.
+func recordsOf(recs []change.Rec, firstCSetID, lastCSetID id.IntID) []change.Rec {
+	var selected []change.Rec
+	for _, rec := range recs {
+		if firstCSetID <= rec.ChangeSetID && rec.ChangeSetID <= lastCSetID {
+			selected = append(selected, rec)
+		}
+	}
+	return selected
+}
+
+// checkOrder checks the order promised by the pull sources: by changeset,
+// then in the order the records were put (see cstore.ReadingDop),
+// which is the order of the records in the sample changesets.
+func checkOrder(t testing.TB, recs []change.Rec) {
+	for i := 1; i < len(recs); i++ {
+		if recs[i-1].ChangeSetID > recs[i].ChangeSetID {
+			t.Errorf("Record #%d out of order (changeset %d after changeset %d)",
+				i, recs[i].ChangeSetID, recs[i-1].ChangeSetID)
+		}
+	}
+
+	for _, set := range SampleSets() {
+		got := recordsOf(recs, set.ID, set.ID)
+		for i, rec := range set.ChangeRecords {
+			if i >= len(got) {
+				break // missing records are reported by compareRecs
+			}
+			rec.ChangeSetID = set.ID
+			if recKey(got[i]) != recKey(rec) {
+				t.Errorf("Record %d of changeset %d out of order:\n got  %s\n want %s",
+					i, set.ID, recKey(got[i]), recKey(rec))
+				break
+			}
+		}
+	}
+}
+
+// compareRecs compares the records as multisets (the order is checked
+// apart, see checkOrder); a nil binary value is the same as an empty one.
+func compareRecs(t testing.TB, what string, got, want []change.Rec) {
+	gotKeys, wantKeys := recKeys(got), recKeys(want)
+	if reflect.DeepEqual(gotKeys, wantKeys) {
+		return
+	}
+
+	missing, extra := diffKeys(wantKeys, gotKeys), diffKeys(gotKeys, wantKeys)
+	t.Errorf("Records of %s differ (got %d, want %d):\n missing %q\n extra %q",
+		what, len(got), len(want), missing, extra)
+}
+
+func recKeys(recs []change.Rec) []string {
+	keys := make([]string, len(recs))
+	for i, rec := range recs {
+		keys[i] = recKey(rec)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// recKey returns a text with all the fields of the record.
+func recKey(rec change.Rec) string {
+	binVal := rec.BinVal
+	rec.BinVal = nil
+	return fmt.Sprintf("%+v BinVal:%x", rec, binVal)
+}
+
+// diffKeys returns the keys from 'a' not in 'b' (both sorted, with repetitions).
+func diffKeys(a, b []string) []string {
+	var diff []string
+	j := 0
+	for _, key := range a {
+		for j < len(b) && b[j] < key {
+			j++
+		}
+		if j < len(b) && b[j] == key {
+			j++
+			continue
+		}
+		diff = append(diff, key)
+	}
+	return diff
+}
+
+// sameSetInfo compares changeset headers; the creation times
+// are compared as instants, nil slices are the same as empty ones.
+func sameSetInfo(a, b change.SetInfo) bool {
+	if a.ID != b.ID || a.Author != b.Author || a.Message != b.Message ||
+		!a.CreatedAt.Equal(b.CreatedAt) ||
+		len(a.ParentIDs) != len(b.ParentIDs) || len(a.Annotations) != len(b.Annotations) {
+		return false
+	}
+	for i := range a.ParentIDs {
+		if a.ParentIDs[i] != b.ParentIDs[i] {
+			return false
+		}
+	}
+	for i := range a.Annotations {
+		if a.Annotations[i] != b.Annotations[i] {
+			return false
+		}
+	}
+	return true
+}
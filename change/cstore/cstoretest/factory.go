@@ -0,0 +1,259 @@
+// change/cstore/cstoretest/factory.go: conformance tests for any changes store implementation
+
+/*
+Package cstoretest is a kit of conformance tests for the changes store
+implementations (cstore.SQLDefFactory, cstore.Dop), to be called from
+the tests of each implementation, so all of them are held to the same
+contract:
+
+	func TestConformance(t *testing.T) {
+		ft := cstoretest.FactoryTest{
+			Factory: cstoresqlite0.SQLDefFactory{},
+			NewDB:   openNewTestDB,
+		}
+		ft.Run(t)
+	}
+
+The whole life cycle of a store is checked: creation (CreateSQLStore,
+CheckCStoreSchema, CreateCStoreSchemaElements, with the latest schema
+version: nothing to do for MigrateCStoreSchema), use (putting and reading back
+sample changesets with records of every form and type, compared with
+the reference in-memory store from package 'cstoremem'; ID getters),
+reopening, and dropping (DropCStoreSchemaElements); the schema operations
+reports (sqlschema.OpReport) are checked at each step.
+*/
+package cstoretest
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoreconfsql"
+	"github.com/gimpldo/ba-prototype-go/cstoremem"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+// DefaultStorePrefix is the store prefix used when FactoryTest.StorePrefix is empty.
+const DefaultStorePrefix = "cstoretest_"
+
+// FactoryTest = conformance test of a changes store implementation
+// (see Run).
+type FactoryTest struct {
+	Factory cstore.SQLDefFactory
+
+	// NewDB returns a database without changes store with the prefix used
+	// (a new one, usually); the test does not close it.
+	NewDB func(t *testing.T) *sql.DB
+
+	// Default: DefaultStorePrefix
+	StorePrefix string
+
+	// Given to CreateSQLStore; the samples need stores that do not
+	// check the IDs in the records (the IDs are not allocated).
+	SchemaCreationOptions string
+}
+
+// Run runs the conformance test, as a sequence of subtests
+// on the same store (stops at the first failed one).
+func (ft *FactoryTest) Run(t *testing.T) {
+	db := ft.NewDB(t)
+	prefix := ft.StorePrefix
+	if prefix == "" {
+		prefix = DefaultStorePrefix
+	}
+
+	var (
+		sd        cstore.SQLDef
+		nExpected int
+	)
+
+	steps := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"OpenMissing", func(t *testing.T) {
+			_, err := ft.Factory.OpenSQLStore(db, prefix)
+			if err == nil {
+				t.Fatalf("OpenSQLStore succeeded without store %q", prefix)
+			}
+		}},
+		{"CreateSQLStore", func(t *testing.T) {
+			var err error
+			sd, err = ft.Factory.CreateSQLStore(db, prefix, ft.SchemaCreationOptions)
+			if err != nil {
+				t.Fatalf("CreateSQLStore failed: %v", err)
+			}
+		}},
+		{"CheckBeforeCreate", func(t *testing.T) {
+			report, err := sd.CheckCStoreSchema()
+			if err != nil {
+				t.Fatalf("CheckCStoreSchema failed: %v\n%v", err, report)
+			}
+			nExpected = report.NumExpected
+			if nExpected == 0 {
+				t.Fatalf("No schema elements expected:\n%v", report)
+			}
+			checkReport(t, report, sqlschema.OpCheck, sqlschema.MissingES,
+				sqlschema.OpReport{NumExpected: nExpected, NumMissing: nExpected})
+		}},
+		{"CreateCStoreSchemaElements", func(t *testing.T) {
+			report, err := sd.CreateCStoreSchemaElements()
+			if err != nil {
+				t.Fatalf("CreateCStoreSchemaElements failed: %v\n%v", err, report)
+			}
+			checkReport(t, report, sqlschema.OpCreate, sqlschema.CreatedES,
+				sqlschema.OpReport{NumExpected: nExpected, NumMissing: nExpected, NumCreated: nExpected})
+		}},
+		{"CheckAfterCreate", func(t *testing.T) {
+			checkMatched(t, sd, nExpected)
+		}},
+		{"MigrateUpToDate", func(t *testing.T) {
+			report, err := sd.MigrateCStoreSchema()
+			if err != nil {
+				t.Fatalf("MigrateCStoreSchema failed: %v\n%v", err, report)
+			}
+			if len(report.Steps) != 0 {
+				t.Errorf("Migration steps run on a new store:\n%v", report)
+			}
+			checkReport(t, report, sqlschema.OpMigrate, sqlschema.MatchedES,
+				sqlschema.OpReport{NumExpected: nExpected, NumFound: nExpected, NumMatched: nExpected})
+		}},
+		{"CreateExisting", func(t *testing.T) {
+			_, err := ft.Factory.CreateSQLStore(db, prefix, ft.SchemaCreationOptions)
+			if err == nil {
+				t.Fatalf("CreateSQLStore succeeded with existing store %q", prefix)
+			}
+		}},
+		{"LookupForStore", func(t *testing.T) {
+			name, factory, err := cstore.LookupForStore(db, prefix)
+			if err != nil {
+				t.Fatalf("LookupForStore failed (implementation not registered?): %v", err)
+			}
+			if reflect.TypeOf(factory) != reflect.TypeOf(ft.Factory) {
+				t.Fatalf("Implementation %q registered as %T, not %T", name, factory, ft.Factory)
+			}
+
+			confEntries, err := cstoreconfsql.ReadConfFromDB(db, prefix)
+			if err != nil {
+				t.Fatalf("ReadConfFromDB failed: %v", err)
+			}
+			version, err := cstoreconfsql.SchemaVersion(confEntries)
+			if err != nil || version != cstore.LatestSchemaVersion(name) {
+				t.Errorf("New store schema version %d (error %v), want the latest: %d",
+					version, err, cstore.LatestSchemaVersion(name))
+			}
+		}},
+		{"Dop", func(t *testing.T) {
+			dop, err := sd.UseCStore()
+			if err != nil {
+				t.Fatalf("UseCStore failed: %v", err)
+			}
+			defer dop.Close()
+
+			TestDop(t, dop, db)
+		}},
+		{"ReopenReadOnly", func(t *testing.T) {
+			rsd, err := ft.Factory.OpenSQLStoreReadOnly(db, prefix)
+			if err != nil {
+				t.Fatalf("OpenSQLStoreReadOnly failed: %v", err)
+			}
+			checkMatched(t, rsd, nExpected)
+
+			dop, err := rsd.UseCStoreReadOnly()
+			if err != nil {
+				t.Fatalf("UseCStoreReadOnly failed: %v", err)
+			}
+			defer dop.Close()
+
+			ref := cstoremem.New()
+			PutSamples(t, ref, nil)
+			CheckSamples(t, dop, ref)
+		}},
+		{"Reopen", func(t *testing.T) {
+			var err error
+			sd, err = ft.Factory.OpenSQLStore(db, prefix)
+			if err != nil {
+				t.Fatalf("OpenSQLStore failed: %v", err)
+			}
+			checkMatched(t, sd, nExpected)
+		}},
+		{"DropCStoreSchemaElements", func(t *testing.T) {
+			report, err := sd.DropCStoreSchemaElements()
+			if err != nil {
+				t.Fatalf("DropCStoreSchemaElements failed: %v\n%v", err, report)
+			}
+			checkReport(t, report, sqlschema.OpDrop, sqlschema.DroppedES,
+				sqlschema.OpReport{NumExpected: nExpected, NumFound: nExpected, NumMatched: nExpected,
+					NumDropped: nExpected})
+		}},
+		{"CheckAfterDrop", func(t *testing.T) {
+			report, err := sd.CheckCStoreSchema()
+			if err != nil {
+				t.Fatalf("CheckCStoreSchema failed: %v\n%v", err, report)
+			}
+			checkReport(t, report, sqlschema.OpCheck, sqlschema.MissingES,
+				sqlschema.OpReport{NumExpected: nExpected, NumMissing: nExpected, NumDropped: nExpected})
+
+			_, err = ft.Factory.OpenSQLStore(db, prefix)
+			if err == nil {
+				t.Errorf("OpenSQLStore succeeded after dropping store %q", prefix)
+			}
+			_, err = ft.Factory.CreateSQLStore(db, prefix, ft.SchemaCreationOptions)
+			if err != nil {
+				t.Errorf("CreateSQLStore failed after dropping store %q: %v", prefix, err)
+			}
+		}},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, step.test) {
+			return
+		}
+	}
+}
+
+// checkMatched checks that all the expected schema elements are found and matched.
+func checkMatched(t *testing.T, sd cstore.ReadingSQLDef, nExpected int) {
+	report, err := sd.CheckCStoreSchema()
+	if err != nil {
+		t.Fatalf("CheckCStoreSchema failed: %v\n%v", err, report)
+	}
+	checkReport(t, report, sqlschema.OpCheck, sqlschema.MatchedES,
+		sqlschema.OpReport{NumExpected: nExpected, NumFound: nExpected, NumMatched: nExpected,
+			NumCreated: report.NumCreated, NumDropped: report.NumDropped})
+}
+
+// checkReport checks the last operation and the counts of a schema
+// operations report, and the status of each element.
+//
+// The counts not reset by the last operation (left by the previous ones
+// on the same SQLDef) are expected too, so the wanted report must
+// have them as well.
+//
+func checkReport(t *testing.T, report sqlschema.OpReport, lastOp string,
+	status sqlschema.ElemStatusCode, want sqlschema.OpReport) {
+
+	t.Helper()
+
+	if report.LastOp != lastOp {
+		t.Errorf("Report after %q: last operation %q", lastOp, report.LastOp)
+	}
+	got := report
+	got.Elements, got.Conf, got.LastOp = nil, "", ""
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Report after %q: counts differ:\n got  %+v\n want %+v\n%v",
+			lastOp, got, want, report)
+	}
+	if len(report.Elements) != want.NumExpected {
+		t.Errorf("Report after %q: %d elements, %d expected",
+			lastOp, len(report.Elements), want.NumExpected)
+	}
+	for i, elem := range report.Elements {
+		if elem.Status != status || elem.Err != nil {
+			t.Errorf("Report after %q: element %d/%d (%s) has status %v (error %v), want %v",
+				lastOp, i, len(report.Elements), elem.Name, elem.Status, elem.Err, status)
+		}
+	}
+}
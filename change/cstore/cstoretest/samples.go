@@ -0,0 +1,175 @@
+// change/cstore/cstoretest/samples.go: sample changesets for the conformance tests
+
+package cstoretest
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// The changeset IDs of the samples (see SampleSets).
+const (
+	FullCSetID       id.IntID = 2001
+	SecondCSetID     id.IntID = 2002
+	HeaderlessCSetID id.IntID = 2003
+	EmptyCSetID      id.IntID = 2005
+)
+
+// All the record forms, and the range of the record types
+// (for generating the records of every applicable form and type).
+var (
+	allForms = []change.RecFormCode{change.UsualTriple, change.OrdContItem, change.IDLitBin}
+
+	firstRecType = change.AddRT
+	lastRecType  = change.ReplaceAtRT
+)
+
+// SampleSets returns new sample changesets, in changeset ID order:
+//  - FullCSetID: header with all the fields, and records
+//    of every applicable form and type (with every kind of value),
+//    including editing operations and information records;
+//  - SecondCSetID: header with a parent, a few records, including
+//    records for the same triple with different types (tests of
+//    the triples deleted, and of a triple added);
+//  - HeaderlessCSetID: header without fields but the ID (as the store
+//    should give it when the records are put without header), a few records;
+//  - EmptyCSetID: merge header (two parents), no records.
+//
+// The IDs in the records are arbitrary (not allocated from the store).
+//
+func SampleSets() []*change.Set {
+	full := &change.Set{SetInfo: change.SetInfo{
+		ID:     FullCSetID,
+		Author: "Conformance Tester <tester@example.org>",
+		// Not in UTC, with nanoseconds:
+		CreatedAt: time.Date(2017, time.March, 14, 15, 9, 26, 535897932, time.FixedZone("UTC+2", 2*60*60)),
+		Message:   "Every form and type\n\nwith a multi-line message, \"quotes\" and ünïcödé",
+		Annotations: []change.Annotation{
+			{Key: "tool", Value: "cstoretest"},
+			{Key: "tag", Value: "one"},
+			{Key: "tag", Value: "two"},
+			{Key: "empty", Value: ""},
+		},
+	}}
+	full.ChangeRecords = everyFormAndType(FullCSetID)
+
+	b := change.NewSetBuilder(full)
+	b.Container(1900).Append(1901).Append(1902).Move(0, 1, 1901).Swap(0, 1902, 1, 1901)
+	b.TextLiteral(1910).Create("hello").InsertText(5, " world").RemoveText(0, "h")
+	b.Meta().LangString(FullCSetID, 1920, "en", "a comment")
+	b.Context(1930).Triple(1930, 1931, 1900)
+	b.InContext(1930).AddTriple(1940, 1941, 1942).Container(1900).Prepend(1943)
+	b.Ident().Triple(1940, 1941, 1942)
+	b.AddLangString(1940, 1941, "en", "label")
+	if b.Err() != nil {
+		panic(b.Err()) // bad samples
+	}
+
+	second := &change.Set{SetInfo: change.SetInfo{
+		ID:        SecondCSetID,
+		Author:    "Second Author",
+		ParentIDs: []id.IntID{FullCSetID},
+	}}
+	b = change.NewSetBuilder(second)
+	b.Put(testRec(1940, 1941, change.Rec{ObjectID: 1942})).DeleteTriple(1940, 1941, 1942)
+	b.Put(testRec(1940, 1941, change.Rec{ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "label"}))
+	b.DeleteLangString(1940, 1941, "en", "label")
+	b.AddTypedLiteral(1940, 1941, 1502, "ok")
+	b.Put(testRec(1940, 1941, change.Rec{ValueTypeID: 1502, StringVal: "ok"}))
+	b.Container(1900).RemoveAt(0).ReplaceAt(0, 1944)
+	b.TextLiteral(1910).Delete()
+	if b.Err() != nil {
+		panic(b.Err())
+	}
+
+	headerless := &change.Set{SetInfo: change.SetInfo{ID: HeaderlessCSetID}}
+	b = change.NewSetBuilder(headerless)
+	b.AddTriple(1950, 1951, 1952).AddLangString(1950, 1953, "fr", "bonjour")
+	b.Container(1960).Append(1961)
+	if b.Err() != nil {
+		panic(b.Err())
+	}
+
+	empty := &change.Set{SetInfo: change.SetInfo{
+		ID:        EmptyCSetID,
+		Message:   "Merge",
+		CreatedAt: time.Unix(1500000000, 0).UTC(),
+		ParentIDs: []id.IntID{SecondCSetID, HeaderlessCSetID},
+	}}
+
+	return []*change.Set{full, second, headerless, empty}
+}
+
+// testRec returns a 'TestRT' record for the given triple
+// (the object given as a record with the value fields).
+func testRec(subjectID, propID id.IntID, v change.Rec) change.Rec {
+	v.Form = change.UsualTriple
+	v.ChangeRecType = change.TestRT
+	v.SubjectID = subjectID
+	v.Prop = id.FromID(propID)
+	return v
+}
+
+// everyFormAndType returns records of every form, with every record type
+// applicable to the form (as accepted by change.Validator), each with
+// every kind of value the form can have; each record has its own subject.
+func everyFormAndType(csetID id.IntID) []change.Rec {
+	tripleValues := []change.Rec{
+		{ObjectID: 1500},
+		{ValueTypeID: id.RDFLangStringID, LangTag: "en-GB", StringVal: "colour"},
+		{ValueTypeID: 1501, StringVal: "42"},
+		{ValueTypeID: 1502, StringVal: ""},
+	}
+	itemValues := append([]change.Rec{{}}, tripleValues...)
+	binValues := []change.Rec{
+		{BinVal: []byte{0, 1, 2, 0xff}},
+		{BinVal: []byte{}},
+		{BinVal: bytes.Repeat([]byte("0123456789abcdef"), 1024)},
+	}
+
+	var recs []change.Rec
+	subjectID := id.IntID(1100)
+	for _, form := range allForms {
+		values := tripleValues
+		switch form {
+		case change.OrdContItem:
+			values = itemValues
+		case change.IDLitBin:
+			values = binValues
+		}
+
+		for recType := firstRecType; recType <= lastRecType; recType++ {
+			for i, rec := range values {
+				subjectID++
+				rec.Form = form
+				rec.ChangeRecType = recType
+				rec.ChangeSetID = csetID
+				rec.SubjectID = subjectID
+				if form == change.UsualTriple {
+					rec.Prop = id.FromID(1400 + id.IntID(i))
+				} else {
+					rec.Prop = id.FromPos(int64(i))
+				}
+				if i%2 == 1 {
+					rec.ChangeRecContextID = 1600
+				}
+				if form == change.OrdContItem && recType == change.ReplaceAtRT {
+					rec.OldProp = id.FromPos(int64(i + 1))
+				}
+
+				err := change.NewValidator().Check(rec)
+				if vErr, ok := err.(*change.ValidationError); ok && vErr.Rule == change.TypeForFormVR {
+					break // record type not applicable to the form
+				}
+				if err != nil {
+					panic(err) // bad samples
+				}
+				recs = append(recs, rec)
+			}
+		}
+	}
+	return recs
+}
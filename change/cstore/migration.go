@@ -0,0 +1,83 @@
+package cstore
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gimpldo/ba-prototype-go/cstoreconfsql"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+// Registry of the schema migration steps of each changes store
+// implementation (by implementation name, as the registry of
+// the implementations), populated by the implementation packages
+// from their init functions.
+//
+// The steps of an implementation are ordered by FromVersion, without gaps:
+// the first one upgrades from cstoreconfsql.FirstSchemaVersion;
+// the latest schema version is the one after the last step.
+//
+var migrationSteps = make(map[string][]sqlschema.MigrationStep)
+
+// SchemaVersionError is returned when a store has a schema version
+// not known by its implementation (usually newer than the latest:
+// the store was upgraded by a newer version of the program).
+type SchemaVersionError struct {
+	ImplName string
+	Version  int
+	Latest   int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("Store schema version %d not known by %q (latest: %d)",
+		e.Version, e.ImplName, e.Latest)
+}
+
+// RegisterMigrationSteps adds the given schema migration steps for
+// the named changes store implementation, after those already registered.
+// Panics if the steps are not in order (see above).
+func RegisterMigrationSteps(implName string, steps ...sqlschema.MigrationStep) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registered := migrationSteps[implName]
+	for _, step := range steps {
+		expected := cstoreconfsql.FirstSchemaVersion + len(registered)
+		if step.FromVersion != expected {
+			panic("cstore: RegisterMigrationSteps for " + implName +
+				": step from version " + strconv.Itoa(step.FromVersion) +
+				", expected from version " + strconv.Itoa(expected))
+		}
+		registered = append(registered, step)
+	}
+	migrationSteps[implName] = registered
+}
+
+// LatestSchemaVersion returns the latest schema version of
+// the named changes store implementation (the one its new stores get).
+func LatestSchemaVersion(implName string) int {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return cstoreconfsql.FirstSchemaVersion + len(migrationSteps[implName])
+}
+
+// MigrationSteps returns the schema migration steps upgrading a store of
+// the named changes store implementation from the given schema version
+// to the latest one, in order (none if the store is up to date).
+//
+// The error returned (if any) is a *SchemaVersionError.
+//
+func MigrationSteps(implName string, fromVersion int) ([]sqlschema.MigrationStep, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registered := migrationSteps[implName]
+	latest := cstoreconfsql.FirstSchemaVersion + len(registered)
+	if fromVersion > latest || fromVersion < cstoreconfsql.FirstSchemaVersion {
+		return nil, &SchemaVersionError{ImplName: implName, Version: fromVersion, Latest: latest}
+	}
+
+	steps := registered[fromVersion-cstoreconfsql.FirstSchemaVersion:]
+	return append([]sqlschema.MigrationStep(nil), steps...), nil
+}
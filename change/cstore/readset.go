@@ -0,0 +1,40 @@
+package cstore
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// ReadSet reads a whole changeset (header and all the change records)
+// from the store; 'found' is false if the store has no such changeset.
+//
+// The records are kept in memory, in the order given by the store's
+// pull source; use MakeCSetRangePullSourceCloser directly to stream
+// a changeset that may be too big.
+//
+func ReadSet(dop ReadingDop, csetID id.IntID) (set *change.Set, found bool, err error) {
+	info, found, err := dop.ReadCSetInfo(csetID)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	src, err := dop.MakeCSetRangePullSourceCloser(csetID, csetID)
+	if err != nil {
+		return nil, true, err
+	}
+	defer src.Close()
+
+	set = &change.Set{SetInfo: info}
+	for {
+		rec, gotRec, err := src.GetNextChangeRec()
+		if err != nil {
+			return nil, true, errors.Wrapf(err, "Failed to read changeset %d", csetID)
+		}
+		if !gotRec {
+			break
+		}
+		set.ChangeRecords = append(set.ChangeRecords, rec)
+	}
+	return set, true, nil
+}
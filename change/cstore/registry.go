@@ -0,0 +1,100 @@
+package cstore
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gimpldo/ba-prototype-go/cstoreconfsql"
+	"github.com/pkg/errors"
+)
+
+// Registry of the changes store implementations (SQLDefFactory by name),
+// populated by the implementation packages from their init functions,
+// as done by 'database/sql' for the drivers: a program only needs
+// to import the implementations it wants to support.
+//
+// The name is the one written by the implementation as 'CStoreImplName'
+// in the configuration table of the stores it creates.
+//
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]SQLDefFactory)
+)
+
+// UnknownImplError is returned when no changes store implementation
+// is registered with the given name.
+type UnknownImplError struct {
+	Name string
+}
+
+func (e *UnknownImplError) Error() string {
+	return fmt.Sprintf("Unknown changes store implementation %q (registered: %v)",
+		e.Name, List())
+}
+
+// Register makes a changes store implementation available by the given name.
+// Panics if called twice with the same name or if the factory is nil.
+func Register(name string, factory SQLDefFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("cstore: Register factory is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("cstore: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the changes store implementation registered
+// with the given name; 'found' is false if there is none.
+func Lookup(name string) (factory SQLDefFactory, found bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, found = registry[name]
+	return factory, found
+}
+
+// List returns the sorted names of the registered changes store implementations.
+func List() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupForStore returns the changes store implementation for an existing
+// store: the one named by 'CStoreImplName' in its configuration table
+// (read with the given prefix).
+//
+// The error returned (if any) is from reading or checking the configuration
+// table (see package 'cstoreconfsql'), or an *UnknownImplError.
+//
+func LookupForStore(db *sql.DB, storePrefix string) (name string, factory SQLDefFactory, err error) {
+	confEntries, err := cstoreconfsql.ReadConfFromDB(db, storePrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	err = cstoreconfsql.CheckOrder(confEntries)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Cannot tell the implementation of store %q", storePrefix)
+	}
+
+	// CheckOrder made sure the implementation name entry is first:
+	name = confEntries[0].ConfValue
+
+	factory, found := Lookup(name)
+	if !found {
+		return name, nil, &UnknownImplError{Name: name}
+	}
+	return name, factory, nil
+}
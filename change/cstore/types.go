@@ -46,6 +46,13 @@ type SQLDef interface {
 	CreateCStoreSchemaElements() (sqlschema.OpReport, error)
 	DropCStoreSchemaElements() (sqlschema.OpReport, error)
 
+	// MigrateCStoreSchema upgrades the schema of an existing store
+	// to the latest version of the implementation, running the migration
+	// steps registered for it (see RegisterMigrationSteps) and recording
+	// the new version in the configuration table, all in one transaction.
+	// Nothing to do (no error) if the store is up to date.
+	MigrateCStoreSchema() (sqlschema.OpReport, error)
+
 	UseCStore() (Dop, error)
 }
 
@@ -55,8 +62,22 @@ type SQLDef interface {
 // and any special needs deriving from the schema used.
 //
 type ReadingDop interface {
+	// MakeCRecPullSourceCloser returns a pull source for all
+	// the change records in the store, in changeset ID order
+	// (all the records of a changeset before those of the next one);
+	// inside a changeset, in the order they were put (as needed by
+	// change.CheckFlagGroups, for instance).
 	MakeCRecPullSourceCloser() (change.RecPullSourceCloser, error)
 
+	// MakeCSetRangePullSourceCloser is like MakeCRecPullSourceCloser
+	// but only for the changesets with IDs in the given range
+	// (both ends inclusive), so a big store can be read in parts.
+	MakeCSetRangePullSourceCloser(firstCSetID, lastCSetID id.IntID) (change.RecPullSourceCloser, error)
+
+	// ReadCSetInfo returns the header (metadata) of the given changeset;
+	// 'found' is false if the store has no such changeset.
+	ReadCSetInfo(csetID id.IntID) (info change.SetInfo, found bool, err error)
+
 	Close()
 }
 
@@ -0,0 +1,82 @@
+package change
+
+import (
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// EditOpCIDPool = allocator of editing operation IDs ('EditOpCID')
+// for a changeset being built: hands out compact IDs (1, 2, 3, ...),
+// unique in the changeset, starting again for each changeset.
+//
+// The zero value is ready to use (for a new, empty changeset).
+//
+type EditOpCIDPool struct {
+	last id.IntID
+}
+
+// Reset makes the pool start again from the first ID,
+// for the next changeset.
+func (p *EditOpCIDPool) Reset() {
+	p.last = id.NoID
+}
+
+// Attach makes the pool continue after the IDs already used
+// by the records of the given changeset (to add more records to it).
+func (p *EditOpCIDPool) Attach(set *Set) {
+	p.Reset()
+	for i := range set.ChangeRecords {
+		if set.ChangeRecords[i].EditOpCID > p.last {
+			p.last = set.ChangeRecords[i].EditOpCID
+		}
+	}
+}
+
+// Take returns a new ID, to be set in all the records
+// representing together one editing operation.
+func (p *EditOpCIDPool) Take() id.IntID {
+	p.last++
+	return p.last
+}
+
+// EditOpGroup = the records of one editing operation
+// (all the records of a changeset with the same 'EditOpCID')
+type EditOpGroup struct {
+	EditOpCID id.IntID
+
+	// Indexes of the records in the slice given to GroupByEditOp,
+	// in increasing order
+	RecIndexes []int
+}
+
+// Recs returns the records of the group, from the slice it was made from.
+func (g *EditOpGroup) Recs(recs []Rec) []Rec {
+	groupRecs := make([]Rec, len(g.RecIndexes))
+	for i, recIndex := range g.RecIndexes {
+		groupRecs[i] = recs[recIndex]
+	}
+	return groupRecs
+}
+
+// GroupByEditOp groups the given records (of one changeset) by 'EditOpCID',
+// so high-level edits (copy, move, swap) can be reconstructed;
+// the groups are in the order of their first records.
+// Records without 'EditOpCID' (id.NoID) are left out.
+func GroupByEditOp(recs []Rec) []EditOpGroup {
+	var groups []EditOpGroup
+	groupIndex := make(map[id.IntID]int)
+
+	for i := range recs {
+		cid := recs[i].EditOpCID
+		if cid == id.NoID {
+			continue
+		}
+		gi, found := groupIndex[cid]
+		if !found {
+			gi = len(groups)
+			groupIndex[cid] = gi
+			groups = append(groups, EditOpGroup{EditOpCID: cid})
+		}
+		groups[gi].RecIndexes = append(groups[gi].RecIndexes, i)
+	}
+	return groups
+}
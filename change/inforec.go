@@ -0,0 +1,120 @@
+package change
+
+import (
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// IsInfoRecType tells if records of the given type only carry
+// information ('ContextRT', 'IdentRT', 'MetaRT'): such records
+// neither change nor check the state, so an applier can skip them,
+// while an exporter or a user interface can show them.
+func IsInfoRecType(recType RecTypeCode) bool {
+	switch recType {
+	case ContextRT, IdentRT, MetaRT:
+		return true
+	}
+	return false
+}
+
+// IsInfoRec tells if the given record is an information record
+// (see IsInfoRecType); usable with FilterPullSource.
+func IsInfoRec(rec *Rec) bool {
+	return IsInfoRecType(rec.ChangeRecType)
+}
+
+// IsNotInfoRec is the opposite of IsInfoRec (for FilterPullSource).
+func IsNotInfoRec(rec *Rec) bool {
+	return !IsInfoRecType(rec.ChangeRecType)
+}
+
+// filteringPullSource implements RecPullSourceCloser
+type filteringPullSource struct {
+	src  RecPullSource
+	keep func(*Rec) bool
+}
+
+// FilterPullSource returns a pull source giving only the records
+// from the given source for which 'keep' returns true; closing it
+// closes the given source, if it is a RecPullSourceCloser.
+//
+// Example: FilterPullSource(src, IsNotInfoRec) for applying,
+// FilterPullSource(src, IsInfoRec) for showing the information records.
+//
+func FilterPullSource(src RecPullSource, keep func(*Rec) bool) RecPullSourceCloser {
+	return &filteringPullSource{src: src, keep: keep}
+}
+
+func (fs *filteringPullSource) GetNextChangeRec() (rec Rec, gotRec bool, err error) {
+	for {
+		rec, gotRec, err = fs.src.GetNextChangeRec()
+		if err != nil || !gotRec || fs.keep(&rec) {
+			return rec, gotRec, err
+		}
+	}
+}
+
+func (fs *filteringPullSource) Close() error {
+	if closer, ok := fs.src.(RecPullSourceCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// InfoBuilder builds information records (see IsInfoRecType)
+// of one type, as usual triples.
+type InfoBuilder struct {
+	b         *SetBuilder
+	recType   RecTypeCode
+	contextID id.IntID
+}
+
+// Meta returns a builder for metadata records ('MetaRT'),
+// usually about the changeset itself.
+func (b *SetBuilder) Meta() *InfoBuilder {
+	return &InfoBuilder{b: b, recType: MetaRT}
+}
+
+// Ident returns a builder for records identifying or describing
+// the nodes referred by the changeset ('IdentRT'), like their labels.
+func (b *SetBuilder) Ident() *InfoBuilder {
+	return &InfoBuilder{b: b, recType: IdentRT}
+}
+
+// Context returns a builder for records describing the given context
+// ('ContextRT'); see also InContext.
+func (b *SetBuilder) Context(contextID id.IntID) *InfoBuilder {
+	return &InfoBuilder{b: b, recType: ContextRT, contextID: contextID}
+}
+
+// InContext makes the following records (except those describing
+// a context) carry the given context ID; id.NoID for none.
+func (b *SetBuilder) InContext(contextID id.IntID) *SetBuilder {
+	b.contextID = contextID
+	return b
+}
+
+func (ib *InfoBuilder) put(subjectID, propID id.IntID, v Rec) *InfoBuilder {
+	v.ChangeRecContextID = ib.contextID
+	ib.b.putTriple(ib.recType, subjectID, propID, v)
+	return ib
+}
+
+// Triple puts a record with an IRI or blank node as object.
+func (ib *InfoBuilder) Triple(subjectID, propID, objectID id.IntID) *InfoBuilder {
+	return ib.put(subjectID, propID, Rec{ObjectID: objectID})
+}
+
+// LangString puts a record with a language-tagged string as object.
+func (ib *InfoBuilder) LangString(subjectID, propID id.IntID, langTag, text string) *InfoBuilder {
+	return ib.put(subjectID, propID, langStringValue(langTag, text))
+}
+
+// TypedLiteral puts a record with a literal of the given datatype
+// (not 'rdf:langString') as object.
+func (ib *InfoBuilder) TypedLiteral(subjectID, propID, datatypeID id.IntID, lexical string) *InfoBuilder {
+	if datatypeID == id.NoID || datatypeID == id.RDFLangStringID {
+		ib.b.fail("Typed literal needs a datatype other than 'rdf:langString' (got %d)", datatypeID)
+		return ib
+	}
+	return ib.put(subjectID, propID, Rec{ValueTypeID: datatypeID, StringVal: lexical})
+}
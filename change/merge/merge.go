@@ -0,0 +1,249 @@
+// change/merge/merge.go: three-way merge of concurrent changesets
+
+package merge
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/apply"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// Merge rebases "theirs" over "ours", both made against the given state
+// (the common ancestor, left unchanged), and returns the merged changeset
+// (with the two changesets as parents) and the conflicts found.
+//
+// Fails if one of the changesets cannot be applied to the ancestor.
+//
+func Merge(ancestor apply.Graph, ours, theirs *change.Set) (*Result, error) {
+	// Positions of 'AppendRT' and 'PrependRT' records
+	// are needed for transforming; also checks that
+	// the changesets apply to the ancestor:
+	oursResolved, err := apply.ResolvePositions(ancestor, ours)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Changeset \"ours\" (%d) does not apply to the ancestor", ours.ID)
+	}
+	theirsResolved, err := apply.ResolvePositions(ancestor, theirs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Changeset \"theirs\" (%d) does not apply to the ancestor", theirs.ID)
+	}
+
+	m := newMerger(oursResolved)
+
+	merged := &change.Set{}
+	for _, parentID := range []id.IntID{ours.ID, theirs.ID} {
+		if parentID != id.NoID {
+			merged.ParentIDs = append(merged.ParentIDs, parentID)
+		}
+	}
+	for _, rec := range ours.ChangeRecords {
+		rec.ChangeSetID = id.NoID
+		merged.ChangeRecords = append(merged.ChangeRecords, rec)
+	}
+
+	result := &Result{Merged: merged}
+	for i := 0; i < len(theirsResolved.ChangeRecords); i++ {
+		rec := theirsResolved.ChangeRecords[i]
+		rec.ChangeSetID = id.NoID
+		if rec.EditOpCID != id.NoID {
+			rec.EditOpCID += m.maxEditOpCID
+		}
+
+		if rec.Form == change.OrdContItem && !isNoOpType(rec.ChangeRecType) {
+			op := change.OrdContOpFromRec(rec)
+			theirsIndex := i
+			if i+1 < len(theirsResolved.ChangeRecords) {
+				next := theirsResolved.ChangeRecords[i+1]
+				next.ChangeSetID = id.NoID
+				if next.EditOpCID != id.NoID {
+					next.EditOpCID += m.maxEditOpCID
+				}
+				if pair, ok := change.PairOrdContOp(rec, next); ok {
+					op = pair
+					i++ // the move or swap is one operation
+				}
+			}
+
+			op = m.rebaseOrdCont(op, theirsIndex, ours, theirs, result)
+			merged.ChangeRecords = append(merged.ChangeRecords, op.Recs()...)
+			continue
+		}
+
+		kind, oursIndex := m.rebase(&rec)
+		if kind != 0 {
+			result.Conflicts = append(result.Conflicts, Conflict{
+				Kind:        kind,
+				OursIndex:   oursIndex,
+				TheirsIndex: i,
+				Ours:        ours.ChangeRecords[oursIndex],
+				Theirs:      theirs.ChangeRecords[i],
+				Dropped:     true,
+			})
+			continue
+		}
+		if oursIndex >= 0 {
+			continue // same change in "ours", nothing left to do
+		}
+		merged.ChangeRecords = append(merged.ChangeRecords, rec)
+	}
+
+	// A record of "theirs" left out can leave the other records
+	// of its editing operation incomplete:
+	apply.UnlinkIncompleteEditOps(merged.ChangeRecords)
+
+	err = apply.Check(ancestor, merged)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Merged changeset (%d over %d) does not apply to the ancestor",
+			theirs.ID, ours.ID)
+	}
+	return result, nil
+}
+
+type tripleChange struct {
+	recType change.RecTypeCode
+	index   int
+}
+
+// merger keeps the changes of "ours" (with the positions transformed
+// over the records of "theirs" rebased so far).
+type merger struct {
+	triples map[apply.Triple]tripleChange
+
+	// Index of the first record changing each binary literal
+	binLits map[id.IntID]int
+
+	containers map[id.IntID][]oursOrdContOp
+
+	maxEditOpCID id.IntID
+}
+
+func newMerger(ours *change.Set) *merger {
+	m := &merger{
+		triples:    make(map[apply.Triple]tripleChange),
+		binLits:    make(map[id.IntID]int),
+		containers: make(map[id.IntID][]oursOrdContOp),
+	}
+
+	for i := 0; i < len(ours.ChangeRecords); i++ {
+		rec := &ours.ChangeRecords[i]
+		if rec.EditOpCID > m.maxEditOpCID {
+			m.maxEditOpCID = rec.EditOpCID
+		}
+		if isNoOpType(rec.ChangeRecType) {
+			continue
+		}
+
+		switch rec.Form {
+		case change.UsualTriple:
+			propID, _ := rec.Prop.ID()
+			t := apply.Triple{SubjectID: rec.SubjectID, PropID: propID, Value: apply.ValueOf(rec)}
+			m.triples[t] = tripleChange{recType: rec.ChangeRecType, index: i}
+		case change.IDLitBin:
+			if _, found := m.binLits[rec.SubjectID]; !found {
+				m.binLits[rec.SubjectID] = i
+			}
+		case change.OrdContItem:
+			op := oursOrdContOp{OrdContOp: change.OrdContOpFromRec(*rec), index: i}
+			if i+1 < len(ours.ChangeRecords) {
+				if pair, ok := change.PairOrdContOp(*rec, ours.ChangeRecords[i+1]); ok {
+					op.OrdContOp = pair
+					i++
+				}
+			}
+			m.containers[rec.SubjectID] = append(m.containers[rec.SubjectID], op)
+		}
+	}
+	return m
+}
+
+// rebase transforms the given record of "theirs" to apply after "ours";
+// returns the conflict kind (0 if none) and the index of the record
+// of "ours" it conflicts with, or that makes the same change
+// (-1 if none).
+func (m *merger) rebase(rec *change.Rec) (ConflictKind, int) {
+	if isNoOpType(rec.ChangeRecType) {
+		return 0, -1
+	}
+
+	switch rec.Form {
+	case change.UsualTriple:
+		propID, _ := rec.Prop.ID()
+		t := apply.Triple{SubjectID: rec.SubjectID, PropID: propID, Value: apply.ValueOf(rec)}
+		oursChange, found := m.triples[t]
+		switch {
+		case !found:
+			return 0, -1
+		case oursChange.recType == rec.ChangeRecType:
+			return 0, oursChange.index
+		default:
+			return AddDelCK, oursChange.index
+		}
+
+	case change.IDLitBin:
+		if i, found := m.binLits[rec.SubjectID]; found {
+			return BinLiteralCK, i
+		}
+		return 0, -1
+
+	}
+	return 0, -1
+}
+
+// rebaseOrdCont transforms the given operation of "theirs" to apply
+// after the operations of "ours" on the same container (which are
+// transformed to apply after it); clashes go into the result's conflicts.
+func (m *merger) rebaseOrdCont(t change.OrdContOp, theirsIndex int,
+	ours, theirs *change.Set, result *Result) change.OrdContOp {
+
+	ops := m.containers[t.Rec.SubjectID]
+	for j := range ops {
+		o := &ops[j]
+		if t.Kind == change.NoOpOC {
+			break
+		}
+
+		oBefore, tBefore := o.OrdContOp, t
+		var clash bool
+		o.OrdContOp, t, clash = change.TransformOrdCont(o.OrdContOp, t, true)
+		if !clash {
+			continue
+		}
+
+		var kind ConflictKind
+		switch {
+		case oBefore.Kind == change.SwapOC || tBefore.Kind == change.SwapOC:
+			kind = SwapCK
+		case oBefore.Kind == change.MoveOC || tBefore.Kind == change.MoveOC:
+			kind = MoveCK
+		case oBefore.Kind == change.ReplaceOC && tBefore.Kind == change.ReplaceOC:
+			if apply.ValueOf(&oBefore.Rec) == apply.ValueOf(&tBefore.Rec) {
+				continue // same replacement
+			}
+			kind = ReplaceReplaceCK
+		default:
+			kind = RemoveReplaceCK
+		}
+		result.Conflicts = append(result.Conflicts, Conflict{
+			Kind:        kind,
+			OursIndex:   o.index,
+			TheirsIndex: theirsIndex,
+			Ours:        ours.ChangeRecords[o.index],
+			Theirs:      theirs.ChangeRecords[theirsIndex],
+			Dropped:     t.Kind == change.NoOpOC,
+		})
+	}
+	return t
+}
+
+// oursOrdContOp = operation of "ours" on a container, as transformed
+// over the operations of "theirs" rebased so far
+type oursOrdContOp struct {
+	change.OrdContOp
+
+	// Index of the (first) record of the operation
+	index int
+}
+
+func isNoOpType(recType change.RecTypeCode) bool {
+	return recType == change.TestRT || change.IsInfoRecType(recType)
+}
@@ -0,0 +1,311 @@
+package merge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/apply"
+	"github.com/gimpldo/ba-prototype-go/change/changetest"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// The changeset IDs used in the tests
+const (
+	ancestorCSetID id.IntID = 1
+	oursCSetID     id.IntID = 2
+	theirsCSetID   id.IntID = 3
+	mergedCSetID   id.IntID = 4
+)
+
+// newAncestor returns a state with a triple (1, 2, 3), a container 10
+// with items [100, 101, 102] and a binary literal 20 with "abc".
+func newAncestor(t *testing.T) *apply.MemGraph {
+	g := apply.NewMemGraph()
+	err := apply.NewApplier(g).ApplySet(changetest.BuildSet(t, ancestorCSetID, func(b *change.SetBuilder) {
+		b.AddTriple(1, 2, 3)
+		b.Container(10).Append(100).Append(101).Append(102)
+		b.TextLiteral(20).Create("abc")
+	}))
+	if err != nil {
+		t.Fatalf("Failed to make ancestor: %v", err)
+	}
+	return g
+}
+
+// mergeAndApply merges the changesets built by the given functions,
+// checks the merged changeset and returns the state after applying it
+// to the ancestor, and the merge result.
+func mergeAndApply(t *testing.T, ours, theirs func(b *change.SetBuilder)) (*apply.MemGraph, *Result) {
+	t.Helper()
+
+	g := newAncestor(t)
+	oursSet := changetest.BuildSet(t, oursCSetID, ours)
+	theirsSet := changetest.BuildSet(t, theirsCSetID, theirs)
+	result, err := Merge(g, oursSet, theirsSet)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	merged := result.Merged
+	if !reflect.DeepEqual(merged.ParentIDs, []id.IntID{oursCSetID, theirsCSetID}) {
+		t.Errorf("Merged changeset parents %v", merged.ParentIDs)
+	}
+	merged.ID = mergedCSetID
+	err = change.ValidateSet(merged)
+	if err != nil {
+		t.Errorf("Invalid merged changeset: %v\n%+v", err, merged.ChangeRecords)
+	}
+	err = apply.NewApplier(g).ApplySet(merged)
+	if err != nil {
+		t.Fatalf("ApplySet of merged changeset failed: %v\n%+v", err, merged.ChangeRecords)
+	}
+	return g, result
+}
+
+func items(objectIDs ...id.IntID) []apply.Value {
+	values := make([]apply.Value, len(objectIDs))
+	for i, objectID := range objectIDs {
+		values[i] = apply.Value{ObjectID: objectID}
+	}
+	return values
+}
+
+func tripleWith(objectID id.IntID) apply.Triple {
+	return apply.Triple{SubjectID: 1, PropID: 2, Value: apply.Value{ObjectID: objectID}}
+}
+
+func TestMergeDisjoint(t *testing.T) {
+	g, result := mergeAndApply(t,
+		func(b *change.SetBuilder) {
+			b.AddTriple(1, 2, 4).AddTriple(1, 2, 5)
+			b.Container(10).Append(200)
+		},
+		func(b *change.SetBuilder) {
+			b.DeleteTriple(1, 2, 3).AddTriple(1, 2, 5) // same addition as ours
+			b.Container(11).Append(300)
+			b.TextLiteral(21).Create("new")
+		})
+
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts in disjoint merge: %v", result.Conflicts)
+	}
+	if got, want := g.Triples(), []apply.Triple{tripleWith(4), tripleWith(5)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Triples after merge:\n got  %+v\n want %+v", got, want)
+	}
+	if got, want := g.Container(10), items(100, 101, 102, 200); !reflect.DeepEqual(got, want) {
+		t.Errorf("Container 10 after merge: %v, want %v", got, want)
+	}
+	if got, want := g.Container(11), items(300); !reflect.DeepEqual(got, want) {
+		t.Errorf("Container 11 after merge: %v, want %v", got, want)
+	}
+	if data, _, _ := g.BinLiteral(21); string(data) != "new" {
+		t.Errorf("Literal 21 after merge: %q", data)
+	}
+}
+
+func TestMergeContainer(t *testing.T) {
+	// On container 10 = [100, 101, 102]
+	tests := []struct {
+		name          string
+		ours, theirs  func(cb *change.ContainerBuilder)
+		want          []apply.Value
+		conflicts     []ConflictKind
+		theirsDropped bool
+	}{
+		{"insertions",
+			func(cb *change.ContainerBuilder) { cb.InsertAt(0, 200) },
+			func(cb *change.ContainerBuilder) { cb.InsertAt(2, 300) },
+			items(200, 100, 101, 300, 102), nil, false},
+		{"insertions at the same position",
+			func(cb *change.ContainerBuilder) { cb.InsertAt(1, 200) },
+			func(cb *change.ContainerBuilder) { cb.InsertAt(1, 300) },
+			items(100, 200, 300, 101, 102), nil, false},
+		{"appends",
+			func(cb *change.ContainerBuilder) { cb.Append(200) },
+			func(cb *change.ContainerBuilder) { cb.Append(300).Prepend(301) },
+			items(301, 100, 101, 102, 200, 300), nil, false},
+		{"removal and replacement of other items",
+			func(cb *change.ContainerBuilder) { cb.RemoveAt(0) },
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(2, 302) },
+			items(101, 302), nil, false},
+		{"same replacement",
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(1, 201) },
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(1, 201) },
+			items(100, 201, 102), nil, false},
+		{"different replacements",
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(1, 201) },
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(1, 301) },
+			items(100, 201, 102), []ConflictKind{ReplaceReplaceCK}, true},
+		{"removal and replacement",
+			func(cb *change.ContainerBuilder) { cb.RemoveAt(1) },
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(1, 301) },
+			items(100, 102), []ConflictKind{RemoveReplaceCK}, true},
+		{"moves of other items",
+			func(cb *change.ContainerBuilder) { cb.Move(0, 2, 100) },
+			func(cb *change.ContainerBuilder) { cb.Move(2, 0, 102) },
+			items(102, 101, 100), nil, false},
+		{"moves of the same item",
+			func(cb *change.ContainerBuilder) { cb.Move(0, 2, 100) },
+			func(cb *change.ContainerBuilder) { cb.Move(0, 1, 100) },
+			items(101, 102, 100), []ConflictKind{MoveCK}, true},
+		{"removal and swap",
+			func(cb *change.ContainerBuilder) { cb.RemoveAt(0) },
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 1, 101) },
+			items(101, 102), []ConflictKind{SwapCK}, true},
+		{"swap and removal",
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 1, 101) },
+			func(cb *change.ContainerBuilder) { cb.RemoveAt(0) },
+			items(101, 102), []ConflictKind{SwapCK}, false},
+		{"move and swap",
+			func(cb *change.ContainerBuilder) { cb.Move(0, 2, 100) },
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 1, 101) },
+			items(101, 102, 100), []ConflictKind{SwapCK}, true},
+		{"swap and replacement",
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 1, 101) },
+			func(cb *change.ContainerBuilder) { cb.ReplaceAt(0, 300) },
+			items(101, 300, 102), nil, false},
+		{"swaps of the same items",
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 2, 102) },
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 2, 102) },
+			items(102, 101, 100), nil, false},
+		{"swaps sharing an item",
+			func(cb *change.ContainerBuilder) { cb.Swap(0, 100, 1, 101) },
+			func(cb *change.ContainerBuilder) { cb.Swap(1, 101, 2, 102) },
+			items(101, 102, 100), []ConflictKind{SwapCK}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, result := mergeAndApply(t,
+				func(b *change.SetBuilder) { tt.ours(b.Container(10)) },
+				func(b *change.SetBuilder) { tt.theirs(b.Container(10)) })
+
+			if got := g.Container(10); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Container after merge: %v, want %v", got, tt.want)
+			}
+			var kinds []ConflictKind
+			for _, c := range result.Conflicts {
+				kinds = append(kinds, c.Kind)
+				if c.Dropped != tt.theirsDropped {
+					t.Errorf("Conflict %v: dropped %v", &c, c.Dropped)
+				}
+			}
+			if !reflect.DeepEqual(kinds, tt.conflicts) {
+				t.Errorf("Conflicts %v, want %v", kinds, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestMergeConflictingTriple(t *testing.T) {
+	g, result := mergeAndApply(t,
+		func(b *change.SetBuilder) { b.DeleteTriple(1, 2, 3).AddTriple(1, 2, 3) },
+		func(b *change.SetBuilder) { b.AddTriple(1, 2, 4).DeleteTriple(1, 2, 3) })
+
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("Conflicts %v, want one", result.Conflicts)
+	}
+	c := result.Conflicts[0]
+	if c.Kind != AddDelCK || !c.Dropped || c.OursIndex != 1 || c.TheirsIndex != 1 {
+		t.Errorf("Conflict %v (dropped %v)", &c, c.Dropped)
+	}
+	if got, want := g.Triples(), []apply.Triple{tripleWith(3), tripleWith(4)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Triples after merge:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestMergeBinLiteral(t *testing.T) {
+	g, result := mergeAndApply(t,
+		func(b *change.SetBuilder) { b.TextLiteral(20).AppendText("d") },
+		func(b *change.SetBuilder) {
+			b.TextLiteral(20).InsertText(0, "x")
+			b.TextLiteral(21).Create("theirs")
+		})
+
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("Conflicts %v, want one", result.Conflicts)
+	}
+	c := result.Conflicts[0]
+	if c.Kind != BinLiteralCK || !c.Dropped || c.OursIndex != 0 || c.TheirsIndex != 0 {
+		t.Errorf("Conflict %v (dropped %v)", &c, c.Dropped)
+	}
+	if data, _, _ := g.BinLiteral(20); string(data) != "abcd" {
+		t.Errorf("Literal 20 after merge: %q, want only the change of ours", data)
+	}
+	if data, _, _ := g.BinLiteral(21); string(data) != "theirs" {
+		t.Errorf("Literal 21 after merge: %q, want the change of theirs", data)
+	}
+}
+
+func TestMergeEditOpCID(t *testing.T) {
+	g, result := mergeAndApply(t,
+		func(b *change.SetBuilder) {
+			b.Container(10).Move(0, 2, 100).Swap(0, 101, 1, 102)
+		},
+		func(b *change.SetBuilder) {
+			b.Container(11).Append(300).Append(301).Move(1, 0, 301)
+			b.Container(12).Append(310).Append(311).Swap(0, 310, 1, 311)
+		})
+
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts %v", result.Conflicts)
+	}
+
+	// Editing operation IDs of "ours" kept, those of "theirs"
+	// offset after them; the operations stay distinct:
+	bySubject := make(map[id.IntID][]id.IntID)
+	for _, group := range change.GroupByEditOp(result.Merged.ChangeRecords) {
+		rec := &result.Merged.ChangeRecords[group.RecIndexes[0]]
+		bySubject[rec.SubjectID] = append(bySubject[rec.SubjectID], group.EditOpCID)
+		if len(group.RecIndexes) != 2 {
+			t.Errorf("Editing operation %d has %d records", group.EditOpCID, len(group.RecIndexes))
+		}
+	}
+	want := map[id.IntID][]id.IntID{10: {1, 2}, 11: {3}, 12: {4}}
+	if !reflect.DeepEqual(bySubject, want) {
+		t.Errorf("Editing operations by container %v, want %v", bySubject, want)
+	}
+
+	for subjectID, want := range map[id.IntID][]apply.Value{
+		10: items(102, 101, 100),
+		11: items(301, 300),
+		12: items(311, 310),
+	} {
+		if got := g.Container(subjectID); !reflect.DeepEqual(got, want) {
+			t.Errorf("Container %d after merge: %v, want %v", subjectID, got, want)
+		}
+	}
+}
+
+func TestMergeIncompleteEditOp(t *testing.T) {
+	g, result := mergeAndApply(t,
+		func(b *change.SetBuilder) { b.AddTriple(4, 2, 3) },
+		func(b *change.SetBuilder) {
+			// Copy of (1, 2, 3) to subject 4; the addition
+			// is the same as ours:
+			cid := b.TakeEditOpCID()
+			b.Put(change.Rec{Form: change.UsualTriple, ChangeRecType: change.TestRT,
+				SubjectID: 1, Prop: id.FromID(2), ObjectID: 3,
+				ChangeRecFlags: change.CopyingRF, EditOpCID: cid})
+			b.Put(change.Rec{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+				SubjectID: 4, Prop: id.FromID(2), ObjectID: 3,
+				ChangeRecFlags: change.CopyingRF, EditOpCID: cid})
+		})
+
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts %v", result.Conflicts)
+	}
+	// The test record left of the copy is no longer an operation:
+	for i, rec := range result.Merged.ChangeRecords {
+		if rec.ChangeRecFlags != 0 || rec.EditOpCID != id.NoID {
+			t.Errorf("Merged record #%d: flags %v, editing operation %d",
+				i, rec.ChangeRecFlags, rec.EditOpCID)
+		}
+	}
+	want := []apply.Triple{tripleWith(3),
+		{SubjectID: 4, PropID: 2, Value: apply.Value{ObjectID: 3}}}
+	if got := g.Triples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Triples after merge:\n got  %+v\n want %+v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Package merge combines two changesets made concurrently against
+// the same (common ancestor) state into one changeset: "theirs"
+// is rebased over "ours".
+//
+// The records of "ours" are kept unchanged; the records of "theirs"
+// are transformed to apply after them (positions in order-preserving
+// containers shifted over the insertions and removals of "ours").
+// When the two changesets disagree, "ours" wins (mostly): the record
+// of "theirs" is left out of the merged changeset and reported as a Conflict.
+// Container positions are transformed as defined by change.TransformOrdCont.
+// What is left of an editing operation of "theirs" missing some of its
+// records loses its operation flags and 'EditOpCID'
+// (see apply.UnlinkIncompleteEditOps).
+//
+package merge
+
+import (
+	"fmt"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+)
+
+// ConflictKind tells how two changesets disagree.
+type ConflictKind int
+
+// The trailing 'CK' in constant names stands for "Conflict Kind".
+const (
+	// One adds a triple, the other deletes it
+	AddDelCK ConflictKind = iota + 1
+
+	// Both replace the same container item, with different values
+	ReplaceReplaceCK
+
+	// One removes a container item, the other replaces it
+	RemoveReplaceCK
+
+	// Both change the same binary literal
+	// (concurrent binary edits are not transformed)
+	BinLiteralCK
+
+	// One moves a container item, the other moves or removes it
+	MoveCK
+
+	// One swaps two container items, the other removes, moves
+	// or swaps one of them
+	SwapCK
+)
+
+var conflictKindNames = [...]string{
+	AddDelCK:         "triple added by one side, deleted by the other",
+	ReplaceReplaceCK: "container item replaced differently by both sides",
+	RemoveReplaceCK:  "container item removed by one side, replaced by the other",
+	BinLiteralCK:     "binary literal changed by both sides",
+	MoveCK:           "container item moved by one side, moved or removed by the other",
+	SwapCK:           "container item swapped by one side, removed, moved or swapped by the other",
+}
+
+func (k ConflictKind) String() string {
+	if k > 0 && int(k) < len(conflictKindNames) {
+		return conflictKindNames[k]
+	}
+	return fmt.Sprintf("ConflictKind(%d)", int(k))
+}
+
+// Conflict = a record of "theirs" that disagrees with a record of "ours".
+type Conflict struct {
+	Kind ConflictKind
+
+	// Indexes in the 'ChangeRecords' of the given changesets
+	// (for a move, the index of the pair's first record)
+	OursIndex   int
+	TheirsIndex int
+
+	// The records as given (not transformed)
+	Ours   change.Rec
+	Theirs change.Rec
+
+	// The change of "theirs" is left out of the merged changeset;
+	// otherwise it is kept, the change of "ours" being altered
+	// (see change.TransformOrdCont: a removal wins over a move).
+	Dropped bool
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("%v: ours #%d, theirs #%d (subject %d)",
+		c.Kind, c.OursIndex, c.TheirsIndex, c.Theirs.SubjectID)
+}
+
+// Result = merged changeset and the conflicts found
+type Result struct {
+	Merged *change.Set
+
+	// In the order of the records of "theirs"
+	Conflicts []Conflict
+}
@@ -0,0 +1,577 @@
+package change
+
+import (
+	"fmt"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// Operational transformation (OT) for order-preserving container items
+// (change records with Form = OrdContItem).
+//
+// Positions are dense indexes, valid in the state the record applies to;
+// when two changes are made concurrently (against the same state),
+// one of them must be transformed to apply after the other:
+// its position shifted over the other's insertion or removal,
+// or the change dropped / altered when both touch the same item.
+//
+// The transformation is done on OrdContOp values (one logical change
+// each: a move is one operation, made of a 'RemoveAtRT' + 'InsertAtRT'
+// reordering pair of records; a swap is one operation, made of two
+// 'ReplaceAtRT' records with 'SwappingRF').
+//
+// Convergence: for any two operations 'a' and 'b' made against the same
+// state, applying 'a' then b' gives the same result as applying 'b' then a',
+// where (a', b') = TransformOrdCont(a, b, aWins); see TestOrdContConvergence.
+//
+// When the two operations clash, the one that "wins" keeps its effect:
+//
+//   - insertions at the same position: the winner's item comes first;
+//   - both replace the same item: the winner's value stays;
+//   - one removes an item, the other replaces it: if the replacement wins,
+//     the item (with the new value) is inserted back;
+//   - both move the same item: the winner's destination is kept;
+//   - one removes an item, the other moves it: the removal wins, always
+//     (a move is not a reason to keep a deleted item);
+//   - one replaces an item, the other moves it: both effects are kept
+//     (the replacement follows the item);
+//   - one swaps two items, the other removes or moves one of them:
+//     the removal or move wins, always; the other swapped item
+//     takes the place of the item removed or moved away (the swap
+//     becomes a move of that item);
+//   - one swaps two items, the other replaces one of them: both effects
+//     are kept (the replacement follows the item);
+//   - both swap an item with different items: the winner's item
+//     ends where the winner puts it, the other two items take
+//     the other two places.
+//
+// Both remove the same item, or swap the same items: it is done once
+// (no clash).
+//
+// What is left of a move or a swap that lost one of its records
+// is no longer an editing operation: the remaining record has no
+// operation flag and no 'EditOpCID' (see Recs).
+//
+
+// OrdContOpKind = kind of change to an order-preserving container
+type OrdContOpKind int
+
+// The trailing 'OC' in constant names stands for "Order-preserving Container".
+const (
+	NoOpOC OrdContOpKind = iota
+	InsertOC
+	RemoveOC
+	ReplaceOC
+	MoveOC
+	SwapOC
+)
+
+var ordContOpKindNames = [...]string{
+	NoOpOC:    "NoOp",
+	InsertOC:  "Insert",
+	RemoveOC:  "Remove",
+	ReplaceOC: "Replace",
+	MoveOC:    "Move",
+	SwapOC:    "Swap",
+}
+
+func (k OrdContOpKind) String() string {
+	if k >= 0 && int(k) < len(ordContOpKindNames) {
+		return ordContOpKindNames[k]
+	}
+	return fmt.Sprintf("OrdContOpKind(%d)", int(k))
+}
+
+// OrdContOp = one logical change to an order-preserving container
+type OrdContOp struct {
+	Kind OrdContOpKind
+
+	// Position of the item inserted, removed or replaced;
+	// for a move, the destination = position in the container
+	// without the moved item (as for the 'InsertAtRT' record of the pair);
+	// for a swap, the position of its first record.
+	Pos int64
+
+	// Source position of a move, position of the second record
+	// of a swap (unused for other kinds)
+	From int64
+
+	// Record carrying the container subject, the item value, and the other
+	// fields to keep (flags, IDs); for a move, the 'InsertAtRT' record;
+	// for a swap, the first record (the item put at Pos).
+	// Its type and positions are not used (see Recs).
+	Rec Rec
+
+	// Second record of a swap (the item put at From);
+	// unused for other kinds
+	Rec2 Rec
+}
+
+// OrdContOpFromRec returns the operation of a single change record;
+// for 'AppendRT' and 'PrependRT', the record's position must be
+// the actual one (as resolved for the state the record applies to).
+// Records that do not change the container give NoOpOC.
+func OrdContOpFromRec(rec Rec) OrdContOp {
+	op := OrdContOp{Rec: rec}
+	op.Pos, _ = rec.Prop.Pos()
+
+	switch rec.ChangeRecType {
+	case InsertAtRT, AppendRT:
+		op.Kind = InsertOC
+	case PrependRT:
+		op.Kind = InsertOC
+		op.Pos = 0
+	case RemoveAtRT:
+		op.Kind = RemoveOC
+	case ReplaceAtRT:
+		op.Kind = ReplaceOC
+	}
+	return op
+}
+
+// MoveOrdContOp returns the move operation represented by the given
+// reordering pair of records ('ok' is false if not a reordering pair:
+// 'RemoveAtRT' then 'InsertAtRT' for the same container, same 'EditOpCID',
+// with 'ReorderingRF' set on both).
+func MoveOrdContOp(remove, insert Rec) (op OrdContOp, ok bool) {
+	if remove.Form != OrdContItem || insert.Form != OrdContItem ||
+		remove.ChangeRecType != RemoveAtRT || insert.ChangeRecType != InsertAtRT ||
+		remove.SubjectID != insert.SubjectID ||
+		remove.EditOpCID == id.NoID || remove.EditOpCID != insert.EditOpCID ||
+		remove.ChangeRecFlags&ReorderingRF == 0 || insert.ChangeRecFlags&ReorderingRF == 0 {
+		return op, false
+	}
+	from, okFrom := remove.Prop.Pos()
+	to, okTo := insert.Prop.Pos()
+	if !okFrom || !okTo {
+		return op, false
+	}
+	return OrdContOp{Kind: MoveOC, Pos: to, From: from, Rec: insert}, true
+}
+
+// SwapOrdContOp returns the swap operation represented by the given
+// records ('ok' is false if not a swap: two 'ReplaceAtRT' records
+// for the same container, at different positions, same 'EditOpCID',
+// with 'SwappingRF' set on both).
+func SwapOrdContOp(first, second Rec) (op OrdContOp, ok bool) {
+	if first.Form != OrdContItem || second.Form != OrdContItem ||
+		first.ChangeRecType != ReplaceAtRT || second.ChangeRecType != ReplaceAtRT ||
+		first.SubjectID != second.SubjectID ||
+		first.EditOpCID == id.NoID || first.EditOpCID != second.EditOpCID ||
+		first.ChangeRecFlags&SwappingRF == 0 || second.ChangeRecFlags&SwappingRF == 0 {
+		return op, false
+	}
+	pos1, ok1 := first.Prop.Pos()
+	pos2, ok2 := second.Prop.Pos()
+	if !ok1 || !ok2 || pos1 == pos2 {
+		return op, false
+	}
+	return OrdContOp{Kind: SwapOC, Pos: pos1, From: pos2, Rec: first, Rec2: second}, true
+}
+
+// PairOrdContOp returns the operation made of two records: a move
+// (see MoveOrdContOp) or a swap (see SwapOrdContOp); 'ok' is false
+// if neither.
+func PairOrdContOp(first, second Rec) (op OrdContOp, ok bool) {
+	if op, ok = MoveOrdContOp(first, second); ok {
+		return op, true
+	}
+	return SwapOrdContOp(first, second)
+}
+
+// OrdContOps groups the given records (of one or more containers)
+// into operations, in the same order: the two records of a reordering
+// pair or of a swap (see PairOrdContOp) must be consecutive.
+func OrdContOps(recs []Rec) []OrdContOp {
+	ops := make([]OrdContOp, 0, len(recs))
+	for i := 0; i < len(recs); i++ {
+		if i+1 < len(recs) {
+			if op, ok := PairOrdContOp(recs[i], recs[i+1]); ok {
+				ops = append(ops, op)
+				i++
+				continue
+			}
+		}
+		ops = append(ops, OrdContOpFromRec(recs[i]))
+	}
+	return ops
+}
+
+// Recs returns the change records representing the operation
+// (none for NoOpOC, two for MoveOC and SwapOC).
+//
+// The record of a single-record operation made from a move or a swap
+// (what is left of it after a transformation) loses the reordering
+// and swapping flags and its 'EditOpCID'.
+//
+func (op OrdContOp) Recs() []Rec {
+	rec := op.Rec
+	rec.Form = OrdContItem
+	rec.Prop = id.FromPos(op.Pos)
+	rec.OldProp = 0
+
+	if op.Kind != MoveOC && op.Kind != SwapOC && rec.ChangeRecFlags&(ReorderingRF|SwappingRF) != 0 {
+		rec.ChangeRecFlags &^= ReorderingRF | SwappingRF
+		rec.EditOpCID = id.NoID
+	}
+
+	switch op.Kind {
+	case InsertOC:
+		rec.ChangeRecType = InsertAtRT
+	case RemoveOC:
+		rec.ChangeRecType = RemoveAtRT
+	case ReplaceOC:
+		rec.ChangeRecType = ReplaceAtRT
+	case MoveOC:
+		remove := rec
+		remove.ChangeRecType = RemoveAtRT
+		remove.Prop = id.FromPos(op.From)
+		remove.ChangeRecFlags |= ReorderingRF
+
+		insert := rec
+		insert.ChangeRecType = InsertAtRT
+		insert.OldProp = id.FromPos(op.From)
+		insert.ChangeRecFlags |= ReorderingRF
+		return []Rec{remove, insert}
+	case SwapOC:
+		rec.ChangeRecType = ReplaceAtRT
+		rec.ChangeRecFlags |= SwappingRF
+
+		second := op.Rec2
+		second.Form = OrdContItem
+		second.ChangeRecType = ReplaceAtRT
+		second.Prop = id.FromPos(op.From)
+		second.OldProp = 0
+		second.ChangeRecFlags |= SwappingRF
+		return []Rec{rec, second}
+	default:
+		return nil
+	}
+	return []Rec{rec}
+}
+
+// TransformOrdCont transforms two operations made concurrently (against
+// the same state of the same container): a' = 'a' to apply after 'b',
+// b' = 'b' to apply after 'a'. 'aWins' decides the clashes (see above);
+// 'clash' is true if the effect of one of the operations was dropped
+// or altered because of the other (not for mere position shifts).
+//
+// Operations on different containers are returned unchanged.
+//
+func TransformOrdCont(a, b OrdContOp, aWins bool) (aPrime, bPrime OrdContOp, clash bool) {
+	if a.Kind == NoOpOC || b.Kind == NoOpOC || a.Rec.SubjectID != b.Rec.SubjectID {
+		return a, b, false
+	}
+
+	if a.Kind == SwapOC {
+		return transformSwap(a, b, aWins)
+	}
+	if b.Kind == SwapOC {
+		bPrime, aPrime, clash = transformSwap(b, a, !aWins)
+		return aPrime, bPrime, clash
+	}
+
+	// Both change the same item, at least one of them moving it:
+	if b.Kind == MoveOC && a.Kind != MoveOC {
+		if bPrime, aPrime, clash, ok := transformMoveSameItem(b, a, !aWins); ok {
+			return aPrime, bPrime, clash
+		}
+	} else if a.Kind == MoveOC {
+		if aPrime, bPrime, clash, ok := transformMoveSameItem(a, b, aWins); ok {
+			return aPrime, bPrime, clash
+		}
+	}
+
+	// All other cases: decompose (a move is a removal then an insertion)
+	// and transform the sequences of primitive changes.
+	aPrims := a.prims()
+	bPrims := b.prims()
+	for i := range aPrims {
+		for j := range bPrims {
+			ai, bj := aPrims[i], bPrims[j]
+			var c1, c2 bool
+			aPrims[i], c1 = transformPrim(ai, bj, aWins)
+			bPrims[j], c2 = transformPrim(bj, ai, !aWins)
+			clash = clash || c1 || c2
+		}
+	}
+	return a.fromPrims(aPrims), b.fromPrims(bPrims), clash
+}
+
+// transformMoveSameItem handles a move ('m') and another operation ('o')
+// changing the same item; 'ok' is false if not the same item.
+func transformMoveSameItem(m, o OrdContOp, mWins bool) (mPrime, oPrime OrdContOp, clash, ok bool) {
+	oItemPos := o.Pos
+	switch o.Kind {
+	case InsertOC, NoOpOC:
+		return m, o, false, false
+	case MoveOC:
+		oItemPos = o.From
+	}
+	if oItemPos != m.From {
+		return m, o, false, false
+	}
+
+	mPrime, oPrime = m, o
+	switch o.Kind {
+	case RemoveOC:
+		// The removal always wins; it follows the moved item:
+		mPrime.Kind = NoOpOC
+		oPrime.Pos = m.Pos
+		return mPrime, oPrime, true, true
+
+	case ReplaceOC:
+		// Both kept: the replacement follows the moved item,
+		// the move carries the new value.
+		oPrime.Pos = m.Pos
+		setItemValue(&mPrime.Rec, o.Rec)
+		return mPrime, oPrime, false, true
+
+	default: // MoveOC
+		if m.Pos == o.Pos {
+			mPrime.Kind, oPrime.Kind = NoOpOC, NoOpOC
+			return mPrime, oPrime, false, true
+		}
+		// The winner moves the item again, from where the loser put it:
+		if mWins {
+			mPrime.From = o.Pos
+			oPrime.Kind = NoOpOC
+		} else {
+			oPrime.From = m.Pos
+			mPrime.Kind = NoOpOC
+		}
+		return mPrime, oPrime, true, true
+	}
+}
+
+// transformSwap transforms a swap ('s') and another operation ('o')
+// made concurrently (see TransformOrdCont).
+func transformSwap(s, o OrdContOp, sWins bool) (sPrime, oPrime OrdContOp, clash bool) {
+	sPrime, oPrime = s, o
+
+	switch o.Kind {
+	case InsertOC:
+		// Positions shifted, as for replacements:
+		sPrime.Pos += b2i(s.Pos >= o.Pos)
+		sPrime.From += b2i(s.From >= o.Pos)
+		return sPrime, oPrime, false
+
+	case RemoveOC:
+		if !s.isSwapping(o.Pos) {
+			sPrime.Pos -= b2i(s.Pos > o.Pos)
+			sPrime.From -= b2i(s.From > o.Pos)
+			return sPrime, oPrime, false
+		}
+		// The removal follows the item; the other item takes its place:
+		oPrime.Pos = s.otherSwapped(o.Pos)
+		sPrime, _ = s.swapToMove(o.Pos, -1)
+		return sPrime, oPrime, true
+
+	case ReplaceOC:
+		// The replacement follows the item, the swap carries the new value:
+		switch o.Pos {
+		case s.Pos:
+			oPrime.Pos = s.From
+			setItemValue(&sPrime.Rec2, o.Rec)
+		case s.From:
+			oPrime.Pos = s.Pos
+			setItemValue(&sPrime.Rec, o.Rec)
+		}
+		return sPrime, oPrime, false
+
+	case MoveOC:
+		if !s.isSwapping(o.From) {
+			// Positions mapped over the removal, then the insertion:
+			for _, pos := range []*int64{&sPrime.Pos, &sPrime.From} {
+				*pos -= b2i(*pos > o.From)
+				*pos += b2i(*pos >= o.Pos)
+			}
+			return sPrime, oPrime, false
+		}
+		// The move follows the item; the other item takes its place:
+		oPrime.From = s.otherSwapped(o.From)
+		sPrime, oPrime.Pos = s.swapToMove(o.From, o.Pos)
+		return sPrime, oPrime, true
+
+	default: // SwapOC
+		if !s.isSwapping(o.Pos) && !s.isSwapping(o.From) {
+			return sPrime, oPrime, false
+		}
+		if s.isSwapping(o.Pos) && s.isSwapping(o.From) {
+			// Same items swapped: done once.
+			sPrime.Kind, oPrime.Kind = NoOpOC, NoOpOC
+			return sPrime, oPrime, false
+		}
+
+		// One item ('shared', at the same position) swapped with
+		// a different item by each: the winner's item goes where
+		// the winner puts it.
+		w, l := s, o
+		if !sWins {
+			w, l = o, s
+		}
+		shared := w.Pos
+		if !l.isSwapping(shared) {
+			shared = w.From
+		}
+		wOther, lOther := w.otherSwapped(shared), l.otherSwapped(shared)
+		wShared := w.recAt(shared) // item taking the place of the shared one
+
+		wPrime := w
+		wPrime.Pos, wPrime.From = wOther, lOther
+		wPrime.Rec, wPrime.Rec2 = w.recAt(wOther), wShared
+
+		lPrime := l
+		lPrime.Pos, lPrime.From = shared, lOther
+		lPrime.Rec, lPrime.Rec2 = l.recAt(shared), l.recAt(lOther)
+		setItemValue(&lPrime.Rec2, wShared)
+
+		if sWins {
+			return wPrime, lPrime, true
+		}
+		return lPrime, wPrime, true
+	}
+}
+
+// isSwapping reports whether the swap changes the given position.
+func (op OrdContOp) isSwapping(pos int64) bool {
+	return pos == op.Pos || pos == op.From
+}
+
+// otherSwapped returns the other position of the swap.
+func (op OrdContOp) otherSwapped(pos int64) int64 {
+	if pos == op.Pos {
+		return op.From
+	}
+	return op.Pos
+}
+
+// recAt returns the record of the swap putting an item at the given position.
+func (op OrdContOp) recAt(pos int64) Rec {
+	if pos == op.Pos {
+		return op.Rec
+	}
+	return op.Rec2
+}
+
+// swapToMove returns what is left of the swap when the item at 'gone'
+// is removed (dest < 0) or moved to 'dest' (position in the container
+// without it) concurrently: a move of the other item to the place of
+// the item gone (no change if it is there already); for a concurrent
+// move, also returns its destination after the swap, keeping the moved
+// item between the same items (other than the one of the swap).
+func (op OrdContOp) swapToMove(gone, dest int64) (move OrdContOp, destAfter int64) {
+	other := op.otherSwapped(gone)
+
+	move = op
+	move.Kind = MoveOC
+	move.Rec = op.recAt(gone) // the other item, put in place of the one gone
+	move.Rec.ChangeRecFlags &^= SwappingRF
+	move.Rec2 = Rec{}
+
+	// Positions of the other item in the container without the item
+	// gone: before and after the swap.
+	move.From = other - b2i(other > gone)
+	move.Pos = gone - b2i(gone > other)
+	if dest >= 0 {
+		// Items other than the swapped ones before the moved item,
+		// which is inserted before the other item if next to it:
+		nBefore := dest - b2i(move.From < dest)
+		destAfter = nBefore + b2i(move.Pos < nBefore)
+		move.From += b2i(move.From >= dest)
+		move.Pos += b2i(move.Pos >= destAfter)
+	}
+	if move.From == move.Pos {
+		move.Kind = NoOpOC
+	}
+	return move, destAfter
+}
+
+// setItemValue sets the item value of 'rec' to the one of 'from'.
+func setItemValue(rec *Rec, from Rec) {
+	rec.ValueTypeID = from.ValueTypeID
+	rec.ObjectID = from.ObjectID
+	rec.LangTag = from.LangTag
+	rec.StringVal = from.StringVal
+}
+
+func b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ordContPrim = primitive change: insertion, removal or replacement
+// (or nothing, for kind NoOpOC)
+type ordContPrim struct {
+	kind OrdContOpKind
+	pos  int64
+}
+
+func (op OrdContOp) prims() []ordContPrim {
+	if op.Kind == MoveOC {
+		return []ordContPrim{{kind: RemoveOC, pos: op.From}, {kind: InsertOC, pos: op.Pos}}
+	}
+	return []ordContPrim{{kind: op.Kind, pos: op.Pos}}
+}
+
+func (op OrdContOp) fromPrims(prims []ordContPrim) OrdContOp {
+	if op.Kind != MoveOC {
+		op.Kind, op.Pos = prims[0].kind, prims[0].pos
+		return op
+	}
+
+	remove, insert := prims[0], prims[1]
+	switch {
+	case remove.kind == RemoveOC && insert.kind == InsertOC:
+		op.From, op.Pos = remove.pos, insert.pos
+	case insert.kind == InsertOC:
+		op.Kind, op.Pos = InsertOC, insert.pos
+	case remove.kind == RemoveOC:
+		op.Kind, op.Pos = RemoveOC, remove.pos
+	default:
+		op.Kind = NoOpOC
+	}
+	return op
+}
+
+// transformPrim returns 'a' transformed to apply after 'b'
+// (both made against the same state).
+func transformPrim(a, b ordContPrim, aWins bool) (ordContPrim, bool) {
+	if a.kind == NoOpOC || b.kind == NoOpOC {
+		return a, false
+	}
+
+	switch b.kind {
+	case InsertOC:
+		if a.pos > b.pos || (a.pos == b.pos && (a.kind != InsertOC || !aWins)) {
+			a.pos++
+		}
+
+	case RemoveOC:
+		switch {
+		case a.pos > b.pos:
+			a.pos--
+		case a.pos < b.pos || a.kind == InsertOC:
+		case a.kind == RemoveOC:
+			a.kind = NoOpOC // removed once
+		default: // ReplaceOC
+			if !aWins {
+				a.kind = NoOpOC
+			} else {
+				a.kind = InsertOC // put back, with the new value
+			}
+			return a, true
+		}
+
+	case ReplaceOC:
+		if a.pos == b.pos && a.kind != InsertOC {
+			if !aWins {
+				a.kind = NoOpOC
+			}
+			return a, true
+		}
+	}
+	return a, false
+}
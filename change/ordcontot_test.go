@@ -0,0 +1,222 @@
+package change
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// TestOrdContConvergence verifies TransformOrdCont exhaustively
+// for all the containers with up to 'maxLen' items: for every pair of
+// operations (all kinds, all valid positions) made against the same state,
+// and both choices of the winner, checks that
+//
+//   - applying 'a' then b' gives the same items as applying 'b' then a';
+//   - swapping the arguments gives the same transformed operations.
+//
+// (Small lengths already cover all the position relations.)
+//
+func TestOrdContConvergence(t *testing.T) {
+	const maxLen = 5
+
+	for n := 0; n <= maxLen; n++ {
+		items := make([]id.IntID, n)
+		for i := range items {
+			items[i] = id.IntID(i + 1)
+		}
+
+		aOps := allOrdContOps(n, 100)
+		bOps := allOrdContOps(n, 200)
+		for _, a := range aOps {
+			for _, b := range bOps {
+				for _, aWins := range []bool{true, false} {
+					err := checkOrdContPair(items, a, b, aWins)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func checkOrdContPair(items []id.IntID, a, b OrdContOp, aWins bool) error {
+	aPrime, bPrime, _ := TransformOrdCont(a, b, aWins)
+	bPrime2, aPrime2, _ := TransformOrdCont(b, a, !aWins)
+	if !sameOrdContOp(aPrime, aPrime2) || !sameOrdContOp(bPrime, bPrime2) {
+		return errors.Errorf("Asymmetric transform of %v and %v (aWins %v): %v, %v versus %v, %v",
+			descrOrdContOp(a), descrOrdContOp(b), aWins,
+			descrOrdContOp(aPrime), descrOrdContOp(bPrime),
+			descrOrdContOp(aPrime2), descrOrdContOp(bPrime2))
+	}
+
+	viaA, err := applyOrdContOps(items, a, bPrime)
+	if err == nil {
+		var viaB []id.IntID
+		viaB, err = applyOrdContOps(items, b, aPrime)
+		if err == nil && !equalIDs(viaA, viaB) {
+			err = errors.Errorf("Divergence: %v versus %v", viaA, viaB)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Transforming %v and %v (aWins %v) on %v, got %v and %v",
+			descrOrdContOp(a), descrOrdContOp(b), aWins, items,
+			descrOrdContOp(aPrime), descrOrdContOp(bPrime))
+	}
+	return nil
+}
+
+// allOrdContOps returns all the operations valid for a container
+// with 'n' items (item IDs 1 to n); the inserted and replacing items
+// have IDs from 'itemBase' (two values for replacements, to cover both
+// equal and different values between two sets of operations made
+// with different bases).
+func allOrdContOps(n int, itemBase id.IntID) []OrdContOp {
+	var ops []OrdContOp
+	newOp := func(kind OrdContOpKind, pos, from int64, item id.IntID) {
+		op := OrdContOp{Kind: kind, Pos: pos, From: from}
+		op.Rec.Form = OrdContItem
+		op.Rec.ObjectID = item
+		ops = append(ops, op)
+	}
+
+	for pos := int64(0); pos <= int64(n); pos++ {
+		newOp(InsertOC, pos, 0, itemBase)
+	}
+	for pos := int64(0); pos < int64(n); pos++ {
+		newOp(RemoveOC, pos, 0, id.NoID)
+		newOp(ReplaceOC, pos, 0, itemBase+1)
+		newOp(ReplaceOC, pos, 0, 1000) // same value for both sets
+		for to := int64(0); to < int64(n); to++ {
+			newOp(MoveOC, to, pos, id.NoID)
+		}
+		for other := int64(0); other < int64(n); other++ {
+			if other != pos {
+				newOp(SwapOC, pos, other, id.IntID(other+1))
+				ops[len(ops)-1].Rec2 = Rec{Form: OrdContItem, ObjectID: id.IntID(pos + 1)}
+			}
+		}
+	}
+	return ops
+}
+
+// applyOrdContOps applies the given operations, in order, to a copy
+// of the given items (move operations take the item from the container,
+// replacing carried values are not used, as in the records of a move;
+// swaps put their carried values, as their two records).
+func applyOrdContOps(items []id.IntID, ops ...OrdContOp) ([]id.IntID, error) {
+	result := append([]id.IntID(nil), items...)
+
+	for _, op := range ops {
+		n := int64(len(result))
+		switch op.Kind {
+		case NoOpOC:
+		case InsertOC:
+			if op.Pos < 0 || op.Pos > n {
+				return nil, errors.Errorf("%v out of range (length %d)", descrOrdContOp(op), n)
+			}
+			result = append(result[:op.Pos], append([]id.IntID{op.Rec.ObjectID}, result[op.Pos:]...)...)
+		case RemoveOC, ReplaceOC:
+			if op.Pos < 0 || op.Pos >= n {
+				return nil, errors.Errorf("%v out of range (length %d)", descrOrdContOp(op), n)
+			}
+			if op.Kind == ReplaceOC {
+				result[op.Pos] = op.Rec.ObjectID
+			} else {
+				result = append(result[:op.Pos], result[op.Pos+1:]...)
+			}
+		case MoveOC:
+			if op.From < 0 || op.From >= n || op.Pos < 0 || op.Pos >= n {
+				return nil, errors.Errorf("%v out of range (length %d)", descrOrdContOp(op), n)
+			}
+			item := result[op.From]
+			result = append(result[:op.From], result[op.From+1:]...)
+			result = append(result[:op.Pos], append([]id.IntID{item}, result[op.Pos:]...)...)
+		case SwapOC:
+			if op.Pos < 0 || op.Pos >= n || op.From < 0 || op.From >= n || op.Pos == op.From {
+				return nil, errors.Errorf("%v out of range (length %d)", descrOrdContOp(op), n)
+			}
+			result[op.Pos], result[op.From] = op.Rec.ObjectID, op.Rec2.ObjectID
+		default:
+			return nil, errors.Errorf("Unexpected operation kind %v", op.Kind)
+		}
+	}
+	return result, nil
+}
+
+func sameOrdContOp(a, b OrdContOp) bool {
+	if a.Kind == NoOpOC || b.Kind == NoOpOC {
+		return a.Kind == b.Kind
+	}
+	return a.Kind == b.Kind && a.Pos == b.Pos && a.From == b.From &&
+		a.Rec.ObjectID == b.Rec.ObjectID && a.Rec2.ObjectID == b.Rec2.ObjectID
+}
+
+func equalIDs(a, b []id.IntID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func descrOrdContOp(op OrdContOp) string {
+	switch op.Kind {
+	case MoveOC:
+		return fmt.Sprintf("Move(%d->%d)", op.From, op.Pos)
+	case SwapOC:
+		return fmt.Sprintf("Swap(%d: %d, %d: %d)", op.Pos, op.Rec.ObjectID, op.From, op.Rec2.ObjectID)
+	case NoOpOC:
+		return "NoOp"
+	}
+	return fmt.Sprintf("%v(%d, %d)", op.Kind, op.Pos, op.Rec.ObjectID)
+}
+
+// The records of swaps, and of what is left of a swap after
+// a transformation, make valid changesets.
+func TestOrdContOpRecs(t *testing.T) {
+	set := &Set{SetInfo: SetInfo{ID: testCSetID}}
+	NewSetBuilder(set).Container(10).Swap(0, 1, 2, 3)
+	for i := range set.ChangeRecords {
+		set.ChangeRecords[i].ChangeSetID = testCSetID
+	}
+
+	ops := OrdContOps(set.ChangeRecords)
+	if len(ops) != 1 || ops[0].Kind != SwapOC {
+		t.Fatalf("Operations of a swap: %+v", ops)
+	}
+	if recs := ops[0].Recs(); !reflect.DeepEqual(recs, set.ChangeRecords) {
+		t.Errorf("Records of a swap:\n got  %+v\n want %+v", recs, set.ChangeRecords)
+	}
+
+	// Item 1 removed concurrently: item 3 takes its place.
+	remove := OrdContOpFromRec(itemRec(RemoveAtRT, 10, 0, id.NoID))
+	swap, _, clash := TransformOrdCont(ops[0], remove, false)
+	recs := swap.Recs()
+	if !clash || swap.Kind != MoveOC || len(recs) != 2 {
+		t.Fatalf("Swap after removal: %s (clash %v)", descrOrdContOp(swap), clash)
+	}
+	for _, rec := range recs {
+		if rec.ChangeRecFlags != ReorderingRF || rec.EditOpCID != set.ChangeRecords[0].EditOpCID {
+			t.Errorf("Record of the swap turned into a move: %+v", rec)
+		}
+	}
+	err := ValidateSet(&Set{SetInfo: set.SetInfo, ChangeRecords: recs})
+	if err != nil {
+		t.Errorf("Invalid records of the swap turned into a move: %v", err)
+	}
+
+	// One record of a swap left alone: no longer an operation.
+	replace := OrdContOpFromRec(set.ChangeRecords[0])
+	recs = replace.Recs()
+	if len(recs) != 1 || recs[0].ChangeRecFlags != 0 || recs[0].EditOpCID != id.NoID {
+		t.Errorf("Record left of a swap: %+v", recs)
+	}
+}
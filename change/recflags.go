@@ -0,0 +1,140 @@
+package change
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+var recFlagNames = []struct {
+	flag RecFlags
+	name string
+}{
+	{CopyingRF, "Copying"},
+	{ReorderingRF, "Reordering"},
+	{SwappingRF, "Swapping"},
+	{ReplacingRF, "Replacing"},
+	{MovingRF, "Moving"},
+}
+
+// String returns the names of the flags set, separated by '|'
+// (unknown bits in hex), or "0" if none.
+func (f RecFlags) String() string {
+	if f == 0 {
+		return "0"
+	}
+	var names []string
+	for _, fn := range recFlagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+			f &^= fn.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint32(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+// OperationRFs = the operation flags: the flags for which CheckFlagGroups
+// checks the records (grouped by 'EditOpCID').
+const OperationRFs = CopyingRF | ReorderingRF | SwappingRF
+
+// CheckFlagGroups checks the records of the given changeset carrying
+// operation flags (copying, reordering, swapping): grouped by 'EditOpCID',
+// each group must have the records required for its operation:
+//
+//   - copying: at least one 'AddRT', 'AppendRT', 'PrependRT', 'InsertAtRT'
+//     or 'ReplaceAtRT' record (other records, like a 'TestRT' documenting
+//     the source, are allowed);
+//   - reordering: one 'RemoveAtRT' and one 'InsertAtRT' record,
+//     same subject, nothing else;
+//   - swapping: two 'ReplaceAtRT' records, same subject,
+//     different positions, nothing else.
+//
+// Returns nil or a *ValidationError (rule FlagGroupVR) naming
+// the first record of the offending group (or the offending record).
+//
+func CheckFlagGroups(set *Set) error {
+	for i := range set.ChangeRecords {
+		rec := &set.ChangeRecords[i]
+		if rec.ChangeRecFlags&OperationRFs != 0 && rec.EditOpCID == id.NoID {
+			return &ValidationError{RecIndex: i, Rec: *rec, Rule: FlagGroupVR,
+				Descr: fmt.Sprintf("flags %v without 'EditOpCID'", rec.ChangeRecFlags)}
+		}
+	}
+
+	for _, group := range GroupByEditOp(set.ChangeRecords) {
+		var flags RecFlags
+		for _, i := range group.RecIndexes {
+			flags |= set.ChangeRecords[i].ChangeRecFlags
+		}
+		if flags&OperationRFs == 0 {
+			continue
+		}
+		err := checkFlagGroup(set.ChangeRecords, group.RecIndexes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFlagGroup(recs []Rec, indexes []int) error {
+	first := indexes[0]
+	fail := func(i int, format string, args ...interface{}) error {
+		return &ValidationError{RecIndex: i, Rec: recs[i], Rule: FlagGroupVR,
+			Descr: fmt.Sprintf("editing operation %d: ", recs[first].EditOpCID) +
+				fmt.Sprintf(format, args...)}
+	}
+
+	flags := recs[first].ChangeRecFlags & OperationRFs
+	for _, i := range indexes[1:] {
+		if recs[i].ChangeRecFlags&OperationRFs != flags {
+			return fail(i, "flags %v differ from %v of record #%d",
+				recs[i].ChangeRecFlags&OperationRFs, flags, first)
+		}
+	}
+
+	switch flags {
+	case CopyingRF:
+		for _, i := range indexes {
+			switch recs[i].ChangeRecType {
+			case AddRT, AppendRT, PrependRT, InsertAtRT, ReplaceAtRT:
+				return nil
+			}
+		}
+		return fail(first, "copy without any adding record")
+
+	case ReorderingRF:
+		if len(indexes) != 2 ||
+			recs[indexes[0]].ChangeRecType != RemoveAtRT ||
+			recs[indexes[1]].ChangeRecType != InsertAtRT {
+			return fail(first, "reordering needs one 'RemoveAtRT' then one 'InsertAtRT' (got %d records)",
+				len(indexes))
+		}
+		if recs[indexes[1]].SubjectID != recs[first].SubjectID {
+			return fail(indexes[1], "reordering across different subjects")
+		}
+
+	case SwappingRF:
+		if len(indexes) != 2 ||
+			recs[indexes[0]].ChangeRecType != ReplaceAtRT ||
+			recs[indexes[1]].ChangeRecType != ReplaceAtRT {
+			return fail(first, "swapping needs two 'ReplaceAtRT' records (got %d records)",
+				len(indexes))
+		}
+		second := &recs[indexes[1]]
+		if second.SubjectID != recs[first].SubjectID {
+			return fail(indexes[1], "swapping across different subjects")
+		}
+		if second.Prop == recs[first].Prop {
+			return fail(indexes[1], "swapping an item with itself")
+		}
+
+	default:
+		return fail(first, "more than one operation flag (%v)", flags)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package change
+
+import "testing"
+
+func TestCheckFlagGroups(t *testing.T) {
+	remove := withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1)
+	insert := withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1)
+	replace1 := withOp(itemRec(ReplaceAtRT, 11, 0, 111), SwappingRF, 2)
+	replace2 := withOp(itemRec(ReplaceAtRT, 11, 1, 110), SwappingRF, 2)
+	other := tripleRec(AddRT, 1, 2, 3)
+
+	tests := []struct {
+		name  string
+		recs  []Rec
+		index int // of the record named by the error, -1 if valid
+	}{
+		{"valid pairs", []Rec{remove, insert, other, replace1, replace2}, -1},
+		{"interleaved pairs", []Rec{remove, replace1, insert, replace2}, -1},
+		{"pairs with other records in between", []Rec{replace1, other, replace2}, -1},
+		{"orphan remove", []Rec{other, remove}, 1},
+		{"orphan insert", []Rec{insert, other}, 0},
+		{"orphan swap half", []Rec{remove, insert, replace2}, 2},
+		{"insert before remove", []Rec{insert, remove}, 0},
+		{"pair split across subjects", []Rec{remove,
+			withOp(itemRec(InsertAtRT, 12, 2, 100), ReorderingRF, 1)}, 1},
+		{"swap of a position with itself", []Rec{replace1,
+			withOp(itemRec(ReplaceAtRT, 11, 0, 111), SwappingRF, 2)}, 1},
+		{"two operation flags", []Rec{
+			withOp(itemRec(ReplaceAtRT, 11, 0, 111), SwappingRF|ReorderingRF, 2),
+			withOp(itemRec(ReplaceAtRT, 11, 1, 110), SwappingRF|ReorderingRF, 2)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckFlagGroups(&Set{SetInfo: SetInfo{ID: testCSetID}, ChangeRecords: tt.recs})
+			if tt.index < 0 {
+				if err != nil {
+					t.Errorf("CheckFlagGroups failed: %v", err)
+				}
+				return
+			}
+			ve, ok := err.(*ValidationError)
+			if !ok || ve.Rule != FlagGroupVR || ve.RecIndex != tt.index {
+				t.Errorf("CheckFlagGroups returned %v, want an error for record #%d", err, tt.index)
+			}
+		})
+	}
+}
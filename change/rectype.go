@@ -0,0 +1,68 @@
+package change
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Names for display and exports: the constant names without the suffix.
+var recTypeNames = [...]string{
+	AddRT:       "Add",
+	DelRT:       "Del",
+	ModifyRT:    "Modify",
+	TestRT:      "Test",
+	ContextRT:   "Context",
+	IdentRT:     "Ident",
+	MetaRT:      "Meta",
+	AppendRT:    "Append",
+	PrependRT:   "Prepend",
+	InsertAtRT:  "InsertAt",
+	RemoveAtRT:  "RemoveAt",
+	ReplaceAtRT: "ReplaceAt",
+}
+
+var recFormNames = map[RecFormCode]string{
+	UsualTriple: "UsualTriple",
+	OrdContItem: "OrdContItem",
+	IDLitBin:    "IDLitBin",
+}
+
+func (t RecTypeCode) String() string {
+	if int(t) < len(recTypeNames) {
+		return recTypeNames[t]
+	}
+	return fmt.Sprintf("RecTypeCode(%d)", int(t))
+}
+
+// ParseRecType returns the record type with the given name
+// (as returned by String, or the constant name with the 'RT' suffix;
+// case-insensitive).
+func ParseRecType(s string) (RecTypeCode, error) {
+	name := strings.TrimSuffix(strings.ToLower(s), "rt")
+	for t, tName := range recTypeNames {
+		if name == strings.ToLower(tName) {
+			return RecTypeCode(t), nil
+		}
+	}
+	return 0, errors.Errorf("Unknown change record type %q", s)
+}
+
+func (f RecFormCode) String() string {
+	if name, ok := recFormNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("RecFormCode(%d)", int(f))
+}
+
+// ParseRecForm returns the record form with the given name
+// (as returned by String; case-insensitive).
+func ParseRecForm(s string) (RecFormCode, error) {
+	for f, name := range recFormNames {
+		if strings.EqualFold(s, name) {
+			return f, nil
+		}
+	}
+	return 0, errors.Errorf("Unknown change record form %q", s)
+}
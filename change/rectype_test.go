@@ -0,0 +1,122 @@
+package change
+
+import (
+	"strings"
+	"testing"
+)
+
+// The values are stored (crec_type, crec_form and crec_flags columns):
+// they must never change.
+func TestRecTypeValues(t *testing.T) {
+	recTypes := []struct {
+		t     RecTypeCode
+		value int
+		name  string
+	}{
+		{AddRT, 0, "Add"},
+		{DelRT, 1, "Del"},
+		{ModifyRT, 2, "Modify"},
+		{TestRT, 3, "Test"},
+		{ContextRT, 4, "Context"},
+		{IdentRT, 5, "Ident"},
+		{MetaRT, 6, "Meta"},
+		{AppendRT, 7, "Append"},
+		{PrependRT, 8, "Prepend"},
+		{InsertAtRT, 9, "InsertAt"},
+		{RemoveAtRT, 10, "RemoveAt"},
+		{ReplaceAtRT, 11, "ReplaceAt"},
+	}
+	if len(recTypes) != len(recTypeNames) {
+		t.Errorf("%d record types tested, %d defined", len(recTypes), len(recTypeNames))
+	}
+	for _, tt := range recTypes {
+		if int(tt.t) != tt.value {
+			t.Errorf("%s: value %d, want %d", tt.name, int(tt.t), tt.value)
+		}
+		if tt.t.String() != tt.name {
+			t.Errorf("RecTypeCode(%d).String() = %q, want %q", tt.value, tt.t.String(), tt.name)
+		}
+		for _, s := range []string{tt.name, strings.ToUpper(tt.name), tt.name + "RT"} {
+			parsed, err := ParseRecType(s)
+			if err != nil || parsed != tt.t {
+				t.Errorf("ParseRecType(%q) = %v, %v; want %v", s, parsed, err, tt.t)
+			}
+		}
+	}
+
+	recForms := []struct {
+		f     RecFormCode
+		value int
+		name  string
+	}{
+		{UsualTriple, 0, "UsualTriple"},
+		{OrdContItem, 8, "OrdContItem"},
+		{IDLitBin, 12, "IDLitBin"},
+	}
+	if len(recForms) != len(recFormNames) {
+		t.Errorf("%d record forms tested, %d defined", len(recForms), len(recFormNames))
+	}
+	for _, tt := range recForms {
+		if int(tt.f) != tt.value {
+			t.Errorf("%s: value %d, want %d", tt.name, int(tt.f), tt.value)
+		}
+		if tt.f.String() != tt.name {
+			t.Errorf("RecFormCode(%d).String() = %q, want %q", tt.value, tt.f.String(), tt.name)
+		}
+		for _, s := range []string{tt.name, strings.ToLower(tt.name)} {
+			parsed, err := ParseRecForm(s)
+			if err != nil || parsed != tt.f {
+				t.Errorf("ParseRecForm(%q) = %v, %v; want %v", s, parsed, err, tt.f)
+			}
+		}
+	}
+
+	recFlags := []struct {
+		f     RecFlags
+		value int
+		name  string
+	}{
+		{CopyingRF, 0x1, "Copying"},
+		{ReorderingRF, 0x2, "Reordering"},
+		{SwappingRF, 0x4, "Swapping"},
+		{ReplacingRF, 0x8, "Replacing"},
+		{MovingRF, 0x10, "Moving"},
+	}
+	if len(recFlags) != len(recFlagNames) {
+		t.Errorf("%d record flags tested, %d defined", len(recFlags), len(recFlagNames))
+	}
+	for _, tt := range recFlags {
+		if int(tt.f) != tt.value {
+			t.Errorf("%s: value %#x, want %#x", tt.name, int(tt.f), tt.value)
+		}
+		if tt.f.String() != tt.name {
+			t.Errorf("RecFlags(%#x).String() = %q, want %q", tt.value, tt.f.String(), tt.name)
+		}
+	}
+}
+
+func TestRecTypeStrings(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{RecTypeCode(99).String(), "RecTypeCode(99)"},
+		{RecFormCode(99).String(), "RecFormCode(99)"},
+		{RecFlags(0).String(), "0"},
+		{(ReorderingRF | MovingRF).String(), "Reordering|Moving"},
+		{(SwappingRF | 0x100).String(), "Swapping|0x100"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("String() = %q, want %q", tt.got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "Foo", "RT", "UsualTripleRT"} {
+		if _, err := ParseRecType(s); err == nil {
+			t.Errorf("ParseRecType(%q) succeeded", s)
+		}
+		if _, err := ParseRecForm(s); err == nil {
+			t.Errorf("ParseRecForm(%q) succeeded", s)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package change
 
 import (
+	"time"
+
 	"github.com/gimpldo/ba-prototype-go/id"
 )
 
@@ -9,6 +11,8 @@ import (
 // not the kind of change (operation: add, delete, etc.)
 type RecFormCode uint16
 
+// The values are stored ('crec_form' or implied by the table) and exported:
+// they must never change (see TestRecTypeValues in rectype_test.go).
 const (
 	// UsualTriple means typical RDF triple,
 	// not part of an order-preserving container:
@@ -16,6 +20,15 @@ const (
 
 	// OrdContItem means Item from an Order-preserving Container:
 	OrdContItem RecFormCode = 8
+
+	// IDLitBin means IDentified Literal node containing Binary data
+	// (a potentially big binary, which can be modified incrementally).
+	// The change record's 'Prop' contains a byte offset (position), and
+	// 'BinVal' the bytes appended, prepended, inserted at the offset,
+	// removed from the offset, or written over (replacing) the bytes
+	// at the offset; the length of 'BinVal' is the length of the range.
+	// Offsets are relative to the binary as it is when the record is applied.
+	IDLitBin RecFormCode = 12
 )
 
 // RecTypeCode = change Record Type Code.
@@ -37,18 +50,34 @@ const (
 type RecTypeCode uint16
 
 // The trailing 'RT' in constant names stands for "Record Type".
+//
+// The values are stored ('crec_type' columns) and exported: they are
+// explicit and must never change (see TestRecTypeValues in rectype_test.go);
+// new record types get new values.
 const (
 	AddRT RecTypeCode = 0
-	DelRT             = 1
+	DelRT RecTypeCode = 1
 
-	ModifyRT // or have it only for order-preserving container items? see below!
+	ModifyRT RecTypeCode = 2 // or have it only for order-preserving container items? see below!
 
-	TestRT
-	ContextRT
+	// 'Test' records are preconditions: they must hold (the triple exists,
+	// the container item at the position has the given value, ...)
+	// before the changeset is applied, otherwise the whole changeset
+	// is rejected; see package 'change/apply'.
+	TestRT RecTypeCode = 3
+
+	// 'Context' records describe a context of the changeset's changes
+	// (like the document part being edited): their 'ChangeRecContextID'
+	// is the ID of the context described, and the records made
+	// in that context carry the same 'ChangeRecContextID'.
+	//
+	// 'ContextRT', 'IdentRT' and 'MetaRT' records only carry information
+	// (see IsInfoRecType): they neither change nor check the state.
+	ContextRT RecTypeCode = 4
 
 	// 'Ident' is short for "Identify", or "Identifier";
 	// initially considered the name 'DescRT' ("Describe")
-	IdentRT
+	IdentRT RecTypeCode = 5
 
 	// 'Meta' is short for "Add Metadata".
 	// There is no corresponding "Delete Metadata" because
@@ -57,7 +86,7 @@ const (
 	// A metadata record ---by itself--- does NOT
 	// (1) provide a value to be stored or (2) cause a change
 	// in the data store that receives and applies the changeset.
-	MetaRT
+	MetaRT RecTypeCode = 6
 
 	// The following type codes are for change record Form = OrdContItem
 	// and maybe other forms not specified yet, like big text or binary.
@@ -66,27 +95,35 @@ const (
 	// some sequence types like a big text or binary which may be defined
 	// later, even if they would not correspond to anything in RDF.
 
-	AppendRT
-	PrependRT
-	InsertAtRT
-	RemoveAtRT
-	ReplaceAtRT // initially considered the name 'SetItemRT'
+	AppendRT    RecTypeCode = 7
+	PrependRT   RecTypeCode = 8
+	InsertAtRT  RecTypeCode = 9
+	RemoveAtRT  RecTypeCode = 10
+	ReplaceAtRT RecTypeCode = 11 // initially considered the name 'SetItemRT'
 
 // Or only have the above 'ReplaceAtRT' instead of a general 'ModifyRT'?
 // Is a general "Modify" operation meaningful in the world of RDF?
 )
 
+// RecFlags = change Record Flags: a bitset (stored in 'crec_flags').
+//
+// The operation flags (copying, reordering, swapping) mark the records
+// representing together one editing operation; such records must share
+// the same (non-zero) 'EditOpCID', see CheckFlagGroups.
+//
+type RecFlags uint32
+
 // Change record flag values, for 'ChangeRecFlags' below.
 // The trailing 'RF' in constant names stands for "Record Flag".
+//
+// The values are stored; do not change them (new flags get new bits).
 const (
 	// Copying Flag means: the change record is part of the representation
 	// for a copy operation/edit, which should include at least one (1)
 	// 'AddRT', 'AppendRT', 'PrependRT', 'InsertAtRT' or 'ReplaceAtRT' record.
 	// There may be a 'TestRT' record to document the source for the copy;
 	// it must have this flag set.
-	// TODO: document ways to identify corresponding change records
-	// that represent together a copy operation/edit.
-	CopyingRF = 0x1
+	CopyingRF RecFlags = 0x1
 
 	// Reorder = move an item inside an order-preserving container or
 	// maybe some sequence type like a big text or binary
@@ -95,7 +132,7 @@ const (
 	// for a reordering operation/edit, which should include
 	// one 'RemoveAtRT' and one 'InsertAtRT' record for same subject
 	// (order-preserving container), with this flag set on both records.
-	ReorderingRF = 0x1
+	ReorderingRF RecFlags = 0x2
 
 	// Swapping Flag means: the change record is part of the representation
 	// for an interchange operation/edit, which should include
@@ -107,20 +144,20 @@ const (
 	// (if such extensions are defined later).
 	// *** Would it be wrong to have flags specific to OrdCont?
 	// *** Can't tell now --- February 15th, 2017
-	SwappingRF = 0x1
+	SwappingRF RecFlags = 0x4
 
-	// ???
-
-	ReplacingRF = 0x1
-	MovingRF    = 0x1
-	RF          = 0x1
+	// Not specified yet (no required records checked):
+	// replacing a value (maybe 'DelRT' + 'AddRT' for a usual triple),
+	// moving an item between containers.
+	ReplacingRF RecFlags = 0x8
+	MovingRF    RecFlags = 0x10
 )
 
 // Rec = change Record
 type Rec struct {
 	Form           RecFormCode
 	ChangeRecType  RecTypeCode
-	ChangeRecFlags uint32
+	ChangeRecFlags RecFlags
 
 	ChangeRecContextID id.IntID
 
@@ -128,7 +165,8 @@ type Rec struct {
 	// We are using local references that are valid only inside a changeset.
 	// The values appearing in the 'edit_op_cid' column will be taken from
 	// a pool of unique IDs --- reusable in each changeset that contains
-	// editing operation metadata.
+	// editing operation metadata (see EditOpCIDPool, and GroupByEditOp
+	// for getting the records of each editing operation).
 	//
 	// The 'C' in 'CID' can be understood as "Correlation", or
 	// as "Changeset-scoped".
@@ -143,8 +181,16 @@ type Rec struct {
 	Prop      id.PosOrID
 	ObjectID  id.IntID
 
+	// Old position of an item from an order-preserving container,
+	// for records that need it (like a reordering pair);
+	// otherwise zero value (id.NoID, not a position).
+	OldProp id.PosOrID
+
 	LangTag   string
 	StringVal string
+
+	// Binary value (only for Form = IDLitBin)
+	BinVal []byte
 }
 
 // RecPullSource = Pull Source of change Records = iterator
@@ -181,11 +227,57 @@ type RecPushSinkEnder interface {
 	Abort() // EndAbort?
 }
 
+// RecPushSinkFinisher = Push Sink of change Records able to check,
+// before ending, that the records put form complete changesets
+// (End has no error return; call Finish first, then End or Abort).
+type RecPushSinkFinisher interface {
+	Finish() error
+}
+
+// SetInfoPushSink = Push Sink of changeset headers (metadata).
+//
+// A push sink of change records may implement this interface too;
+// in that case, the header of a changeset should be put
+// before the changeset's records (see PutSet).
+type SetInfoPushSink interface {
+	PutChangeSetInfo(SetInfo) error
+}
+
+// Annotation = arbitrary key/value pair attached to a changeset
+type Annotation struct {
+	Key   string
+	Value string
+}
+
+// SetInfo = changeset metadata (header)
+type SetInfo struct {
+	ID id.IntID
+
+	Author    string
+	CreatedAt time.Time
+
+	// The changeset(s) this one was made against: usually one,
+	// none for the first changeset, two or more for a merge.
+	ParentIDs []id.IntID
+
+	// Free-form description, like a commit message
+	Message string
+
+	// Keys need not be unique; order is preserved.
+	Annotations []Annotation
+}
+
 // Set = changeset
 type Set struct {
-	// A slice of change records; order should not be relevant
-	// (reordering the change records should not change the meaning =
-	// result of applying the changeset).
+	SetInfo
+
+	// A slice of change records, in order: the records are applied
+	// one after the other (positions and offsets refer to the container
+	// or literal as it is when each record is applied), and the records
+	// of an editing operation must keep their order (a reordering pair
+	// is a 'RemoveAtRT' right before its 'InsertAtRT' for that container,
+	// see CheckFlagGroups and Validator). The stores keep the order
+	// ('crec_seq' columns in the SQL stores).
 	// The slice of change records need not be allocated;
 	// the other fields of the changeset may be valid while
 	// the change records may be streamed, not stored
@@ -193,3 +285,25 @@ type Set struct {
 	//
 	ChangeRecords []Rec
 }
+
+// PutSet puts the changeset's header (if the sink accepts headers)
+// followed by all the changeset's records into the given push sink.
+// Records without changeset ID (id.NoID) get the changeset's ID.
+func PutSet(sink RecPushSink, set *Set) error {
+	if infoSink, ok := sink.(SetInfoPushSink); ok {
+		err := infoSink.PutChangeSetInfo(set.SetInfo)
+		if err != nil {
+			return err
+		}
+	}
+	for _, rec := range set.ChangeRecords {
+		if rec.ChangeSetID == id.NoID {
+			rec.ChangeSetID = set.ID
+		}
+		err := sink.PutChangeRec(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
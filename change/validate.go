@@ -0,0 +1,436 @@
+package change
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// ValidationRule identifies a well-formedness rule for change records
+// (about the records themselves, not about the state they apply to).
+type ValidationRule int
+
+// The trailing 'VR' in constant names stands for "Validation Rule".
+const (
+	// The record form is one of the defined RecFormCode values
+	KnownFormVR ValidationRule = iota + 1
+
+	// The record type is applicable to the record form
+	// (e.g. no 'InsertAtRT' for a usual triple, no 'ModifyRT' at all yet)
+	TypeForFormVR
+
+	// 'Prop' and 'OldProp' are valid packed values (check bits)
+	PackedValueVR
+
+	// 'Prop' holds a property ID for a usual triple,
+	// a position or offset for the other forms;
+	// 'OldProp' is unset, or a position for a container item
+	PropKindVR
+
+	// The value fields fit the form: an object ID or a literal
+	// (language tag only for 'rdf:langString'), binary value
+	// only for binary literals (and no other value there)
+	ValueShapeVR
+
+	// The record belongs to the changeset it is given with
+	ChangeSetVR
+
+	// A reordering pair of container records is a 'RemoveAtRT'
+	// followed by an 'InsertAtRT' for the same container, without
+	// other records for that container in between (records for other
+	// containers may come in between), same 'EditOpCID' (not id.NoID),
+	// 'ReorderingRF' set on both
+	ReorderingPairVR
+
+	// The records sharing an 'EditOpCID' (in a changeset)
+	// have the same flags
+	EditOpCIDVR
+
+	// The records with operation flags form the groups required
+	// for their operations (see CheckFlagGroups)
+	FlagGroupVR
+)
+
+var validationRuleNames = [...]string{
+	KnownFormVR:      "known record form",
+	TypeForFormVR:    "record type applicable to form",
+	PackedValueVR:    "valid packed position or ID",
+	PropKindVR:       "position or property ID as required by form",
+	ValueShapeVR:     "value fields consistent with form",
+	ChangeSetVR:      "record in its changeset",
+	ReorderingPairVR: "well-formed reordering pair",
+	EditOpCIDVR:      "consistent editing operation ID",
+	FlagGroupVR:      "records required by operation flags",
+}
+
+func (r ValidationRule) String() string {
+	if r > 0 && int(r) < len(validationRuleNames) {
+		return validationRuleNames[r]
+	}
+	return fmt.Sprintf("ValidationRule(%d)", int(r))
+}
+
+// ValidationError = a change record violating a well-formedness rule
+type ValidationError struct {
+	// Index of the record in its changeset ('ChangeRecords' index,
+	// or order of putting into a push sink)
+	RecIndex int
+	Rec      Rec
+
+	Rule  ValidationRule
+	Descr string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid change record #%d (rule: %v): %s: %+v",
+		e.RecIndex, e.Rule, e.Descr, e.Rec)
+}
+
+// ValidateSet checks all the records of the given changeset
+// (see Validator); returns nil or the *ValidationError for the first invalid record.
+func ValidateSet(set *Set) error {
+	v := NewValidator()
+	v.StartSet(set.ID)
+	for _, rec := range set.ChangeRecords {
+		if rec.ChangeSetID == id.NoID {
+			rec.ChangeSetID = set.ID // as done by PutSet
+		}
+		err := v.Check(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return v.Finish()
+}
+
+// Validator checks change records one at a time, in changeset order
+// (all the records of a changeset before those of the next one),
+// keeping what is needed for the rules involving several records.
+//
+// The groups formed by operation flags (see CheckFlagGroups) are checked
+// at the end of each changeset; for this, the validator keeps the records
+// with an 'EditOpCID' or operation flags until then.
+//
+type Validator struct {
+	csetID  id.IntID
+	started bool
+
+	// Number of records checked in the current changeset
+	nRecs int
+
+	// Flags and index of the first record of each editing operation
+	// seen in the current changeset
+	editOps map[id.IntID]editOpFirst
+
+	// The 'RemoveAtRT' records of reordering pairs waiting for
+	// their 'InsertAtRT' records, by container
+	pendingRemoves map[id.IntID]pendingRemove
+
+	// The records with an 'EditOpCID' or operation flags
+	// in the current changeset, and their indexes
+	opRecs    []Rec
+	opIndexes []int
+}
+
+type pendingRemove struct {
+	rec   Rec
+	index int
+}
+
+type editOpFirst struct {
+	flags RecFlags
+	index int
+}
+
+func NewValidator() *Validator {
+	return &Validator{
+		editOps:        make(map[id.IntID]editOpFirst),
+		pendingRemoves: make(map[id.IntID]pendingRemove),
+	}
+}
+
+// StartSet tells the validator that the following records belong
+// to the given changeset (optional: a record with another changeset ID
+// than the previous record starts a new changeset anyway).
+// Fails if the previous changeset ended with an incomplete reordering pair.
+func (v *Validator) StartSet(csetID id.IntID) error {
+	err := v.Finish()
+	v.csetID = csetID
+	v.started = true
+	v.nRecs = 0
+	v.editOps = make(map[id.IntID]editOpFirst)
+	v.pendingRemoves = make(map[id.IntID]pendingRemove)
+	v.opRecs = nil
+	v.opIndexes = nil
+	return err
+}
+
+// Finish checks that no reordering pair is left incomplete
+// by the records so far, then the groups formed by operation flags
+// (call after the last record of a changeset).
+func (v *Validator) Finish() error {
+	var first *pendingRemove
+	for _, p := range v.pendingRemoves {
+		if first == nil || p.index < first.index {
+			p := p
+			first = &p
+		}
+	}
+	if first != nil {
+		return &ValidationError{RecIndex: first.index, Rec: first.rec,
+			Rule: ReorderingPairVR, Descr: "reordering 'RemoveAtRT' without the following 'InsertAtRT'"}
+	}
+
+	err := CheckFlagGroups(&Set{SetInfo: SetInfo{ID: v.csetID}, ChangeRecords: v.opRecs})
+	if ve, ok := err.(*ValidationError); ok {
+		ve.RecIndex = v.opIndexes[ve.RecIndex]
+	}
+	return err
+}
+
+// Check validates the next record.
+func (v *Validator) Check(rec Rec) error {
+	if !v.started || (rec.ChangeSetID != v.csetID && v.nRecs != 0) {
+		err := v.StartSet(rec.ChangeSetID)
+		if err != nil {
+			return err
+		}
+	}
+	i := v.nRecs
+	v.nRecs++
+
+	fail := func(rule ValidationRule, descr string) error {
+		return &ValidationError{RecIndex: i, Rec: rec, Rule: rule, Descr: descr}
+	}
+
+	if rec.ChangeSetID != v.csetID {
+		return fail(ChangeSetVR, fmt.Sprintf("changeset ID differs from %d", v.csetID))
+	}
+
+	rule, descr := checkRec(&rec)
+	if rule != 0 {
+		return fail(rule, descr)
+	}
+
+	if rec.EditOpCID != id.NoID || rec.ChangeRecFlags&OperationRFs != 0 {
+		v.opRecs = append(v.opRecs, rec)
+		v.opIndexes = append(v.opIndexes, i)
+	}
+
+	if rec.EditOpCID != id.NoID {
+		first, found := v.editOps[rec.EditOpCID]
+		if !found {
+			v.editOps[rec.EditOpCID] = editOpFirst{flags: rec.ChangeRecFlags, index: i}
+		} else if first.flags != rec.ChangeRecFlags {
+			return fail(EditOpCIDVR, fmt.Sprintf("flags %v differ from %v of record #%d",
+				rec.ChangeRecFlags, first.flags, first.index))
+		}
+	}
+
+	return v.checkReordering(i, &rec, fail)
+}
+
+func (v *Validator) checkReordering(i int, rec *Rec, fail func(ValidationRule, string) error) error {
+	if rec.Form != OrdContItem {
+		return nil
+	}
+
+	if p, found := v.pendingRemoves[rec.SubjectID]; found {
+		remove := &p.rec
+		delete(v.pendingRemoves, rec.SubjectID)
+		switch {
+		case rec.ChangeRecType != InsertAtRT:
+			return fail(ReorderingPairVR, fmt.Sprintf(
+				"not the 'InsertAtRT' of the reordering pair started by record #%d", p.index))
+		case rec.ChangeRecFlags&ReorderingRF == 0:
+			return fail(ReorderingPairVR, "'ReorderingRF' set on the 'RemoveAtRT' of the pair only")
+		case rec.EditOpCID != remove.EditOpCID:
+			return fail(ReorderingPairVR, "'EditOpCID' differs from the pair's 'RemoveAtRT'")
+		case rec.OldProp != 0 && rec.OldProp != remove.Prop:
+			return fail(ReorderingPairVR, "old position differs from the position of the pair's 'RemoveAtRT'")
+		}
+		return nil
+	}
+
+	if rec.ChangeRecFlags&ReorderingRF == 0 {
+		return nil
+	}
+	switch rec.ChangeRecType {
+	case RemoveAtRT:
+		if rec.EditOpCID == id.NoID {
+			return fail(ReorderingPairVR, "reordering record without 'EditOpCID'")
+		}
+		v.pendingRemoves[rec.SubjectID] = pendingRemove{rec: *rec, index: i}
+	case InsertAtRT:
+		return fail(ReorderingPairVR, "reordering 'InsertAtRT' not preceded by the pair's 'RemoveAtRT'")
+	}
+	return nil
+}
+
+// checkRec checks the rules about a single record;
+// returns the violated rule (0 if none) and a description.
+func checkRec(rec *Rec) (ValidationRule, string) {
+	isNoOp := rec.ChangeRecType == TestRT || IsInfoRecType(rec.ChangeRecType)
+
+	switch rec.Form {
+	case UsualTriple:
+		switch rec.ChangeRecType {
+		case AddRT, DelRT:
+		default:
+			if !isNoOp {
+				return TypeForFormVR, "record type not applicable to usual triple"
+			}
+		}
+	case OrdContItem:
+		switch rec.ChangeRecType {
+		case AppendRT, PrependRT, InsertAtRT, RemoveAtRT, ReplaceAtRT:
+		default:
+			if !isNoOp {
+				return TypeForFormVR, "record type not applicable to container item"
+			}
+		}
+	case IDLitBin:
+		switch rec.ChangeRecType {
+		case AddRT, DelRT, AppendRT, PrependRT, InsertAtRT, RemoveAtRT, ReplaceAtRT:
+		default:
+			if !isNoOp {
+				return TypeForFormVR, "record type not applicable to binary literal"
+			}
+		}
+	default:
+		return KnownFormVR, "unsupported record form"
+	}
+
+	for _, p := range []id.PosOrID{rec.Prop, rec.OldProp} {
+		if checked, err := p.Checked(); err != nil || checked != p {
+			return PackedValueVR, "check bits do not match (corrupted value)"
+		}
+	}
+
+	if rec.OldProp != 0 && (rec.Form != OrdContItem || !rec.OldProp.IsPos()) {
+		return PropKindVR, "old position set, but not a container item position"
+	}
+	if rec.Form == UsualTriple {
+		if propID, ok := rec.Prop.ID(); !ok || propID == id.NoID {
+			return PropKindVR, "usual triple without property ID"
+		}
+	} else {
+		if pos, ok := rec.Prop.Pos(); !ok || pos < 0 {
+			return PropKindVR, "no valid position or offset"
+		}
+	}
+
+	return checkValueShape(rec)
+}
+
+func checkValueShape(rec *Rec) (ValidationRule, string) {
+	if rec.Form == IDLitBin {
+		if rec.ValueTypeID != id.NoID || rec.ObjectID != id.NoID ||
+			rec.LangTag != "" || rec.StringVal != "" {
+			return ValueShapeVR, "binary literal record with non-binary value"
+		}
+		return 0, ""
+	}
+
+	if len(rec.BinVal) != 0 {
+		return ValueShapeVR, "binary value, but not a binary literal record"
+	}
+	if rec.ObjectID != id.NoID {
+		if rec.ValueTypeID != id.NoID {
+			return ValueShapeVR, "both object ID and value type"
+		}
+		if rec.LangTag != "" {
+			return ValueShapeVR, "language tag on object ID"
+		}
+		if rec.StringVal != "" {
+			return ValueShapeVR, "both object ID and string value"
+		}
+		return 0, ""
+	}
+	if rec.LangTag != "" && rec.ValueTypeID != id.RDFLangStringID {
+		return ValueShapeVR, "language tag on value type other than 'rdf:langString'"
+	}
+	if rec.Form == UsualTriple && rec.ValueTypeID == id.NoID {
+		return ValueShapeVR, "usual triple without object or value type"
+	}
+	// Container items may have no value: the removed value is optional
+	// in 'RemoveAtRT' records.
+	return 0, ""
+}
+
+// ValidatingSink = push sink wrapper checking each record (see Validator)
+// before passing it on; an invalid record is not passed on, and
+// the error returned is a *ValidationError.
+//
+// Changeset headers are passed on if the wrapped sink accepts them.
+//
+type ValidatingSink struct {
+	sink      RecPushSink
+	validator *Validator
+}
+
+// NewValidatingSink wraps the given push sink; if it is
+// a RecPushSinkEnder, use NewValidatingSinkEnder instead.
+func NewValidatingSink(sink RecPushSink) *ValidatingSink {
+	return &ValidatingSink{sink: sink, validator: NewValidator()}
+}
+
+func (vs *ValidatingSink) PutChangeRec(rec Rec) error {
+	err := vs.validator.Check(rec)
+	if err != nil {
+		return err
+	}
+	return vs.sink.PutChangeRec(rec)
+}
+
+func (vs *ValidatingSink) PutChangeSetInfo(info SetInfo) error {
+	err := vs.validator.StartSet(info.ID)
+	if err != nil {
+		return err
+	}
+	if infoSink, ok := vs.sink.(SetInfoPushSink); ok {
+		return infoSink.PutChangeSetInfo(info)
+	}
+	return nil
+}
+
+// Finish checks the end of the last changeset put (see Validator.Finish);
+// returns nil or a *ValidationError.
+func (vs *ValidatingSink) Finish() error {
+	return vs.validator.Finish()
+}
+
+// ValidatingSinkEnder = ValidatingSink for a RecPushSinkEnder.
+//
+// Call Finish before End to get the error if the last changeset
+// is incomplete (see Validator.Finish); End aborts instead of committing
+// in that case, and can only log the error.
+//
+type ValidatingSinkEnder struct {
+	ValidatingSink
+}
+
+func NewValidatingSinkEnder(sink RecPushSinkEnder) *ValidatingSinkEnder {
+	return &ValidatingSinkEnder{ValidatingSink{sink: sink, validator: NewValidator()}}
+}
+
+func (vs *ValidatingSinkEnder) End() {
+	ender := vs.sink.(RecPushSinkEnder)
+	err := vs.validator.Finish()
+	if err != nil {
+		log.Printf("Validating push sink aborting instead of ending: %v", err)
+		ender.Abort()
+		return
+	}
+	ender.End()
+}
+
+func (vs *ValidatingSinkEnder) Abort() {
+	vs.sink.(RecPushSinkEnder).Abort()
+}
+
+var _ RecPushSink = (*ValidatingSink)(nil)
+var _ SetInfoPushSink = (*ValidatingSink)(nil)
+var _ RecPushSinkFinisher = (*ValidatingSink)(nil)
+var _ RecPushSinkEnder = (*ValidatingSinkEnder)(nil)
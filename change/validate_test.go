@@ -0,0 +1,222 @@
+package change
+
+import (
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+const testCSetID id.IntID = 7
+
+func tripleRec(recType RecTypeCode, subjectID, propID, objectID id.IntID) Rec {
+	return Rec{ChangeSetID: testCSetID, Form: UsualTriple, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromID(propID), ObjectID: objectID}
+}
+
+func itemRec(recType RecTypeCode, subjectID id.IntID, pos int64, objectID id.IntID) Rec {
+	return Rec{ChangeSetID: testCSetID, Form: OrdContItem, ChangeRecType: recType,
+		SubjectID: subjectID, Prop: id.FromPos(pos), ObjectID: objectID}
+}
+
+// withOp returns the record with the given operation flags and
+// editing operation ID.
+func withOp(rec Rec, flags RecFlags, editOpCID id.IntID) Rec {
+	rec.ChangeRecFlags = flags
+	rec.EditOpCID = editOpCID
+	return rec
+}
+
+func TestValidateSet(t *testing.T) {
+	corrupted := tripleRec(AddRT, 1, 2, 3)
+	corrupted.Prop ^= 1 << 20
+
+	tests := []struct {
+		name  string
+		recs  []Rec
+		rule  ValidationRule // 0 if valid
+		index int            // of the invalid record
+	}{
+		{"valid records", []Rec{
+			tripleRec(AddRT, 1, 2, 3),
+			tripleRec(TestRT, 1, 2, 4),
+			{ChangeSetID: testCSetID, Form: UsualTriple, ChangeRecType: AddRT, SubjectID: 1,
+				Prop: id.FromID(2), ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "x"},
+			itemRec(AppendRT, 10, 0, 100),
+			itemRec(RemoveAtRT, 10, 0, id.NoID),
+			{ChangeSetID: testCSetID, Form: IDLitBin, ChangeRecType: InsertAtRT, SubjectID: 20,
+				Prop: id.FromPos(0), BinVal: []byte("abc")},
+		}, 0, 0},
+		{"valid operations", []Rec{
+			withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+			withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1),
+			withOp(itemRec(ReplaceAtRT, 10, 0, 101), SwappingRF, 2),
+			withOp(itemRec(ReplaceAtRT, 10, 1, 100), SwappingRF, 2),
+			withOp(tripleRec(TestRT, 1, 2, 3), CopyingRF, 3),
+			withOp(tripleRec(AddRT, 4, 2, 3), CopyingRF, 3),
+		}, 0, 0},
+		{"reordering pair around another container", []Rec{
+			withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+			itemRec(AppendRT, 11, 0, 110),
+			withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1),
+		}, 0, 0},
+
+		{"unknown form", []Rec{
+			tripleRec(AddRT, 1, 2, 3),
+			{ChangeSetID: testCSetID, Form: RecFormCode(99), ChangeRecType: AddRT},
+		}, KnownFormVR, 1},
+		{"type not for form", []Rec{
+			tripleRec(InsertAtRT, 1, 2, 3),
+		}, TypeForFormVR, 0},
+		{"corrupted property", []Rec{
+			corrupted,
+		}, PackedValueVR, 0},
+		{"position for usual triple", []Rec{
+			{ChangeSetID: testCSetID, Form: UsualTriple, ChangeRecType: AddRT,
+				SubjectID: 1, Prop: id.FromPos(2), ObjectID: 3},
+		}, PropKindVR, 0},
+		{"old position for usual triple", []Rec{
+			func() Rec { rec := tripleRec(AddRT, 1, 2, 3); rec.OldProp = id.FromPos(1); return rec }(),
+		}, PropKindVR, 0},
+		{"object ID and value type", []Rec{
+			func() Rec { rec := tripleRec(AddRT, 1, 2, 3); rec.ValueTypeID = 4; return rec }(),
+		}, ValueShapeVR, 0},
+		{"binary value for container item", []Rec{
+			func() Rec { rec := itemRec(AppendRT, 10, 0, 100); rec.BinVal = []byte("x"); return rec }(),
+		}, ValueShapeVR, 0},
+		{"other changeset", []Rec{
+			func() Rec { rec := tripleRec(AddRT, 1, 2, 4); rec.ChangeSetID = 8; return rec }(),
+		}, ChangeSetVR, 0},
+		{"flags differing in editing operation", []Rec{
+			withOp(itemRec(ReplaceAtRT, 10, 0, 101), SwappingRF, 1),
+			withOp(itemRec(ReplaceAtRT, 10, 1, 100), SwappingRF|MovingRF, 1),
+		}, EditOpCIDVR, 1},
+		{"reordering remove without insert", []Rec{
+			tripleRec(AddRT, 1, 2, 3),
+			withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+		}, ReorderingPairVR, 1},
+		{"reordering insert without remove", []Rec{
+			withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1),
+		}, ReorderingPairVR, 0},
+		{"other record in reordering pair", []Rec{
+			withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+			itemRec(AppendRT, 10, 0, 101),
+		}, ReorderingPairVR, 1},
+		{"reordering pair with different old position", []Rec{
+			withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+			func() Rec {
+				rec := withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1)
+				rec.OldProp = id.FromPos(1)
+				return rec
+			}(),
+		}, ReorderingPairVR, 1},
+		{"swap with one record", []Rec{
+			tripleRec(AddRT, 1, 2, 3),
+			withOp(itemRec(ReplaceAtRT, 10, 0, 101), SwappingRF, 1),
+		}, FlagGroupVR, 1},
+		{"copy without adding record", []Rec{
+			withOp(tripleRec(TestRT, 1, 2, 3), CopyingRF, 1),
+		}, FlagGroupVR, 0},
+		{"operation flag without editing operation", []Rec{
+			tripleRec(AddRT, 1, 2, 3),
+			withOp(tripleRec(AddRT, 4, 2, 3), CopyingRF, id.NoID),
+		}, FlagGroupVR, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSet(&Set{SetInfo: SetInfo{ID: testCSetID}, ChangeRecords: tt.recs})
+			if tt.rule == 0 {
+				if err != nil {
+					t.Errorf("ValidateSet failed: %v", err)
+				}
+				return
+			}
+			ve, ok := err.(*ValidationError)
+			if !ok || ve.Rule != tt.rule || ve.RecIndex != tt.index {
+				t.Errorf("ValidateSet returned %v, want rule %q for record #%d", err, tt.rule, tt.index)
+			}
+		})
+	}
+}
+
+// testSinkEnder records what it is given, and how it ended.
+type testSinkEnder struct {
+	recs           []Rec
+	ended, aborted bool
+}
+
+func (s *testSinkEnder) PutChangeRec(rec Rec) error {
+	s.recs = append(s.recs, rec)
+	return nil
+}
+
+func (s *testSinkEnder) End()   { s.ended = true }
+func (s *testSinkEnder) Abort() { s.aborted = true }
+
+func TestValidatingSinkEnder(t *testing.T) {
+	complete := []Rec{
+		tripleRec(AddRT, 1, 2, 3),
+		withOp(itemRec(RemoveAtRT, 10, 0, 100), ReorderingRF, 1),
+		withOp(itemRec(InsertAtRT, 10, 2, 100), ReorderingRF, 1),
+	}
+
+	tests := []struct {
+		name      string
+		recs      []Rec
+		finishErr bool
+	}{
+		{"complete changeset", complete, false},
+		{"incomplete reordering pair", complete[:2], true},
+		{"incomplete swap", []Rec{withOp(itemRec(ReplaceAtRT, 11, 0, 111), SwappingRF, 2)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, finish := range []bool{true, false} {
+				inner := &testSinkEnder{}
+				vs := NewValidatingSinkEnder(inner)
+				for _, rec := range tt.recs {
+					err := vs.PutChangeRec(rec)
+					if err != nil {
+						t.Fatalf("PutChangeRec failed: %v", err)
+					}
+				}
+				if finish {
+					err := vs.Finish()
+					if (err != nil) != tt.finishErr {
+						t.Errorf("Finish returned %v", err)
+					}
+				}
+				vs.End()
+				if inner.ended == tt.finishErr || inner.aborted != tt.finishErr {
+					t.Errorf("Wrapped sink ended %v, aborted %v", inner.ended, inner.aborted)
+				}
+				if len(inner.recs) != len(tt.recs) {
+					t.Errorf("Wrapped sink got %d records, want %d", len(inner.recs), len(tt.recs))
+				}
+			}
+		})
+	}
+
+	// An invalid record is not passed on; the next changeset
+	// reports the end of the previous one:
+	inner := &testSinkEnder{}
+	vs := NewValidatingSinkEnder(inner)
+	err := vs.PutChangeRec(tripleRec(InsertAtRT, 1, 2, 3))
+	if ve, ok := err.(*ValidationError); !ok || ve.Rule != TypeForFormVR {
+		t.Errorf("PutChangeRec of invalid record returned %v", err)
+	}
+	err = vs.PutChangeRec(complete[1])
+	if err != nil {
+		t.Fatalf("PutChangeRec failed: %v", err)
+	}
+	next := tripleRec(AddRT, 1, 2, 3)
+	next.ChangeSetID = testCSetID + 1
+	err = vs.PutChangeRec(next)
+	if ve, ok := err.(*ValidationError); !ok || ve.Rule != ReorderingPairVR || ve.RecIndex != 1 {
+		t.Errorf("PutChangeRec after incomplete changeset returned %v", err)
+	}
+	if len(inner.recs) != 1 {
+		t.Errorf("Wrapped sink got %d records, want 1", len(inner.recs))
+	}
+}
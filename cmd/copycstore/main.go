@@ -11,23 +11,15 @@ import (
 	"github.com/gimpldo/ba-prototype-go/change/cstore"
 	"github.com/gimpldo/ba-prototype-go/geconf"
 
-	// Changes store implementations:
-	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+	// Changes store implementations (registered by name, see cstore.Register):
+	_ "github.com/gimpldo/ba-prototype-go/cstorepg0"
+	_ "github.com/gimpldo/ba-prototype-go/cstoresqlite0"
 
 	// Drivers for the 'database/sql' package:
 	_ "github.com/gimpldo/go-sqlite3"
 	_ "github.com/lib/pq"
 )
 
-func mapNameToSQLDefFactory(defName string) cstore.SQLDefFactory {
-	switch defName {
-	case "cstoresqlite0":
-		return cstoresqlite0.SQLDefFactory{}
-	default:
-		return nil
-	}
-}
-
 type dbOpenInfo struct {
 	dbDriverName string
 	dbDSN        string // 'DSN' = "Data Source Name"
@@ -38,6 +30,8 @@ type cstoreInfo struct {
 
 	label string // for messages
 
+	// The changes store implementation; nil until found from
+	// the conf table of the existing store, if not given by name
 	sqlDefFactory cstore.SQLDefFactory
 
 	prefix        string
@@ -54,7 +48,7 @@ const specialDSNSameDB = "." // Must be non-empty string; chose a single dot for
 
 func main() {
 	var (
-		sourceInfo    cstoreInfo
+		sourceInfo    = cstoreInfo{label: "source"}
 		sourceDefName string
 	)
 	flag.StringVar(&sourceInfo.dbDriverName, "from-db-driver", "", "Source database driver name")
@@ -63,7 +57,8 @@ func main() {
 	flag.StringVar(&sourceInfo.prefix, "from-prefix", "",
 		"Schema element name prefix for the source changes store (optional: may be empty string)")
 	flag.StringVar(&sourceDefName, "from-def", "",
-		"Schema definition name: identify the source changes store implementation")
+		"Schema definition name: identify the source changes store implementation"+
+			" (optional: found in its conf table)")
 
 	// There is no need for flags like
 	// '--from-options=...' or '--source-options=...'
@@ -74,7 +69,7 @@ func main() {
 	// they were decided when the CStore was created.
 
 	var (
-		destInfo    cstoreInfo
+		destInfo    = cstoreInfo{label: "destination"}
 		destDefName string
 	)
 	flag.StringVar(&destInfo.dbDriverName, "to-db-driver", "", "Destination database driver name")
@@ -83,7 +78,8 @@ func main() {
 	flag.StringVar(&destInfo.prefix, "to-prefix", "",
 		"Schema element name prefix for the destination changes store (optional: may be empty string)")
 	flag.StringVar(&destDefName, "to-def", "",
-		"Schema definition name: identify the destination changes store implementation")
+		"Schema definition name: identify the destination changes store implementation"+
+			" (optional: found in its conf table, or same as the source when creating it)")
 
 	flag.StringVar(&destInfo.createOptions, "create-options", "",
 		"Schema definition options for the destination changes store (only used when creating it)")
@@ -134,10 +130,6 @@ func main() {
 		fmt.Println("Source DSN not specified. Use --from-db-dsn=...")
 		os.Exit(12)
 	}
-	if sourceDefName == "" {
-		fmt.Println("Source definition name not specified. Use --from-def=...")
-		os.Exit(13)
-	}
 
 	sameDB := false
 
@@ -167,19 +159,6 @@ func main() {
 		}
 	}
 
-	if destDefName == "" {
-		if sourceDefName == "" {
-			panic("Source definition name not specified; should have exited already!")
-		}
-
-		destDefName = sourceDefName
-
-		fmt.Printf("Using the source definition ('%s') for the destination\n",
-			destDefName)
-		// Showing the _destination_ definition name (that should have been set)
-		// to make it easier to notice a bug if it's introduced here.
-	}
-
 	if sameDB {
 		if sourceInfo.prefix == destInfo.prefix {
 			fmt.Printf("Source and destination prefix cannot be identical (%q) when copying to same database.\n",
@@ -188,17 +167,42 @@ func main() {
 		}
 	}
 
-	sourceInfo.sqlDefFactory = mapNameToSQLDefFactory(sourceDefName)
-	if sourceInfo.sqlDefFactory == nil {
+	// An empty name leaves the factory nil: see findSQLDefFactory()
+	sourceInfo.sqlDefFactory = lookupSQLDefFactory(sourceDefName, 13)
+	destInfo.sqlDefFactory = lookupSQLDefFactory(destDefName, 17)
 
-	}
-
-	destInfo.sqlDefFactory = mapNameToSQLDefFactory(destDefName)
-	if destInfo.sqlDefFactory == nil {
+	os.Exit(dbCopy(destInfo, sourceInfo, sameDB))
+}
 
+// lookupSQLDefFactory returns the changes store implementation registered
+// with the given name, nil for the empty name; exits if unknown.
+func lookupSQLDefFactory(defName string, exitCode int) cstore.SQLDefFactory {
+	if defName == "" {
+		return nil
 	}
+	factory, found := cstore.Lookup(defName)
+	if !found {
+		fmt.Printf("Unknown schema definition '%s' (known: %v)\n",
+			defName, cstore.List())
+		os.Exit(exitCode)
+	}
+	return factory
+}
 
-	os.Exit(dbCopy(destInfo, sourceInfo, sameDB))
+// findSQLDefFactory sets the changes store implementation, if not given
+// by name, from the conf table of the existing store.
+func findSQLDefFactory(info *cstoreInfo, db *sql.DB) error {
+	if info.sqlDefFactory != nil {
+		return nil
+	}
+	implName, factory, err := cstore.LookupForStore(db, info.prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Using the changes store implementation '%s' for the %s (from the store conf)\n",
+		implName, info.label)
+	info.sqlDefFactory = factory
+	return nil
 }
 
 // Harder to do DB work in main().
@@ -268,6 +272,25 @@ func dbCopy(destInfo, sourceInfo cstoreInfo, sameDB bool) int {
 		}
 	}
 
+	err = findSQLDefFactory(&sourceInfo, sourceDB)
+	if err != nil {
+		fmt.Printf("Could not find the source changes store implementation (use --from-def=...): %#+v\n",
+			err)
+		return 7
+	}
+
+	if destInfo.sqlDefFactory == nil && destInfo.createStore {
+		destInfo.sqlDefFactory = sourceInfo.sqlDefFactory
+
+		fmt.Println("Using the source changes store implementation for the destination")
+	}
+	err = findSQLDefFactory(&destInfo, destDB)
+	if err != nil {
+		fmt.Printf("Could not find the destination changes store implementation (use --to-def=...): %#+v\n",
+			err)
+		return 8
+	}
+
 	sourceSQLDef, err := sourceInfo.sqlDefFactory.OpenSQLStoreReadOnly(sourceDB, sourceInfo.prefix)
 	if err != nil {
 		fmt.Printf("Failed to open store read-only and get source SQLDef instance: %#+v\n",
@@ -299,6 +322,8 @@ func dbCopy(destInfo, sourceInfo cstoreInfo, sameDB bool) int {
 			sourceInfo.dbDriverName, sourceInfo.dbDSN, err)
 		return 25
 	}
+	fmt.Println("Report from source check")
+	reportFromSourceCheck.Dump(os.Stdout, 2)
 
 	reportFromDestCheck, err := destSQLDef.CheckCStoreSchema()
 	if err != nil {
@@ -306,14 +331,18 @@ func dbCopy(destInfo, sourceInfo cstoreInfo, sameDB bool) int {
 			destInfo.dbDriverName, destInfo.dbDSN, err)
 		return 26
 	}
+	fmt.Println("Report from destination check")
+	reportFromDestCheck.Dump(os.Stdout, 2)
 
 	if destInfo.createStore || destInfo.createMissingElems {
-		reportFromCreate, err := destSQLDef.CreateCStoreSchemaElements()
-		if err != nil {
+		reportFromCreate, createErr := destSQLDef.CreateCStoreSchemaElements()
+		if createErr != nil {
 			fmt.Printf("Schema elements creation failed for destination '%s' database with DSN '%s': %#+v\n",
-				destInfo.dbDriverName, destInfo.dbDSN, err)
+				destInfo.dbDriverName, destInfo.dbDSN, createErr)
 			return 27
 		}
+		fmt.Println("Report from destination schema elements creation")
+		reportFromCreate.Dump(os.Stdout, 2)
 	}
 
 	// 'dop' in this case is a changes store Data Operator instance:
@@ -10,23 +10,15 @@ import (
 	"github.com/gimpldo/ba-prototype-go/geconf"
 	"github.com/gimpldo/sqlite3-util-go/sqlite3tracemask"
 
-	// Changes store implementations:
-	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+	// Changes store implementations (registered by name, see cstore.Register):
+	_ "github.com/gimpldo/ba-prototype-go/cstorepg0"
+	_ "github.com/gimpldo/ba-prototype-go/cstoresqlite0"
 
 	// Drivers for the 'database/sql' package:
 	sqlite3 "github.com/gimpldo/go-sqlite3"
 	_ "github.com/lib/pq"
 )
 
-func mapNameToSQLDefFactory(defName string) cstore.SQLDefFactory {
-	switch defName {
-	case "cstoresqlite0":
-		return cstoresqlite0.SQLDefFactory{}
-	default:
-		return nil
-	}
-}
-
 func traceCallback(info sqlite3.TraceInfo) int {
 	// Not very readable but may be useful; uncomment next line in case of doubt:
 	//fmt.Printf("Trace: %#v\n", info)
@@ -142,13 +134,14 @@ type expRequest struct {
 // filled based on command line options, for now.
 //
 // The order of actions is:
+//    - migrate (before the check, so the check shows the result),
 //    - create,
 //    - expFirstReq (export first, before other steps),
 //    - impReq (import),
 //    - expLastReq (export last, after all other steps),
 //    - drop.
 //
-// Intended/typical use is: only one or two of the five actions are selected.
+// Intended/typical use is: only one or two of the six actions are selected.
 //
 // The trailing 'Req' stands for "Request" or "Requested".
 //
@@ -158,6 +151,7 @@ type cstActions struct {
 
 	createStore        bool
 	createMissingElems bool
+	migrateSchema      bool
 	dropAllElems       bool
 
 	expFirstReq *expRequest
@@ -193,7 +187,8 @@ func main() {
 	flag.StringVar(&actions.prefix, "cstore-elem-prefix", "",
 		"Schema element name prefix for the changes store (optional: may be empty string)")
 	flag.StringVar(&cstoreDefName, "cstore-def", "",
-		"Schema definition name: identify the changes store implementation")
+		"Schema definition name: identify the changes store implementation"+
+			" (optional for an existing store: found in its conf table)")
 
 	flag.StringVar(&actions.createOptions, "cstore-create-options", "",
 		"Schema definition options for the changes store (only used when creating a store)")
@@ -201,6 +196,8 @@ func main() {
 		"Create the changes store")
 	flag.BoolVar(&actions.createMissingElems, "create-missing", false,
 		"Create the missing schema elements for the changes store implementation")
+	flag.BoolVar(&actions.migrateSchema, "migrate", false,
+		"Upgrade the schema of the changes store to the latest version of its implementation")
 	flag.BoolVar(&actions.dropAllElems, "drop-all", false,
 		"Drop all the schema elements known by the changes store implementation")
 
@@ -354,17 +351,23 @@ func main() {
 			})
 	}
 
-	if cstoreDefName == "" {
-		fmt.Printf("Missing '--cstore-def=...': need schema definition name\n")
-		os.Exit(31)
-	}
+	var sqlDefFactory cstore.SQLDefFactory
 
-	sqlDefFactory := mapNameToSQLDefFactory(cstoreDefName)
-	if sqlDefFactory == nil {
-		fmt.Printf("Unknown schema definition '%s'\n",
-			cstoreDefName)
-		os.Exit(32)
+	if cstoreDefName != "" {
+		var found bool
+		sqlDefFactory, found = cstore.Lookup(cstoreDefName)
+		if !found {
+			fmt.Printf("Unknown schema definition '%s' (known: %v)\n",
+				cstoreDefName, cstore.List())
+			os.Exit(32)
+		}
+	} else if actions.createStore {
+		fmt.Printf("Missing '--cstore-def=...': need schema definition name to create a store (known: %v)\n",
+			cstore.List())
+		os.Exit(31)
 	}
+	// Otherwise (nil 'sqlDefFactory'), the implementation is the one
+	// named in the conf table of the existing store, see dbMain().
 
 	os.Exit(dbMain(openInfo, sqlDefFactory, actions))
 }
@@ -394,6 +397,18 @@ func dbMain(openInfo dbOpenInfo, sqlDefFactory cstore.SQLDefFactory, actions cst
 		return 4
 	}
 
+	if sqlDefFactory == nil {
+		implName, factory, lookupErr := cstore.LookupForStore(db, actions.prefix)
+		if lookupErr != nil {
+			fmt.Printf("Could not find the changes store implementation (use --cstore-def=...): %#+v\n",
+				lookupErr)
+			return 8
+		}
+		fmt.Printf("Using the changes store implementation '%s' (from the store conf)\n",
+			implName)
+		sqlDefFactory = factory
+	}
+
 	var sqlDef cstore.SQLDef
 
 	if actions.createStore {
@@ -412,6 +427,18 @@ func dbMain(openInfo dbOpenInfo, sqlDefFactory cstore.SQLDefFactory, actions cst
 		}
 	}
 
+	if actions.migrateSchema {
+		reportFromMigrate, migrateErr := sqlDef.MigrateCStoreSchema()
+		if migrateErr != nil {
+			fmt.Printf("Schema migration failed for '%s' database with DSN '%s': %#+v\n",
+				openInfo.dbDriverName, openInfo.dbDSN, migrateErr)
+			reportFromMigrate.Dump(os.Stdout, 2)
+			return 10
+		}
+		fmt.Println("Report from schema migration")
+		reportFromMigrate.Dump(os.Stdout, 2)
+	}
+
 	reportFromCheck, err := sqlDef.CheckCStoreSchema()
 	if err != nil {
 		fmt.Printf("Check failed for '%s' database with DSN '%s': %#+v\n",
@@ -0,0 +1,241 @@
+/*
+Package cstoreconfsql reads and checks the changes store configuration
+table ('cstore_conf') for changes store implementations using SQL databases.
+
+The configuration table is the "head" table of a changes store:
+its presence tells that a store exists (with the given prefix), and
+its entries are the definition options used when the store was created.
+*/
+package cstoreconfsql
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gimpldo/ba-prototype-go/geconf"
+)
+
+// ImplNameProperty is the configuration property that identifies
+// the changes store implementation which created the store;
+// it must be the first entry (see CheckOrder).
+const ImplNameProperty = "CStoreImplName"
+
+const confTableBaseName = "cstore_conf"
+
+// ConfTableMissingError is returned when the configuration table
+// does not exist = there is no changes store with the given prefix.
+type ConfTableMissingError struct {
+	TableName string
+	Err       error
+}
+
+func (e *ConfTableMissingError) Error() string {
+	return fmt.Sprintf("Conf table %q missing: %v", e.TableName, e.Err)
+}
+
+// ConfTableEmptyError is returned when the configuration table exists
+// but has no entries: not a valid changes store (maybe its creation
+// was interrupted), but the prefix is not free either.
+type ConfTableEmptyError struct {
+	TableName string
+}
+
+func (e *ConfTableEmptyError) Error() string {
+	return fmt.Sprintf("Conf table %q is empty", e.TableName)
+}
+
+// ConfReadError is returned when reading the configuration table failed
+// for other reasons than the table missing.
+// Entries read before the failure (if any) are returned along with the error.
+type ConfReadError struct {
+	TableName string
+	NumRead   int
+	Err       error
+}
+
+func (e *ConfReadError) Error() string {
+	return fmt.Sprintf("Failed to read conf table %q (after %d entries): %v",
+		e.TableName, e.NumRead, e.Err)
+}
+
+// ConfOrderError is returned by CheckOrder.
+type ConfOrderError struct {
+	Index         int
+	OffenderDescr string
+	BadEntry      geconf.Entry
+}
+
+func (e *ConfOrderError) Error() string {
+	return fmt.Sprintf("%s at %d: %#v", e.OffenderDescr, e.Index, e.BadEntry)
+}
+
+// ImplNameMismatchError is returned by CheckImplName.
+type ImplNameMismatchError struct {
+	Expected string
+	Found    string
+}
+
+func (e *ImplNameMismatchError) Error() string {
+	return fmt.Sprintf("Store created by implementation %q cannot be used by %q",
+		e.Found, e.Expected)
+}
+
+// ReadConfFromDB reads all the entries from the configuration table
+// ('cstore_conf' with the given prefix) and returns them in canonical order
+// (see SortConf).
+//
+// The error returned (if any) is one of *ConfTableMissingError,
+// *ConfTableEmptyError or *ConfReadError; in the last case
+// the entries read before the failure are returned too.
+//
+func ReadConfFromDB(db *sql.DB, storePrefix string) ([]geconf.Entry, error) {
+	tableName := storePrefix + confTableBaseName
+
+	err := checkPrefixChars(storePrefix)
+	if err != nil {
+		return nil, &ConfReadError{TableName: tableName, Err: err}
+	}
+
+	rows, err := db.Query(
+		"SELECT cstore_element, cstore_property, cstore_value FROM " + tableName)
+	if err != nil {
+		if isMissingTableErr(err) {
+			return nil, &ConfTableMissingError{TableName: tableName, Err: err}
+		}
+		return nil, &ConfReadError{TableName: tableName, Err: err}
+	}
+	defer rows.Close()
+
+	var result []geconf.Entry
+	for rows.Next() {
+		var entry geconf.Entry
+		err = rows.Scan(&entry.ConfElement, &entry.ConfProperty, &entry.ConfValue)
+		if err != nil {
+			return result, &ConfReadError{TableName: tableName, NumRead: len(result), Err: err}
+		}
+		result = append(result, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return result, &ConfReadError{TableName: tableName, NumRead: len(result), Err: err}
+	}
+
+	if len(result) == 0 {
+		return nil, &ConfTableEmptyError{TableName: tableName}
+	}
+
+	SortConf(result)
+	return result, nil
+}
+
+// SortConf sorts configuration entries in the order used for
+// a changes store configuration: the implementation name entry first,
+// followed by all other entries in geconf.CanonicalOrder.
+//
+// This is the order in which the entries are written when a store is created;
+// there is no row order in a database table, so it must be restored on reading.
+//
+func SortConf(confEntries []geconf.Entry) {
+	sort.Sort(geconf.CanonicalOrder(confEntries))
+
+	for i := range confEntries {
+		if isImplNameEntry(confEntries[i]) {
+			implEntry := confEntries[i]
+			copy(confEntries[1:i+1], confEntries[:i])
+			confEntries[0] = implEntry
+			break
+		}
+	}
+}
+
+// CheckOrder verifies that the implementation name entry is first
+// (and unique), followed by the other entries in canonical order
+// without duplicates (same element and property).
+func CheckOrder(confEntries []geconf.Entry) error {
+	if len(confEntries) == 0 {
+		return &ConfOrderError{Index: 0, OffenderDescr: "No conf entries"}
+	}
+	if !isImplNameEntry(confEntries[0]) {
+		return &ConfOrderError{Index: 0,
+			OffenderDescr: "First entry is not " + ImplNameProperty,
+			BadEntry:      confEntries[0],
+		}
+	}
+
+	rest := confEntries[1:]
+	for i := range rest {
+		if isImplNameEntry(rest[i]) {
+			return &ConfOrderError{Index: i + 1,
+				OffenderDescr: "Duplicate " + ImplNameProperty,
+				BadEntry:      rest[i],
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		if rest[i].ConfElement == rest[i-1].ConfElement &&
+			rest[i].ConfProperty == rest[i-1].ConfProperty {
+			return &ConfOrderError{Index: i + 1,
+				OffenderDescr: "Duplicate element and property",
+				BadEntry:      rest[i],
+			}
+		}
+		if geconf.CanonicalOrder(rest).Less(i, i-1) {
+			return &ConfOrderError{Index: i + 1,
+				OffenderDescr: "Not in canonical order",
+				BadEntry:      rest[i],
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckImplName verifies that the configuration (already checked by
+// CheckOrder) was written by the changes store implementation
+// with the given name, so a store created by one implementation
+// is not silently opened by another.
+func CheckImplName(confEntries []geconf.Entry, implName string) error {
+	if len(confEntries) == 0 || !isImplNameEntry(confEntries[0]) {
+		return CheckOrder(confEntries)
+	}
+	if confEntries[0].ConfValue != implName {
+		return &ImplNameMismatchError{Expected: implName, Found: confEntries[0].ConfValue}
+	}
+	return nil
+}
+
+func isImplNameEntry(entry geconf.Entry) bool {
+	return entry.ConfElement == "" && entry.ConfProperty == ImplNameProperty
+}
+
+// isMissingTableErr recognizes the "table does not exist" errors
+// by message text, because 'database/sql' has no standard error
+// (or error code) for this case.
+//
+// Known messages:
+//  - SQLite: "no such table: ..."
+//  - PostgreSQL: "relation ... does not exist" (SQLSTATE 42P01)
+//
+func isMissingTableErr(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "no such table") ||
+		(strings.Contains(msg, "relation") && strings.Contains(msg, "does not exist"))
+}
+
+// checkPrefixChars uses a very strict (whitelist) approach to validation,
+// similar to the changes store implementations; a dot is accepted
+// because the prefix may include a schema or database name.
+func checkPrefixChars(prefix string) error {
+	for i, ch := range prefix {
+		switch {
+		case 'a' <= ch && ch <= 'z':
+		case '0' <= ch && ch <= '9':
+		case ch == '_' || ch == '.':
+		default:
+			return fmt.Errorf("Prefix is not safe: %x at %d.", ch, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package cstoreconfsql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/gimpldo/ba-prototype-go/geconf"
+	"github.com/pkg/errors"
+)
+
+// SchemaVersionProperty is the configuration property (global entry)
+// that tells the version of the store's schema, as defined by
+// the implementation which created the store (see ImplNameProperty);
+// a migration (see cstore.SQLDef) upgrades it.
+const SchemaVersionProperty = "CStoreSchemaVersion"
+
+// FirstSchemaVersion is the version of the schema of the stores
+// without schema version entry (created before the entry was introduced).
+const FirstSchemaVersion = 1
+
+// SchemaVersion returns the schema version from the configuration
+// (FirstSchemaVersion if there is no schema version entry).
+func SchemaVersion(confEntries []geconf.Entry) (int, error) {
+	for _, entry := range confEntries {
+		if !isSchemaVersionEntry(entry) {
+			continue
+		}
+		version, err := strconv.Atoi(entry.ConfValue)
+		if err != nil || version < FirstSchemaVersion {
+			return 0, errors.Errorf("Bad schema version %q in conf entry %#v",
+				entry.ConfValue, entry)
+		}
+		return version, nil
+	}
+	return FirstSchemaVersion, nil
+}
+
+// SchemaVersionEntry returns the configuration entry for the given schema version.
+func SchemaVersionEntry(version int) geconf.Entry {
+	return geconf.Entry{
+		ConfProperty: SchemaVersionProperty,
+		ConfValue:    strconv.Itoa(version),
+	}
+}
+
+// SetSchemaVersion returns the configuration with the schema version entry
+// set to the given version (replaced, or added and sorted, see SortConf).
+func SetSchemaVersion(confEntries []geconf.Entry, version int) []geconf.Entry {
+	for i := range confEntries {
+		if isSchemaVersionEntry(confEntries[i]) {
+			confEntries[i].ConfValue = strconv.Itoa(version)
+			return confEntries
+		}
+	}
+	confEntries = append(confEntries, SchemaVersionEntry(version))
+	SortConf(confEntries)
+	return confEntries
+}
+
+// WriteSchemaVersion writes the schema version entry into
+// the configuration table ('cstore_conf' with the given prefix),
+// replacing the one already there (if any).
+//
+// The statements have no parameters (the values are safe constants),
+// so they work with any SQL database and driver.
+//
+func WriteSchemaVersion(tx *sql.Tx, storePrefix string, version int) error {
+	tableName := storePrefix + confTableBaseName
+
+	err := checkPrefixChars(storePrefix)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE cstore_element = '' AND cstore_property = '%s'",
+		tableName, SchemaVersionProperty))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete schema version from conf table %q", tableName)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (cstore_element, cstore_property, cstore_value) VALUES ('', '%s', '%d')",
+		tableName, SchemaVersionProperty, version))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write schema version %d into conf table %q",
+			version, tableName)
+	}
+	return nil
+}
+
+func isSchemaVersionEntry(entry geconf.Entry) bool {
+	return entry.ConfElement == "" && entry.ConfProperty == SchemaVersionProperty
+}
@@ -0,0 +1,90 @@
+// cstoremem/idget.go: Internal and External ID getters for the in-memory store
+
+package cstoremem
+
+import (
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// intIDGetter implements id.InternalIDGetCloser;
+// the IDs are taken from the store, one by one (no blocks needed).
+type intIDGetter struct {
+	store *Store
+
+	closed bool
+}
+
+func (s *Store) MakeInternalIDGetCloser() (id.InternalIDGetCloser, error) {
+	return &intIDGetter{store: s}, nil
+}
+
+func (g *intIDGetter) GetNewInternalID() (id.IntID, error) {
+	if g.closed {
+		return id.NoID, errors.Errorf("Internal ID getter already closed")
+	}
+
+	g.store.mu.Lock()
+	defer g.store.mu.Unlock()
+
+	return g.store.takeID(), nil
+}
+
+func (g *intIDGetter) Close() error {
+	g.closed = true
+	return nil
+}
+
+// takeID returns a new internal ID (the caller holds the lock).
+func (s *Store) takeID() id.IntID {
+	newID := s.nextFreeID
+	s.nextFreeID++
+	return newID
+}
+
+// extIDGetter implements id.ExternalIDGetCloser.
+type extIDGetter struct {
+	store *Store
+
+	closed bool
+}
+
+func (s *Store) MakeExternalIDGetCloser() (id.ExternalIDGetCloser, error) {
+	return &extIDGetter{store: s}, nil
+}
+
+func (g *extIDGetter) LookupInternalIDForIRI(iri string) (id.IntID, bool, error) {
+	if g.closed {
+		return id.NoID, false, errors.Errorf("External ID getter already closed")
+	}
+
+	g.store.mu.Lock()
+	defer g.store.mu.Unlock()
+
+	intID, found := g.store.extIDs[iri]
+	return intID, found, nil
+}
+
+func (g *extIDGetter) GetInternalIDForIRI(iri string) (id.IntID, error) {
+	if g.closed {
+		return id.NoID, errors.Errorf("External ID getter already closed")
+	}
+	if iri == "" {
+		return id.NoID, errors.Errorf("Empty IRI")
+	}
+
+	g.store.mu.Lock()
+	defer g.store.mu.Unlock()
+
+	intID, found := g.store.extIDs[iri]
+	if !found {
+		intID = g.store.takeID()
+		g.store.extIDs[iri] = intID
+	}
+	return intID, nil
+}
+
+func (g *extIDGetter) Close() error {
+	g.closed = true
+	return nil
+}
@@ -0,0 +1,75 @@
+// cstoremem/pullsrc.go: change record Pull Source for the in-memory store
+
+package cstoremem
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// memPullSource implements change.RecPullSourceCloser,
+// on a snapshot of the records taken when it was made.
+type memPullSource struct {
+	recs []change.Rec
+	next int
+
+	closed bool
+}
+
+func (s *Store) MakeCRecPullSourceCloser() (change.RecPullSourceCloser, error) {
+	return s.MakeCSetRangePullSourceCloser(id.NoID+1, math.MaxInt64)
+}
+
+// MakeCSetRangePullSourceCloser gives the records of the changesets
+// in the given range, in changeset ID order; inside a changeset,
+// in the order they were put (as the SQL implementations).
+func (s *Store) MakeCSetRangePullSourceCloser(firstCSetID, lastCSetID id.IntID) (change.RecPullSourceCloser, error) {
+	if firstCSetID > lastCSetID {
+		return nil, errors.Errorf("Bad changeset ID range: first %d > last %d",
+			firstCSetID, lastCSetID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var csetIDs []id.IntID
+	for csetID := range s.csets {
+		if firstCSetID <= csetID && csetID <= lastCSetID {
+			csetIDs = append(csetIDs, csetID)
+		}
+	}
+	sort.Slice(csetIDs, func(i, j int) bool { return csetIDs[i] < csetIDs[j] })
+
+	src := &memPullSource{}
+	for _, csetID := range csetIDs {
+		for _, rec := range s.csets[csetID].recs {
+			if rec.BinVal != nil {
+				rec.BinVal = append([]byte{}, rec.BinVal...)
+			}
+			src.recs = append(src.recs, rec)
+		}
+	}
+	return src, nil
+}
+
+func (src *memPullSource) GetNextChangeRec() (rec change.Rec, gotRec bool, err error) {
+	if src.closed {
+		return rec, false, errors.Errorf("Pull source already closed")
+	}
+	if src.next >= len(src.recs) {
+		return rec, false, nil
+	}
+	rec = src.recs[src.next]
+	src.next++
+	return rec, true, nil
+}
+
+func (src *memPullSource) Close() error {
+	src.closed = true
+	src.recs = nil
+	return nil
+}
@@ -0,0 +1,221 @@
+// cstoremem/pushsink.go: change record Push Sink for the in-memory store
+
+package cstoremem
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// memPushSink implements change.RecPushSink and,
+// when made by MakeCRecPushSinkEnder, change.RecPushSinkEnder.
+//
+// A buffering sink keeps the headers and records until End
+// (stored all together, or none if one is rejected); otherwise
+// each header or record is stored when put.
+//
+type memPushSink struct {
+	store *Store
+
+	buffering bool
+	pending   []pendingItem
+
+	ended bool
+}
+
+// pendingItem = header or record put into a buffering sink
+type pendingItem struct {
+	isInfo bool
+	info   change.SetInfo
+	rec    change.Rec
+}
+
+// MakeCRecPushSink makes a push sink storing each record when put
+// (the transaction is ignored); the records are validated first
+// (see change.ValidatingSink).
+func (s *Store) MakeCRecPushSink(*sql.Tx) (change.RecPushSink, error) {
+	return change.NewValidatingSink(&memPushSink{store: s}), nil
+}
+
+// MakeCRecPushSinkEnder makes a push sink storing the records when
+// it ends (commit on End, nothing stored on Abort); the transaction
+// is ignored. The records are validated first (see change.ValidatingSinkEnder).
+func (s *Store) MakeCRecPushSinkEnder(*sql.Tx) (change.RecPushSinkEnder, error) {
+	return change.NewValidatingSinkEnder(&memPushSink{store: s, buffering: true}), nil
+}
+
+func (sink *memPushSink) PutChangeRec(rec change.Rec) error {
+	if sink.ended {
+		return errors.Errorf("Push sink already ended")
+	}
+	if rec.ChangeSetID == id.NoID {
+		return errors.Errorf("Change record without changeset ID: %+v", rec)
+	}
+	if sink.buffering {
+		sink.pending = append(sink.pending, pendingItem{rec: rec})
+		return nil
+	}
+
+	sink.store.mu.Lock()
+	defer sink.store.mu.Unlock()
+
+	return sink.store.putRec(rec, nil)
+}
+
+// PutChangeSetInfo stores the changeset header (metadata);
+// must be called before putting the changeset's records, and
+// fails if the changeset's header is already stored.
+func (sink *memPushSink) PutChangeSetInfo(info change.SetInfo) error {
+	if sink.ended {
+		return errors.Errorf("Push sink already ended")
+	}
+	if info.ID == id.NoID {
+		return errors.Errorf("Changeset header without ID")
+	}
+	if sink.buffering {
+		for _, item := range sink.pending {
+			if item.isInfo && item.info.ID == info.ID ||
+				!item.isInfo && item.rec.ChangeSetID == info.ID {
+				return errors.Errorf("Header for changeset %d already written (records put before header?)",
+					info.ID)
+			}
+		}
+		sink.pending = append(sink.pending, pendingItem{isInfo: true, info: info})
+		return nil
+	}
+
+	sink.store.mu.Lock()
+	defer sink.store.mu.Unlock()
+
+	return sink.store.putSetInfo(info, nil)
+}
+
+func (sink *memPushSink) End() {
+	if sink.ended {
+		return
+	}
+	sink.ended = true
+
+	sink.store.mu.Lock()
+	defer sink.store.mu.Unlock()
+
+	// Remember what was added, so it can be undone if a record
+	// is rejected (as a failed transaction).
+	var undo undoLog
+	for _, item := range sink.pending {
+		var err error
+		if item.isInfo {
+			err = sink.store.putSetInfo(item.info, &undo)
+		} else {
+			err = sink.store.putRec(item.rec, &undo)
+		}
+		if err != nil {
+			sink.store.rollBack(&undo)
+			log.Printf("Push sink failed to commit: %v", err)
+			break
+		}
+	}
+	sink.pending = nil
+}
+
+func (sink *memPushSink) Abort() {
+	sink.ended = true
+	sink.pending = nil
+}
+
+// undoLog = what was added to the store by a buffering push sink
+type undoLog struct {
+	newCSets   []id.IntID
+	tripleKeys []tripleKey
+
+	// Number of records of each changeset before
+	nRecsBefore map[id.IntID]int
+}
+
+// putSetInfo stores a changeset header (the caller holds the lock).
+func (s *Store) putSetInfo(info change.SetInfo, undo *undoLog) error {
+	if _, found := s.csets[info.ID]; found {
+		return errors.Errorf("Failed to write header for changeset %d: already stored", info.ID)
+	}
+	s.csets[info.ID] = &storedSet{info: copySetInfo(info)}
+	if undo != nil {
+		undo.newCSets = append(undo.newCSets, info.ID)
+	}
+	return nil
+}
+
+// putRec stores a change record (the caller holds the lock),
+// with an empty header for its changeset if there is none yet.
+func (s *Store) putRec(rec change.Rec, undo *undoLog) error {
+	cset, found := s.csets[rec.ChangeSetID]
+	if !found {
+		cset = &storedSet{info: change.SetInfo{ID: rec.ChangeSetID}}
+		s.csets[rec.ChangeSetID] = cset
+		if undo != nil {
+			undo.newCSets = append(undo.newCSets, rec.ChangeSetID)
+		}
+	}
+
+	if rec.Form == change.UsualTriple && !change.IsInfoRecType(rec.ChangeRecType) {
+		propID, _ := rec.Prop.ID()
+		key := tripleKey{
+			csetID: rec.ChangeSetID, subjectID: rec.SubjectID, propID: propID,
+			valueTypeID: rec.ValueTypeID, objectID: rec.ObjectID,
+			langTag: rec.LangTag, stringVal: rec.StringVal,
+			recType: rec.ChangeRecType,
+		}
+		if s.tripleKeys[key] {
+			return errors.Errorf("Failed to store change record: same triple and type already in changeset %d: %+v",
+				rec.ChangeSetID, rec)
+		}
+		s.tripleKeys[key] = true
+		if undo != nil {
+			undo.tripleKeys = append(undo.tripleKeys, key)
+		}
+	}
+
+	if undo != nil {
+		if undo.nRecsBefore == nil {
+			undo.nRecsBefore = make(map[id.IntID]int)
+		}
+		if _, seen := undo.nRecsBefore[rec.ChangeSetID]; !seen {
+			undo.nRecsBefore[rec.ChangeSetID] = len(cset.recs)
+		}
+	}
+	cset.recs = append(cset.recs, storedRec(rec))
+	return nil
+}
+
+// rollBack removes what was added (the caller holds the lock).
+func (s *Store) rollBack(undo *undoLog) {
+	for csetID, n := range undo.nRecsBefore {
+		if cset, found := s.csets[csetID]; found {
+			cset.recs = cset.recs[:n]
+		}
+	}
+	for _, key := range undo.tripleKeys {
+		delete(s.tripleKeys, key)
+	}
+	for _, csetID := range undo.newCSets {
+		delete(s.csets, csetID)
+	}
+}
+
+// storedRec returns the record as the SQL implementations give it back:
+// own copy of the binary value, only for identified binary literals
+// (empty, not nil); no old property for usual triples.
+func storedRec(rec change.Rec) change.Rec {
+	if rec.Form == change.IDLitBin {
+		rec.BinVal = append([]byte{}, rec.BinVal...)
+	} else {
+		rec.BinVal = nil
+	}
+	if rec.Form == change.UsualTriple {
+		rec.OldProp = 0
+	}
+	return rec
+}
@@ -0,0 +1,114 @@
+// cstoremem/store.go: in-memory changes store (Data Operator without database)
+
+/*
+Package cstoremem implements an in-memory changes store, behind
+the same Data Operator interfaces as the SQL implementations
+(cstore.ReadingDop and cstore.Dop), for tests and short-lived tools
+that need a changes store without a database, and as a reference
+the SQL implementations can be compared with.
+
+It follows the behavior of the SQL implementations where it is visible
+through the interfaces: the records are validated when put, a changeset
+gets an empty header if its records are put without one, a header cannot
+be put twice, a usual triple cannot be put twice with the same record
+type in the same changeset (except as information record), and the pull sources give the records
+by changeset, in the order they were put (see MakeCSetRangePullSourceCloser).
+
+The *sql.Tx arguments of the push sink makers are ignored (nil is fine):
+the records put into a push sink made by MakeCRecPushSink are stored
+at once, those put into a push sink made by MakeCRecPushSinkEnder are
+stored when it ends (all or nothing, like a transaction).
+*/
+package cstoremem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// Store = in-memory changes store; it is also its own Data Operator.
+// Safe for concurrent use.
+type Store struct {
+	mu sync.Mutex
+
+	csets map[id.IntID]*storedSet
+
+	// Usual triples stored (not the information records),
+	// to reject duplicates like the SQL primary keys do
+	tripleKeys map[tripleKey]bool
+
+	// Next free internal ID (see MakeInternalIDGetCloser)
+	nextFreeID id.IntID
+
+	// External ID mapping (see MakeExternalIDGetCloser)
+	extIDs map[string]id.IntID
+}
+
+type storedSet struct {
+	info change.SetInfo
+	recs []change.Rec
+}
+
+type tripleKey struct {
+	csetID, subjectID, propID id.IntID
+	valueTypeID, objectID     id.IntID
+	langTag, stringVal        string
+	recType                   change.RecTypeCode
+}
+
+// Explicitly check that the store implements
+// the changes store interfaces.
+var (
+	_ cstore.ReadingDop = (*Store)(nil)
+	_ cstore.Dop        = (*Store)(nil)
+)
+
+// The first ID allocated in a new store: right after the reserved range
+// (as for the SQL implementations).
+const firstAllocatedID = id.MaxReservedID + 1
+
+// New returns a new, empty in-memory changes store.
+func New() *Store {
+	return &Store{
+		csets:      make(map[id.IntID]*storedSet),
+		tripleKeys: make(map[tripleKey]bool),
+		nextFreeID: firstAllocatedID,
+		extIDs:     make(map[string]id.IntID),
+	}
+}
+
+func (s *Store) Close() {
+	// Nothing to release; the store stays usable
+	// (as the database behind an SQL Data Operator).
+}
+
+func (s *Store) ReadCSetInfo(csetID id.IntID) (change.SetInfo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cset, found := s.csets[csetID]
+	if !found {
+		return change.SetInfo{}, false, nil
+	}
+	return copySetInfo(cset.info), true, nil
+}
+
+// copySetInfo returns a copy not sharing the slices, with
+// the creation time as stored by the SQL implementations
+// (Unix time in nanoseconds, read back as UTC).
+func copySetInfo(info change.SetInfo) change.SetInfo {
+	if !info.CreatedAt.IsZero() {
+		info.CreatedAt = time.Unix(0, info.CreatedAt.UnixNano()).UTC()
+	}
+	if info.ParentIDs != nil {
+		info.ParentIDs = append([]id.IntID(nil), info.ParentIDs...)
+	}
+	if info.Annotations != nil {
+		info.Annotations = append([]change.Annotation(nil), info.Annotations...)
+	}
+	return info
+}
@@ -0,0 +1,12 @@
+package cstoremem_test
+
+import (
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore/cstoretest"
+	"github.com/gimpldo/ba-prototype-go/cstoremem"
+)
+
+func TestConformance(t *testing.T) {
+	cstoretest.TestDop(t, cstoremem.New(), nil)
+}
@@ -0,0 +1,20 @@
+package cstorepg0_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore/cstoretest"
+	"github.com/gimpldo/ba-prototype-go/cstorepg0"
+)
+
+// Needs the test database (see testDSNEnv).
+func TestConformance(t *testing.T) {
+	db, schemaName := openTestDB(t)
+	ft := cstoretest.FactoryTest{
+		Factory:     cstorepg0.SQLDefFactory{},
+		NewDB:       func(*testing.T) *sql.DB { return db },
+		StorePrefix: schemaName + "." + cstoretest.DefaultStorePrefix,
+	}
+	ft.Run(t)
+}
@@ -0,0 +1,70 @@
+// cstorepg0/csetinfoimpl.go: changeset header (metadata) reading for PostgreSQL
+
+package cstorepg0
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+func (dop *cstorePGReadingDop) ReadCSetInfo(csetID id.IntID) (change.SetInfo, bool, error) {
+	info := change.SetInfo{ID: csetID}
+	data := sqlTemplateData{Prefix: dop.prefix}
+
+	var createdUnixNs int64
+	err := dop.db.QueryRow(generateSQL(tableCSetInfoSel, data), int64(csetID)).Scan(
+		&info.Author, &createdUnixNs, &info.Message)
+	switch {
+	case err == sql.ErrNoRows:
+		return change.SetInfo{}, false, nil
+	case err != nil:
+		return info, false, errors.Wrapf(err, "Failed to read header of changeset %d", csetID)
+	}
+	if createdUnixNs != 0 {
+		info.CreatedAt = time.Unix(0, createdUnixNs).UTC()
+	}
+
+	rows, err := dop.db.Query(generateSQL(tableCSetParentSel, data), int64(csetID))
+	if err != nil {
+		return info, false, errors.Wrapf(err, "Failed to query parents of changeset %d", csetID)
+	}
+	for rows.Next() {
+		var parentID int64
+		err = rows.Scan(&parentID)
+		if err != nil {
+			rows.Close()
+			return info, false, errors.Wrapf(err, "Failed to read parent of changeset %d", csetID)
+		}
+		info.ParentIDs = append(info.ParentIDs, id.IntID(parentID))
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return info, false, errors.Wrapf(err, "Failed reading parents of changeset %d", csetID)
+	}
+
+	rows, err = dop.db.Query(generateSQL(tableCSetAnnotSel, data), int64(csetID))
+	if err != nil {
+		return info, false, errors.Wrapf(err, "Failed to query annotations of changeset %d", csetID)
+	}
+	for rows.Next() {
+		var annot change.Annotation
+		err = rows.Scan(&annot.Key, &annot.Value)
+		if err != nil {
+			rows.Close()
+			return info, false, errors.Wrapf(err, "Failed to read annotation of changeset %d", csetID)
+		}
+		info.Annotations = append(info.Annotations, annot)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return info, false, errors.Wrapf(err, "Failed reading annotations of changeset %d", csetID)
+	}
+
+	return info, true, nil
+}
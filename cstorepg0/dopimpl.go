@@ -0,0 +1,45 @@
+// cstorepg0/dopimpl.go: changes store Data Operator Implementation
+// for PostgreSQL, v0 design and schema
+
+package cstorepg0
+
+import (
+	"database/sql"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+type (
+	cstorePGReadingDop struct {
+		db     *sql.DB
+		prefix string
+	}
+
+	cstorePGDop struct {
+		cstorePGReadingDop
+
+		// INSERT statement text for each table, indexed by Element Index
+		insertSQL [nTables]string
+	}
+)
+
+// Explicitly check that the Data Operator types implement
+// the changes store interfaces.
+var (
+	_ cstore.ReadingDop = (*cstorePGReadingDop)(nil)
+	_ cstore.Dop        = (*cstorePGDop)(nil)
+)
+
+func (dop *cstorePGReadingDop) Close() {
+	// Nothing to release: the *sql.DB belongs to the caller, and
+	// the objects made by this Data Operator have their own Close/End.
+}
+
+func (dop *cstorePGDop) MakeInternalIDGetCloser() (id.InternalIDGetCloser, error) {
+	return newIntIDGetter(dop.db, dop.prefix), nil
+}
+
+func (dop *cstorePGDop) MakeExternalIDGetCloser() (id.ExternalIDGetCloser, error) {
+	return newExtIDGetter(dop.db, dop.prefix), nil
+}
@@ -0,0 +1,20 @@
+// cstorepg0/migrations.go: schema migration steps for the PostgreSQL changes store
+
+package cstorepg0
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+// The schema migration steps, in version order; a new schema version
+// needs a new step here, after the last one (see cstore.RegisterMigrationSteps
+// and the SQLite implementation for an example).
+//
+// None yet: version 1 is the first schema of this implementation.
+//
+var migrationSteps = []sqlschema.MigrationStep{}
+
+func init() {
+	cstore.RegisterMigrationSteps(cstoreImplName, migrationSteps...)
+}
@@ -0,0 +1,139 @@
+// cstorepg0/pullsrcimpl.go: change record Pull Source Implementation
+// for PostgreSQL, v0 design and schema
+
+package cstorepg0
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// cstorePGPullSource implements change.RecPullSourceCloser.
+//
+// Streams the change records from the 'all_crec' view (a single query),
+// in changeset ID order; see 'viewAllCRecSel' for the order of records
+// inside a changeset.
+//
+type cstorePGPullSource struct {
+	rows *sql.Rows
+
+	closed bool
+}
+
+func (dop *cstorePGReadingDop) MakeCRecPullSourceCloser() (change.RecPullSourceCloser, error) {
+	return dop.MakeCSetRangePullSourceCloser(id.NoID+1, math.MaxInt64)
+}
+
+func (dop *cstorePGReadingDop) MakeCSetRangePullSourceCloser(firstCSetID, lastCSetID id.IntID) (change.RecPullSourceCloser, error) {
+	if firstCSetID > lastCSetID {
+		return nil, errors.Errorf("Bad changeset ID range: first %d > last %d",
+			firstCSetID, lastCSetID)
+	}
+
+	selectSQL := generateSQL(viewAllCRecSel, sqlTemplateData{Prefix: dop.prefix})
+
+	rows, err := dop.db.Query(selectSQL, int64(firstCSetID), int64(lastCSetID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to query change records for changesets %d..%d",
+			firstCSetID, lastCSetID)
+	}
+
+	return &cstorePGPullSource{rows: rows}, nil
+}
+
+func (src *cstorePGPullSource) GetNextChangeRec() (rec change.Rec, gotRec bool, err error) {
+	if src.closed {
+		return rec, false, errors.Errorf("Pull source already closed")
+	}
+
+	if !src.rows.Next() {
+		err = src.rows.Err()
+		if err != nil {
+			return rec, false, errors.Wrapf(err, "Failed reading change records")
+		}
+		return rec, false, nil
+	}
+
+	rec, err = scanAllCRecRow(src.rows)
+	return rec, err == nil, err
+}
+
+// scanAllCRecRow reads a row selected from the 'all_crec' view
+// (with the columns listed in 'viewAllCRecSel').
+func scanAllCRecRow(rows *sql.Rows) (change.Rec, error) {
+	var (
+		rec change.Rec
+
+		csetID, form                int64
+		subjectID, propID, objectID int64
+		posCN, oldPosCN             int64
+		crecType, crecFlags         int64
+		contextID, editOpCID        int64
+		valTypeID                   int64
+		binVal                      []byte
+	)
+
+	err := rows.Scan(&csetID, &form,
+		&subjectID, &propID, &posCN, &oldPosCN,
+		&crecType, &crecFlags, &contextID, &editOpCID,
+		&valTypeID, &objectID, &rec.LangTag, &rec.StringVal,
+		&binVal)
+	if err != nil {
+		return rec, errors.Wrapf(err, "Failed to read change record")
+	}
+
+	rec.Form = change.RecFormCode(form)
+	switch rec.Form {
+	case change.UsualTriple:
+		rec.Prop, err = id.CheckedFromID(id.IntID(propID))
+		if err != nil {
+			return rec, errors.Wrapf(err, "Bad property in changeset %d, subject %d",
+				csetID, subjectID)
+		}
+	case change.OrdContItem:
+		rec.Prop, err = id.PosOrID(posCN).Checked()
+		if err == nil {
+			rec.OldProp, err = id.PosOrID(oldPosCN).Checked()
+		}
+		if err != nil {
+			return rec, errors.Wrapf(err, "Bad position in changeset %d, subject %d",
+				csetID, subjectID)
+		}
+	case change.IDLitBin:
+		rec.Prop, err = id.PosOrID(posCN).Checked()
+		if err != nil {
+			return rec, errors.Wrapf(err, "Bad offset in changeset %d, subject %d",
+				csetID, subjectID)
+		}
+		rec.BinVal = binVal
+		if rec.BinVal == nil {
+			rec.BinVal = []byte{}
+		}
+	default:
+		return rec, errors.Errorf("Unexpected change record form %d in changeset %d",
+			form, csetID)
+	}
+
+	rec.ChangeSetID = id.IntID(csetID)
+	rec.SubjectID = id.IntID(subjectID)
+	rec.ObjectID = id.IntID(objectID)
+	rec.ValueTypeID = id.IntID(valTypeID)
+	rec.ChangeRecType = change.RecTypeCode(crecType)
+	rec.ChangeRecFlags = change.RecFlags(crecFlags)
+	rec.ChangeRecContextID = id.IntID(contextID)
+	rec.EditOpCID = id.IntID(editOpCID)
+
+	return rec, nil
+}
+
+func (src *cstorePGPullSource) Close() error {
+	if src.closed {
+		return nil
+	}
+	src.closed = true
+	return src.rows.Close()
+}
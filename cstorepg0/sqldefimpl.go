@@ -0,0 +1,507 @@
+// cstorepg0/sqldefimpl.go: changes store SQLDef Implementation for PostgreSQL,
+// v0 design and schema (same as 'cstoresqlite0', with PostgreSQL DDL)
+
+package cstorepg0
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoreconfsql"
+	"github.com/gimpldo/ba-prototype-go/geconf"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+	"github.com/gimpldo/ba-prototype-go/util/geconfsql"
+	"github.com/gimpldo/ba-prototype-go/util/pgschema"
+	"github.com/pkg/errors"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const cstoreImplName = "cstorepg0"
+
+// SQLDefFactory makes the definitions of changes stores in
+// a PostgreSQL database (driver "postgres", see 'github.com/lib/pq').
+//
+// The store prefix may be schema-qualified ("myschema.cst123_");
+// without schema name, the store is in the current schema
+// (the first one in the 'search_path' that exists).
+//
+type SQLDefFactory struct{}
+
+func init() {
+	cstore.Register(cstoreImplName, SQLDefFactory{})
+}
+
+type (
+	commonDef struct {
+		db     *sql.DB
+		prefix string
+
+		// From the prefix: schema name ("" for the current schema)
+		// and the local prefix (for the element names in the catalogs)
+		schemaName  string
+		localPrefix string
+
+		// The general/global and per-element configuration for the store,
+		// as it was retrieved from the database, or as it should be written
+		// (store being created in the current operation)
+		dbConf []geconf.Entry
+
+		elementDefs [nElements]sqlschema.ElementDef
+
+		// What the catalogs should say about each element,
+		// made from 'elementDefs' (see describeElement)
+		elementDescrs [nElements]elementDescr
+
+		report sqlschema.OpReport
+	}
+
+	cstorePGReadingDef struct {
+		commonDef
+	}
+
+	cstorePGDef struct {
+		commonDef
+
+		// "Store creation requested" flag
+		createStoreReq bool
+	}
+)
+
+// elementDescr = expected description of a schema element
+// in the PostgreSQL catalogs.
+type elementDescr struct {
+	// Tables only
+	columns []pgschema.Column
+
+	// Indexes only (table name without schema)
+	tableName string
+}
+
+func (SQLDefFactory) OpenSQLStoreReadOnly(db *sql.DB, storePrefix string) (cstore.ReadingSQLDef, error) {
+	confEntries, err := readCheckedConf(db, storePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := &cstorePGReadingDef{
+		commonDef: commonDef{db: db, prefix: storePrefix, dbConf: confEntries},
+	}
+
+	err = setupElements(&sd.commonDef)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+func (SQLDefFactory) OpenSQLStore(db *sql.DB, storePrefix string) (cstore.SQLDef, error) {
+	confEntries, err := readCheckedConf(db, storePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := &cstorePGDef{
+		commonDef: commonDef{db: db, prefix: storePrefix, dbConf: confEntries},
+
+		createStoreReq: false,
+	}
+
+	err = setupElements(&sd.commonDef)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+func (SQLDefFactory) CreateSQLStore(db *sql.DB, storePrefix, schemaCreationOptions string) (cstore.SQLDef, error) {
+	err := checkSafeNameChars(storePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConfEntries, err := cstoreconfsql.ReadConfFromDB(db, storePrefix)
+	n := len(dbConfEntries)
+	if err != nil { // failure to read CStore configuration; good news...
+		// but more checking is needed to avoid overwriting an old store:
+		if n != 0 {
+			return nil, errors.Wrapf(err,
+				"Store probably exists: read failed with partial result (%d conf entries)",
+				n)
+		}
+	} else { // CStore configuration read successfully; bad news for Create...
+		return nil, errors.Errorf(
+			"Store already exists (%d conf entries)",
+			n)
+	}
+
+	var creationConfList geconf.List
+	err = creationConfList.UnmarshalText([]byte(schemaCreationOptions))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range creationConfList {
+		if entry.ConfElement == "" && (entry.ConfProperty == cstoreconfsql.ImplNameProperty ||
+			entry.ConfProperty == cstoreconfsql.SchemaVersionProperty) {
+			return nil, errors.Errorf("Reserved property in schema creation options: %#v", entry)
+		}
+	}
+	creationConfList = append(creationConfList,
+		cstoreconfsql.SchemaVersionEntry(cstore.LatestSchemaVersion(cstoreImplName)))
+
+	sort.Sort(geconf.CanonicalOrder(creationConfList))
+
+	extendedConf := prependImplInfoToConf(creationConfList)
+
+	sd := &cstorePGDef{
+		commonDef: commonDef{db: db, prefix: storePrefix, dbConf: extendedConf},
+
+		createStoreReq: true,
+	}
+
+	err = setupElements(&sd.commonDef)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+func readCheckedConf(db *sql.DB, storePrefix string) ([]geconf.Entry, error) {
+	err := checkSafeNameChars(storePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	confEntries, err := cstoreconfsql.ReadConfFromDB(db, storePrefix)
+	if err != nil {
+		return nil, err
+	}
+	err = cstoreconfsql.CheckOrder(confEntries)
+	if err != nil {
+		return nil, err
+	}
+	err = cstoreconfsql.CheckImplName(confEntries, cstoreImplName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The store may need migration, but it must not be newer:
+	version, err := cstoreconfsql.SchemaVersion(confEntries)
+	if err != nil {
+		return nil, err
+	}
+	_, err = cstore.MigrationSteps(cstoreImplName, version)
+	if err != nil {
+		return nil, err
+	}
+	return confEntries, nil
+}
+
+func setupElements(c *commonDef) error {
+	// Validate the configuration by (re)generating its textual form,
+	// saved in the SQL schema operations report (see 'cstoresqlite0').
+	regeneratedConf, marshalingErr := geconf.List(c.dbConf).MarshalText()
+	if marshalingErr != nil {
+		return errors.Wrapf(marshalingErr, "Could not marshal DB conf")
+	}
+
+	c.report.Conf = string(regeneratedConf)
+	c.schemaName, c.localPrefix = splitPrefix(c.prefix)
+
+	generateDefsForAllElements(c.elementDefs[:], elementTemplates[:],
+		c.prefix, c.dbConf)
+
+	for i := range c.elementDefs {
+		c.elementDescrs[i] = describeElement(c.elementDefs[i])
+	}
+
+	sqlschema.InitOpReportElements(&c.report, c.elementDefs[:])
+
+	return nil
+}
+
+// describeElement makes the expected catalog description from
+// the generated CREATE statement: the column definitions of a table
+// (one per line, see the templates), the table of an index.
+func describeElement(elem sqlschema.ElementDef) elementDescr {
+	var descr elementDescr
+
+	switch elem.ElemType {
+	case sqlschema.TableElem:
+		lines := strings.Split(elem.CreateSQL, "\n")
+		for _, line := range lines[1:] {
+			line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(line, ")") ||
+				strings.HasPrefix(line, "PRIMARY KEY") ||
+				strings.HasPrefix(line, "UNIQUE") ||
+				strings.HasPrefix(line, "FOREIGN KEY") {
+				continue
+			}
+			descr.columns = append(descr.columns, pgschema.Column{
+				Name:     fields[0],
+				DataType: strings.ToLower(fields[1]),
+				NotNull: strings.Contains(line, "NOT NULL") ||
+					strings.Contains(line, "PRIMARY KEY"),
+			})
+		}
+	case sqlschema.IndexElem:
+		fields := strings.Fields(elem.CreateSQL)
+		for i := range fields[:len(fields)-1] {
+			if fields[i] == "ON" {
+				tableName := fields[i+1]
+				descr.tableName = tableName[strings.IndexByte(tableName, '.')+1:]
+				break
+			}
+		}
+	}
+	return descr
+}
+
+func writeConfToDB(c *commonDef, confEntries []geconf.Entry) error {
+	data := sqlTemplateData{Prefix: c.prefix}
+	insertSQL := generateSQL(insertSQLTemplates[tableCStoreConfEI], data)
+
+	return geconfsql.InsertEntriesIntoDB(c.db, insertSQL, confEntries)
+}
+
+func (sd *cstorePGDef) CreateCStoreSchemaElements() (sqlschema.OpReport, error) {
+	dbElementsFound, err := pgschema.ReadFromDB(sd.db, sd.schemaName, sd.localPrefix)
+	if err != nil {
+		// Unlike SQLite, reading the catalogs should work even if
+		// the store (or the schema) does not exist: nothing is found.
+		sd.report.LastOp = "check before create failed to get DB schema"
+		return sd.report, err
+	}
+	checkCStoreSchema(&sd.commonDef, dbElementsFound)
+
+	needWriteConf := false
+
+	confTableStatus := sd.report.Elements[tableCStoreConfEI].Status
+	switch confTableStatus {
+	case sqlschema.MissingES:
+		if !sd.createStoreReq {
+			return sd.report, errors.Errorf(
+				"Conf table missing")
+		}
+		needWriteConf = true
+	case sqlschema.MismatchedES:
+		return sd.report, errors.Errorf(
+			"Conf table mismatched")
+	case sqlschema.MatchedES:
+		if sd.createStoreReq {
+			return sd.report, errors.Errorf(
+				"This SQLDef instance was made by CreateSQLStore() but conf table exists in DB")
+		}
+	default:
+		panic(fmt.Sprintf(
+			"Unexpected schema element status code %x for conf table after check",
+			uint(confTableStatus)))
+	}
+
+	err = sqlschema.CreateElements(sd.db, &sd.report, sd.elementDefs[:], sqlschema.MissingES)
+	if err != nil {
+		return sd.report, err
+	}
+
+	if needWriteConf {
+		err = writeConfToDB(&sd.commonDef, sd.dbConf)
+	}
+
+	return sd.report, err
+}
+
+func (sd *cstorePGDef) DropCStoreSchemaElements() (sqlschema.OpReport, error) {
+	err := sqlschema.DropReportedElements(sd.db, &sd.report,
+		true /* tryAll: means try to drop all elements, don't stop at first failure */)
+	return sd.report, err
+}
+
+// MigrateCStoreSchema runs the migration steps from the store's
+// schema version (see migrations.go); the element statuses in
+// the report come from a check done before the migration.
+func (sd *cstorePGDef) MigrateCStoreSchema() (sqlschema.OpReport, error) {
+	version, err := cstoreconfsql.SchemaVersion(sd.dbConf)
+	if err != nil {
+		return sd.report, err
+	}
+	steps, err := cstore.MigrationSteps(cstoreImplName, version)
+	if err != nil {
+		return sd.report, err
+	}
+
+	_, err = readAndCheckCStoreSchema(&sd.commonDef)
+	if err != nil {
+		return sd.report, err
+	}
+
+	if len(steps) == 0 { // up to date
+		sd.report.NumCreated, sd.report.NumDropped, sd.report.NumFailed = 0, 0, 0
+		sd.report.LastOp = sqlschema.OpMigrate
+		sd.report.Steps = nil
+		return sd.report, nil
+	}
+
+	// PostgreSQL DDL is transactional: a failed step leaves nothing behind.
+	tx, err := sd.db.Begin()
+	if err != nil {
+		return sd.report, errors.Wrapf(err, "Failed to begin schema migration")
+	}
+
+	newVersion := version + len(steps)
+	err = sqlschema.MigrateElements(tx, &sd.report, sd.elementDefs[:], steps)
+	if err == nil {
+		err = cstoreconfsql.WriteSchemaVersion(tx, sd.prefix, newVersion)
+	}
+	if err != nil {
+		tx.Rollback()
+		return sd.report, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return sd.report, errors.Wrapf(err, "Failed to commit schema migration to version %d",
+			newVersion)
+	}
+
+	sd.dbConf = cstoreconfsql.SetSchemaVersion(sd.dbConf, newVersion)
+	regeneratedConf, _ := geconf.List(sd.dbConf).MarshalText()
+	sd.report.Conf = string(regeneratedConf)
+
+	return sd.report, nil
+}
+
+func (sd *cstorePGReadingDef) CheckCStoreSchema() (sqlschema.OpReport, error) {
+	return readAndCheckCStoreSchema(&sd.commonDef)
+}
+
+func (sd *cstorePGDef) CheckCStoreSchema() (sqlschema.OpReport, error) {
+	return readAndCheckCStoreSchema(&sd.commonDef)
+}
+
+func readAndCheckCStoreSchema(c *commonDef) (sqlschema.OpReport, error) {
+	dbElementsFound, err := pgschema.ReadFromDB(c.db, c.schemaName, c.localPrefix)
+	if err != nil {
+		c.report.LastOp = sqlschema.OpCheckFailDB
+		return c.report, err
+	}
+
+	checkCStoreSchema(c, dbElementsFound)
+	return c.report, nil
+}
+
+// checkCStoreSchema compares the elements found in the catalogs with
+// the expected ones: type, columns (name, type, NOT NULL) for tables,
+// table for indexes; views are only checked for existence and type
+// (their definition is normalized by PostgreSQL, see 'pgschema').
+func checkCStoreSchema(c *commonDef, dbElementsFound []pgschema.ElementFound) {
+	report := &c.report
+
+	dbNamesFound := make(map[string]*pgschema.ElementFound)
+	for i := range dbElementsFound {
+		dbNamesFound[dbElementsFound[i].Name] = &dbElementsFound[i]
+	}
+
+	report.NumFound = 0
+	report.NumMissing = 0
+	report.NumMatched = 0
+	report.NumMismatched = 0
+
+	for i := range report.Elements {
+		statusRec := &report.Elements[i]
+		elem := c.elementDefs[i]
+
+		if statusRec.Name != elem.Name {
+			panic(fmt.Sprintf("different Name at %d", i))
+		}
+
+		dbElemFound, ok := dbNamesFound[c.localPrefix+elem.BaseName]
+		if !ok {
+			statusRec.Status = sqlschema.MissingES
+			report.NumMissing++
+			continue
+		}
+
+		statusRec.Status = sqlschema.FoundES
+		report.NumFound++
+
+		problem := ""
+		switch {
+		case dbElemFound.ElemType != elem.ElemType:
+			problem = fmt.Sprintf("type %d in DB != %d in def",
+				dbElemFound.ElemType, elem.ElemType)
+		case elem.ElemType == sqlschema.TableElem:
+			foundText := describeColumns(dbElemFound.Columns)
+			expectedText := describeColumns(c.elementDescrs[i].columns)
+			if foundText != expectedText {
+				problem = diff(foundText, expectedText)
+			}
+		case elem.ElemType == sqlschema.IndexElem:
+			if dbElemFound.TableName != c.elementDescrs[i].tableName {
+				problem = fmt.Sprintf("index on %q in DB != %q in def",
+					dbElemFound.TableName, c.elementDescrs[i].tableName)
+			}
+		}
+
+		if problem == "" {
+			statusRec.Status = sqlschema.MatchedES
+			report.NumMatched++
+		} else {
+			statusRec.Status = sqlschema.MismatchedES
+			statusRec.ProblemDetail = problem
+			report.NumMismatched++
+		}
+	}
+
+	report.LastOp = sqlschema.OpCheck
+}
+
+func describeColumns(columns []pgschema.Column) string {
+	descrs := make([]string, len(columns))
+	for i, col := range columns {
+		descrs[i] = col.String()
+	}
+	return strings.Join(descrs, ",\n")
+}
+
+func (sd *cstorePGReadingDef) UseCStoreReadOnly() (cstore.ReadingDop, error) {
+	dop := &cstorePGReadingDop{db: sd.db, prefix: sd.prefix}
+	return dop, nil
+}
+
+func (sd *cstorePGDef) UseCStoreReadOnly() (cstore.ReadingDop, error) {
+	dop := &cstorePGReadingDop{db: sd.db, prefix: sd.prefix}
+	return dop, nil
+}
+
+func (sd *cstorePGDef) UseCStore() (cstore.Dop, error) {
+	dop := &cstorePGDop{
+		cstorePGReadingDop: cstorePGReadingDop{db: sd.db, prefix: sd.prefix},
+	}
+	generateInsertSQLForAllTables(dop.insertSQL[:], insertSQLTemplates[:], sd.prefix)
+	return dop, nil
+}
+
+func prependImplInfoToConf(confEntries []geconf.Entry) []geconf.Entry {
+	cstoreImplNameEntry := geconf.Entry{
+		ConfProperty: cstoreconfsql.ImplNameProperty,
+		ConfValue:    cstoreImplName,
+	}
+
+	extendedConf := make([]geconf.Entry, 0, len(confEntries)+1)
+	extendedConf = append(extendedConf, cstoreImplNameEntry)
+	extendedConf = append(extendedConf, confEntries...)
+
+	return extendedConf
+}
+
+func diff(text1st, text2nd string) string {
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(text1st, text2nd, false)
+	return fmt.Sprintf("diffs=%v", diffs)
+}
@@ -0,0 +1,190 @@
+package cstorepg0
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/geconf"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+func generateDefsForAllElements(
+	generated []sqlschema.ElementDef,
+	templates []sqlschema.ElementTemplate,
+	prefix string,
+	confEntries []geconf.Entry) {
+
+	err := checkSafeNameChars(prefix)
+	if err != nil {
+		panic(err)
+	}
+	_, localPrefix := splitPrefix(prefix)
+
+	matchableEntries := organizeConfEntries(confEntries)
+
+	csetTableName := prefix + templates[tableCSetInfoEI].BaseName
+
+	for i := range generated {
+		var data sqlTemplateData
+
+		baseName := templates[i].BaseName
+
+		setSpecificSQLTemplateData(&data, matchableEntries, baseName)
+
+		if data.IDTableName != "" {
+			data.ReferencesIDTable = " REFERENCES " + data.IDTableName
+		}
+		data.ReferencesChangeSetTable = " REFERENCES " + csetTableName + " (cset_id)"
+
+		data.Prefix = prefix
+		data.LocalPrefix = localPrefix
+
+		data.FormUsualTriple = int(change.UsualTriple)
+		data.FormOrdContItem = int(change.OrdContItem)
+		data.FormIDLitBin = int(change.IDLitBin)
+		data.LangStringID = int64(id.RDFLangStringID)
+
+		generated[i].CreateSQL = generateSQL(templates[i].CreateSQL, data)
+		generated[i].ElemType = templates[i].ElemType
+		generated[i].BaseName = baseName
+		generated[i].Name = prefix + baseName
+	}
+}
+
+func generateInsertSQLForAllTables(generated, templates []string, prefix string) {
+	err := checkSafeNameChars(prefix)
+	if err != nil {
+		panic(err)
+	}
+	data := sqlTemplateData{Prefix: prefix}
+
+	for i := range generated {
+		generated[i] = generateSQL(templates[i], data)
+	}
+}
+
+func generateSQL(sqlTemplate string, data sqlTemplateData) string {
+	t := template.Must(template.New("").Parse(sqlTemplate))
+
+	var buf bytes.Buffer
+	t.Execute(&buf, data)
+
+	return string(bytes.TrimSpace(buf.Bytes()))
+}
+
+func setSpecificSQLTemplateData(dest *sqlTemplateData, confEntries []geconf.Entry, elementName string) {
+	for _, entry := range confEntries {
+		nBytes := len(entry.ConfElement)
+
+		if entry.ConfElement[nBytes-1] == '*' {
+			if strings.HasPrefix(elementName, entry.ConfElement[:nBytes-1]) {
+				setSQLTemplateDataVal(dest, entry)
+			}
+		} else {
+			if elementName == entry.ConfElement {
+				setSQLTemplateDataVal(dest, entry)
+			}
+		}
+	}
+}
+
+func setSQLTemplateDataVal(dest *sqlTemplateData, confEntry geconf.Entry) {
+	switch confEntry.ConfProperty {
+	case "IDTable":
+		dest.IDTableName = confEntry.ConfValue
+	case "RefCSet", "IOTL1", "IOTL2":
+		// Accepted (same creation options as for 'cstoresqlite0')
+		// but without effect: the 'cset_id' columns always refer to
+		// the changeset table, and there are no index-organized tables.
+	default:
+		log.Printf("Unexpected property in %#v", confEntry)
+	}
+}
+
+func organizeConfEntries(confEntries []geconf.Entry) []geconf.Entry {
+	for i := range confEntries {
+		rankByElementPrefixLength(&confEntries[i])
+	}
+
+	sort.Sort(geconf.RankOrder(confEntries))
+
+	// If no entry has a non-negative rank, all are skipped:
+	iSkip := len(confEntries)
+	for i := range confEntries {
+		if confEntries[i].Rank >= 0 {
+			iSkip = i
+			break
+		}
+	}
+	return confEntries[iSkip:]
+}
+
+func rankByElementPrefixLength(confEntry *geconf.Entry) {
+	// Arbitrary limit for configuration element string length in bytes,
+	// should be several orders of magnitude smaller than 'math.MaxInt32'.
+	const maxBytes = 100
+
+	nBytes := len(confEntry.ConfElement)
+	if nBytes > maxBytes {
+		// Allow releasing memory in case it was a huge string:
+		confEntry.ConfElement = ""
+
+		confEntry.Rank = -99
+		return
+	}
+	if nBytes == 0 {
+		confEntry.Rank = -2
+		return
+	}
+
+	starPos := strings.IndexByte(confEntry.ConfElement, '*')
+	if starPos >= 0 { // wildcard (asterisk) found; need to check where:
+		if starPos == nBytes-1 { // looks like a well-formed prefix:
+			confEntry.Rank = int32(starPos)
+		} else { // not a prefix; we only support asterisk at the end:
+			confEntry.Rank = -5
+		}
+	} else { // no wildcard (asterisk); sort all such entries after prefixes:
+		confEntry.Rank = math.MaxInt32
+	}
+}
+
+// checkSafeNameChars uses a very strict (whitelist) approach to validation,
+// like 'cstoresqlite0', but accepts at most one dot, after a schema name:
+// "myschema.cst123_" or "myschema." (but not ".cst123_").
+//
+func checkSafeNameChars(nameFragment string) error {
+	nDots := 0
+	for i, ch := range nameFragment {
+		switch {
+		case 'a' <= ch && ch <= 'z':
+		case '0' <= ch && ch <= '9':
+		case ch == '_':
+		case ch == '.':
+			nDots++
+			if nDots > 1 || i == 0 {
+				return fmt.Errorf("Name fragment is not safe: misplaced dot at %d.", i)
+			}
+		default:
+			return fmt.Errorf("Name fragment is not safe: %x at %d.", ch, i)
+		}
+	}
+	return nil
+}
+
+// splitPrefix returns the schema name ("" if none: the current schema)
+// and the local prefix (maybe empty) from a safe prefix.
+func splitPrefix(prefix string) (schemaName, localPrefix string) {
+	dotPos := strings.IndexByte(prefix, '.')
+	if dotPos < 0 {
+		return "", prefix
+	}
+	return prefix[:dotPos], prefix[dotPos+1:]
+}
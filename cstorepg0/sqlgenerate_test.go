@@ -0,0 +1,77 @@
+package cstorepg0
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+func TestSplitPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, schemaName, localPrefix string
+		safe                            bool
+	}{
+		{"cst_", "", "cst_", true},
+		{"", "", "", true},
+		{"myschema.cst_", "myschema", "cst_", true},
+		{"myschema.", "myschema", "", true},
+		{".cst_", "", "", false},
+		{"a.b.c_", "", "", false},
+		{"Cst_", "", "", false},
+		{"cst-", "", "", false},
+		{"cst_; DROP TABLE x", "", "", false},
+	}
+	for _, tt := range tests {
+		err := checkSafeNameChars(tt.prefix)
+		if (err == nil) != tt.safe {
+			t.Errorf("checkSafeNameChars(%q) returned %v", tt.prefix, err)
+		}
+		if !tt.safe {
+			continue
+		}
+		schemaName, localPrefix := splitPrefix(tt.prefix)
+		if schemaName != tt.schemaName || localPrefix != tt.localPrefix {
+			t.Errorf("splitPrefix(%q) = %q, %q; want %q, %q",
+				tt.prefix, schemaName, localPrefix, tt.schemaName, tt.localPrefix)
+		}
+	}
+}
+
+// With a schema-qualified prefix, the tables and views are made in
+// the schema, the indexes are named with the local prefix only (they are
+// always in the schema of their table), and the 'cset_id' columns refer
+// to the changeset table of the same store.
+func TestGenerateSchemaQualified(t *testing.T) {
+	var defs [nElements]sqlschema.ElementDef
+	generateDefsForAllElements(defs[:], elementTemplates[:], "myschema.cst_", nil)
+
+	for i, def := range defs {
+		if def.Name != "myschema.cst_"+elementTemplates[i].BaseName {
+			t.Errorf("Element %d named %q", i, def.Name)
+		}
+		var want string
+		switch def.ElemType {
+		case sqlschema.IndexElem:
+			want = "CREATE INDEX cst_" + def.BaseName + "\n  ON myschema.cst_"
+		case sqlschema.ViewElem:
+			want = "CREATE VIEW " + def.Name + " AS"
+		default:
+			want = "CREATE TABLE " + def.Name + " ("
+		}
+		if !strings.HasPrefix(def.CreateSQL, want) {
+			t.Errorf("Element %q created by:\n%s\nwant start %q", def.Name, def.CreateSQL, want)
+		}
+		if strings.Contains(def.CreateSQL, "\n  cset_id BIGINT NOT NULL,") {
+			t.Errorf("Element %q without reference to the changeset table:\n%s", def.Name, def.CreateSQL)
+		}
+		if strings.Contains(def.CreateSQL, "{{") || strings.Contains(def.CreateSQL, "<no value>") {
+			t.Errorf("Element %q created by incomplete SQL:\n%s", def.Name, def.CreateSQL)
+		}
+	}
+
+	ordCont := defs[tableCRecOrdContEI].CreateSQL
+	if !strings.Contains(ordCont, "cset_id BIGINT NOT NULL REFERENCES myschema.cst_cset_info (cset_id),") {
+		t.Errorf("Container item table without reference to the changeset table:\n%s", ordCont)
+	}
+}
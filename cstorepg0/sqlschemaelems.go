@@ -0,0 +1,119 @@
+package cstorepg0
+
+import (
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+// Element Index constants, local identifiers for the schema elements
+// (see 'cstoresqlite0/sqlschemaelems.go' for the explanations);
+// the trailing 'EI' stands for "Element Index".
+//
+// The order is the creation order (taking references / dependencies
+// into account): tables first (the changeset table before the tables
+// referring to it), then the indexes, then the view.
+// Dropping goes in reverse order.
+//
+const (
+	tableCStoreConfEI = iota
+	tableIDAllocEI
+	tableExtIDEI
+	tableCSetInfoEI
+	tableCSetParentEI
+	tableCSetAnnotEI
+	tableCRecIDObjEI
+	tableCRecLangStringEI
+	tableCRecLitDatatypeEI
+	tableCRecOrdContEI
+	tableCRecIDLitBinEI
+	tableCRecInfoEI
+	indexCRecOrdContEditOpEI
+	indexCRecIDObjContextEI
+	indexCRecInfoContextEI
+	viewAllCRecEI
+	nElements // must be last ConstSpec in the const block
+)
+
+// The number of tables (they come first, see above)
+const nTables = tableCRecInfoEI + 1
+
+var elementTemplates = [nElements]sqlschema.ElementTemplate{
+	tableCStoreConfEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCStoreConfBN,
+		CreateSQL: tableCStoreConfCre,
+	},
+	tableIDAllocEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableIDAllocBN,
+		CreateSQL: tableIDAllocCre,
+	},
+	tableExtIDEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableExtIDBN,
+		CreateSQL: tableExtIDCre,
+	},
+	tableCSetInfoEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCSetInfoBN,
+		CreateSQL: tableCSetInfoCre,
+	},
+	tableCSetParentEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCSetParentBN,
+		CreateSQL: tableCSetParentCre,
+	},
+	tableCSetAnnotEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCSetAnnotBN,
+		CreateSQL: tableCSetAnnotCre,
+	},
+	tableCRecIDObjEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecIDObjBN,
+		CreateSQL: tableCRecIDObjCre,
+	},
+	tableCRecLangStringEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecLangStringBN,
+		CreateSQL: tableCRecLangStringCre,
+	},
+	tableCRecLitDatatypeEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecLitDatatypeBN,
+		CreateSQL: tableCRecLitDatatypeCre,
+	},
+	tableCRecOrdContEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecOrdContBN,
+		CreateSQL: tableCRecOrdContCre,
+	},
+	tableCRecIDLitBinEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecIDLitBinBN,
+		CreateSQL: tableCRecIDLitBinCre,
+	},
+	tableCRecInfoEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecInfoBN,
+		CreateSQL: tableCRecInfoCre,
+	},
+	indexCRecOrdContEditOpEI: {ElemType: sqlschema.IndexElem,
+		BaseName:  indexCRecOrdContEditOpBN,
+		CreateSQL: indexCRecOrdContEditOpCre,
+	},
+	indexCRecIDObjContextEI: {ElemType: sqlschema.IndexElem,
+		BaseName:  indexCRecIDObjContextBN,
+		CreateSQL: indexCRecIDObjContextCre,
+	},
+	indexCRecInfoContextEI: {ElemType: sqlschema.IndexElem,
+		BaseName:  indexCRecInfoContextBN,
+		CreateSQL: indexCRecInfoContextCre,
+	},
+	viewAllCRecEI: {ElemType: sqlschema.ViewElem,
+		BaseName:  viewAllCRecBN,
+		CreateSQL: viewAllCRecCre,
+	},
+}
+
+var insertSQLTemplates = [nTables]string{
+	tableCStoreConfEI:      tableCStoreConfIns,
+	tableIDAllocEI:         tableIDAllocIns,
+	tableExtIDEI:           tableExtIDIns,
+	tableCSetInfoEI:        tableCSetInfoIns,
+	tableCSetParentEI:      tableCSetParentIns,
+	tableCSetAnnotEI:       tableCSetAnnotIns,
+	tableCRecIDObjEI:       tableCRecIDObjIns,
+	tableCRecLangStringEI:  tableCRecLangStringIns,
+	tableCRecLitDatatypeEI: tableCRecLitDatatypeIns,
+	tableCRecOrdContEI:     tableCRecOrdContIns,
+	tableCRecIDLitBinEI:    tableCRecIDLitBinIns,
+	tableCRecInfoEI:        tableCRecInfoIns,
+}
@@ -0,0 +1,446 @@
+package cstorepg0
+
+// The trailing 'BN' stands for "Base Name"
+// The trailing 'Cre' stands for "Create" (SQL DDL statement)
+// The trailing 'Ins' stands for "Insert" (SQL DML statement)
+// The trailing 'Sel...' stands for "Select" (SQL DML statement)
+// 'crec' stands for "Change Record"
+// 'cset' stands for "Change Set" (usually written as a single word: changeset)
+//
+// The design (tables, columns and their meaning) is the same as
+// in 'cstoresqlite0'; see its 'sqltemplates.go' for the explanations.
+// Only the PostgreSQL specifics are described here.
+
+/*
+The SQL DDL and DML templates defined below are intended to be used
+with the standard Go package 'text/template'.
+
+Arguments for the SQL templates defined below:
+
+.Prefix: string
+
+Prefix for schema element names (tables, views), optionally
+schema-qualified: the schema name followed by dot, then the local prefix.
+    Examples: "cst123_", "myschema.cst123_", "myschema."
+The schema must exist (it is not created with the store).
+
+.LocalPrefix: string
+
+The prefix without the schema name (and dot), for index names:
+PostgreSQL always creates an index in the schema of its table, and
+the index name given in "CREATE INDEX" must not be schema-qualified.
+
+.ReferencesIDTable: string
+
+Could be the empty string (no "REFERENCES" clause), or
+valid syntax for the clause, including the desired ID table's name.
+    Example: " REFERENCES " followed by the ID table name
+
+.ReferencesChangeSetTable: string
+
+The "REFERENCES" clause for the 'cset_id' columns, always referring to
+the main changeset table ('cset_info'): the push sink writes a header row
+(maybe empty) before the records of a changeset, so the foreign keys
+hold at any time, and PostgreSQL checks them without extra options.
+
+.FormUsualTriple, .FormOrdContItem, .FormIDLitBin, .LangStringID: integers
+
+Fixed values (not configurable, always set by the generator), as for
+'cstoresqlite0': used by the view definition.
+
+IDs (and packed id.PosOrID positions) are 'BIGINT' (id.IntID is int64);
+codes and flags fit in 'INTEGER'.
+PostgreSQL has no index-organized tables: the 'IOTL1' and 'IOTL2'
+configuration properties are accepted but have no effect.
+
+*/
+type sqlTemplateData struct {
+	Prefix      string
+	LocalPrefix string
+
+	IDTableName       string
+	ReferencesIDTable string
+
+	ReferencesChangeSetTable string
+
+	// Fixed values (not configurable) used in the view definition
+	FormUsualTriple int
+	FormOrdContItem int
+	FormIDLitBin    int
+	LangStringID    int64
+}
+
+// The head table: by checking it we can say whether we got a valid CStore;
+// it contains the definition options used when the CStore was created.
+const tableCStoreConfBN = "cstore_conf"
+const tableCStoreConfCre = `CREATE TABLE {{.Prefix}}cstore_conf (
+  cstore_element TEXT NOT NULL,
+  cstore_property TEXT NOT NULL,
+  cstore_value TEXT NOT NULL,
+  PRIMARY KEY (cstore_element, cstore_property)
+)
+`
+const tableCStoreConfIns = `INSERT INTO {{.Prefix}}cstore_conf (
+  cstore_element, cstore_property, cstore_value
+) VALUES ($1, $2, $3)
+`
+
+// ID allocation table: the next free internal ID, for each named
+// allocation sequence (only 'main' for now).
+//
+// A block of IDs is reserved with a single statement
+// (the sequence row is created by the first reservation), so
+// no explicit transaction is needed: see 'tableIDAllocReserve'.
+//
+const tableIDAllocBN = "id_alloc"
+const tableIDAllocCre = `CREATE TABLE {{.Prefix}}id_alloc (
+  alloc_seq_name TEXT NOT NULL,
+  next_free_id BIGINT NOT NULL,
+  PRIMARY KEY (alloc_seq_name)
+)
+`
+const tableIDAllocIns = `INSERT INTO {{.Prefix}}id_alloc (
+  alloc_seq_name, next_free_id
+) VALUES ($1, $2)
+`
+
+// Arguments: sequence name, the next free ID after the first block
+// (used if the row does not exist yet), block size.
+// Returns the next free ID after the block reserved.
+const tableIDAllocReserve = `INSERT INTO {{.Prefix}}id_alloc AS alloc (
+  alloc_seq_name, next_free_id
+) VALUES ($1, $2)
+ON CONFLICT (alloc_seq_name) DO UPDATE
+  SET next_free_id = alloc.next_free_id + $3
+RETURNING next_free_id
+`
+
+// External ID table: maps external identifiers (IRIs) to internal IDs.
+const tableExtIDBN = "ext_id"
+const tableExtIDCre = `CREATE TABLE {{.Prefix}}ext_id (
+  ext_iri TEXT NOT NULL,
+  int_id BIGINT NOT NULL UNIQUE{{.ReferencesIDTable}},
+  PRIMARY KEY (ext_iri)
+)
+`
+const tableExtIDIns = `INSERT INTO {{.Prefix}}ext_id (
+  ext_iri, int_id
+) VALUES ($1, $2)
+ON CONFLICT (ext_iri) DO NOTHING
+`
+const tableExtIDSel = `SELECT int_id FROM {{.Prefix}}ext_id
+  WHERE ext_iri = $1
+`
+
+// The main changeset table (the "ChangeSet ID Table"
+// referred by all the other changeset and change record tables).
+const tableCSetInfoBN = "cset_info"
+const tableCSetInfoCre = `CREATE TABLE {{.Prefix}}cset_info (
+  cset_id BIGINT PRIMARY KEY NOT NULL{{.ReferencesIDTable}},
+  author TEXT NOT NULL,
+  created_unix_ns BIGINT NOT NULL,
+  message TEXT NOT NULL
+)
+`
+const tableCSetInfoIns = `INSERT INTO {{.Prefix}}cset_info (
+  cset_id, author, created_unix_ns, message
+) VALUES ($1, $2, $3, $4)
+`
+const tableCSetInfoInsEmpty = `INSERT INTO {{.Prefix}}cset_info (
+  cset_id, author, created_unix_ns, message
+) VALUES ($1, '', 0, '')
+ON CONFLICT (cset_id) DO NOTHING
+`
+const tableCSetInfoSel = `SELECT
+  author, created_unix_ns, message
+FROM {{.Prefix}}cset_info
+  WHERE cset_id = $1
+`
+
+// Changeset parents; a parent need not be in the same store
+// (a store may hold only a range of changesets), so 'parent_cset_id'
+// does not refer to the changeset table.
+const tableCSetParentBN = "cset_parent"
+const tableCSetParentCre = `CREATE TABLE {{.Prefix}}cset_parent (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  parent_seq INTEGER NOT NULL,
+  parent_cset_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  PRIMARY KEY (cset_id, parent_seq)
+)
+`
+const tableCSetParentIns = `INSERT INTO {{.Prefix}}cset_parent (
+  cset_id, parent_seq, parent_cset_id
+) VALUES ($1, $2, $3)
+`
+const tableCSetParentSel = `SELECT parent_cset_id
+FROM {{.Prefix}}cset_parent
+  WHERE cset_id = $1
+  ORDER BY parent_seq
+`
+
+// Changeset annotations: arbitrary key/value pairs.
+const tableCSetAnnotBN = "cset_annot"
+const tableCSetAnnotCre = `CREATE TABLE {{.Prefix}}cset_annot (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  annot_seq INTEGER NOT NULL,
+  annot_key TEXT NOT NULL,
+  annot_value TEXT NOT NULL,
+  PRIMARY KEY (cset_id, annot_seq)
+)
+`
+const tableCSetAnnotIns = `INSERT INTO {{.Prefix}}cset_annot (
+  cset_id, annot_seq, annot_key, annot_value
+) VALUES ($1, $2, $3, $4)
+`
+const tableCSetAnnotSel = `SELECT annot_key, annot_value
+FROM {{.Prefix}}cset_annot
+  WHERE cset_id = $1
+  ORDER BY annot_seq
+`
+
+// Table for change records with object specified as ID;
+// as in 'cstoresqlite0', the record type is part of the primary key
+// (here and in the other triple tables).
+const tableCRecIDObjBN = "crec_idobj"
+const tableCRecIDObjCre = `CREATE TABLE {{.Prefix}}crec_idobj (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  prop_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  object_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  crec_seq BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  PRIMARY KEY (cset_id, subject_id, prop_id, object_id, crec_type)
+)
+`
+const tableCRecIDObjIns = `INSERT INTO {{.Prefix}}crec_idobj (
+  cset_id, subject_id, prop_id, object_id, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+// Table for change records with value = Language-tagged String
+// (class 'rdf:langString').
+const tableCRecLangStringBN = "crec_langstring"
+const tableCRecLangStringCre = `CREATE TABLE {{.Prefix}}crec_langstring (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  prop_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  crec_seq BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  PRIMARY KEY (cset_id, subject_id, prop_id, lang_tag, string_val, crec_type)
+)
+`
+const tableCRecLangStringIns = `INSERT INTO {{.Prefix}}crec_langstring (
+  cset_id, subject_id, prop_id, lang_tag, string_val, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+// Table for change records with value = Literal with Datatype,
+// other than Language-tagged String.
+const tableCRecLitDatatypeBN = "crec_litdatatype"
+const tableCRecLitDatatypeCre = `CREATE TABLE {{.Prefix}}crec_litdatatype (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  prop_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  val_datatype_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  string_val TEXT NOT NULL,
+  crec_seq BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  PRIMARY KEY (cset_id, subject_id, prop_id, val_datatype_id, string_val, crec_type)
+)
+`
+const tableCRecLitDatatypeIns = `INSERT INTO {{.Prefix}}crec_litdatatype (
+  cset_id, subject_id, prop_id, val_datatype_id, string_val, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+// Table for Order-preserving Container items
+// (the trailing '_cn' in field names stands for "Checked Number").
+const tableCRecOrdContBN = "crec_ordcont"
+const tableCRecOrdContCre = `CREATE TABLE {{.Prefix}}crec_ordcont (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  crec_seq BIGINT NOT NULL,
+  pos_cn BIGINT NOT NULL,
+  old_pos_cn BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  val_type_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  item_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, crec_seq)
+)
+`
+const tableCRecOrdContIns = `INSERT INTO {{.Prefix}}crec_ordcont (
+  cset_id, subject_id, crec_seq, pos_cn, old_pos_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  val_type_id, item_id,
+  lang_tag, string_val
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+`
+
+// Table for change records for IDentified Literal nodes containing Binary data.
+const tableCRecIDLitBinBN = "crec_id_lit_bin"
+const tableCRecIDLitBinCre = `CREATE TABLE {{.Prefix}}crec_id_lit_bin (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  crec_seq BIGINT NOT NULL,
+  offset_cn BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  bin_val BYTEA NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, crec_seq)
+)
+`
+const tableCRecIDLitBinIns = `INSERT INTO {{.Prefix}}crec_id_lit_bin (
+  cset_id, subject_id, crec_seq, offset_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  bin_val
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+// Table for the information records: types 'ContextRT', 'IdentRT'
+// and 'MetaRT' (see change.IsInfoRecType), of any form.
+const tableCRecInfoBN = "crec_info"
+const tableCRecInfoCre = `CREATE TABLE {{.Prefix}}crec_info (
+  cset_id BIGINT NOT NULL{{.ReferencesChangeSetTable}},
+  crec_seq BIGINT NOT NULL,
+  crec_form INTEGER NOT NULL,
+  subject_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  prop_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  pos_cn BIGINT NOT NULL,
+  old_pos_cn BIGINT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  edit_op_cid BIGINT NOT NULL{{.ReferencesIDTable}},
+  val_type_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  object_id BIGINT NOT NULL{{.ReferencesIDTable}},
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  bin_val BYTEA NOT NULL,
+  PRIMARY KEY (cset_id, crec_seq)
+)
+`
+const tableCRecInfoIns = `INSERT INTO {{.Prefix}}crec_info (
+  cset_id, crec_seq, crec_form,
+  subject_id, prop_id, pos_cn, old_pos_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  val_type_id, object_id, lang_tag, string_val,
+  bin_val
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+`
+
+// Partial indexes: only the rows with a value worth searching for
+// (most records have no editing operation ID and no context),
+// so the indexes stay small.
+//
+// Editing operations: the records of a move or swap in a container
+// (see change.GroupByEditOp).
+const indexCRecOrdContEditOpBN = "crec_ordcont_edit_op"
+const indexCRecOrdContEditOpCre = `CREATE INDEX {{.LocalPrefix}}crec_ordcont_edit_op
+  ON {{.Prefix}}crec_ordcont (cset_id, edit_op_cid)
+  WHERE edit_op_cid <> 0
+`
+
+// Records in a context, by context: the triples...
+const indexCRecIDObjContextBN = "crec_idobj_context"
+const indexCRecIDObjContextCre = `CREATE INDEX {{.LocalPrefix}}crec_idobj_context
+  ON {{.Prefix}}crec_idobj (crec_context_id, cset_id)
+  WHERE crec_context_id <> 0
+`
+
+// ... and the information records (including those describing a context).
+const indexCRecInfoContextBN = "crec_info_context"
+const indexCRecInfoContextCre = `CREATE INDEX {{.LocalPrefix}}crec_info_context
+  ON {{.Prefix}}crec_info (crec_context_id, cset_id)
+  WHERE crec_context_id <> 0
+`
+
+// View with all the change records, as a uniform projection
+// (same columns and meaning as the 'all_crec' view in 'cstoresqlite0').
+// The constants in the first branch are cast so the column types
+// are the same as those of the tables.
+const viewAllCRecBN = "all_crec"
+const viewAllCRecCre = `CREATE VIEW {{.Prefix}}all_crec AS
+    SELECT cset_id, {{.FormUsualTriple}} AS crec_form, crec_seq,
+      subject_id, prop_id, CAST(0 AS BIGINT) AS pos_cn, CAST(0 AS BIGINT) AS old_pos_cn,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      CAST(0 AS BIGINT) AS val_type_id, object_id,
+      CAST('' AS TEXT) AS lang_tag, CAST('' AS TEXT) AS string_val,
+      CAST('' AS BYTEA) AS bin_val
+    FROM {{.Prefix}}crec_idobj
+  UNION ALL
+    SELECT cset_id, {{.FormUsualTriple}}, crec_seq,
+      subject_id, prop_id, 0, 0,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      {{.LangStringID}}, 0, lang_tag, string_val,
+      ''
+    FROM {{.Prefix}}crec_langstring
+  UNION ALL
+    SELECT cset_id, {{.FormUsualTriple}}, crec_seq,
+      subject_id, prop_id, 0, 0,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      val_datatype_id, 0, '', string_val,
+      ''
+    FROM {{.Prefix}}crec_litdatatype
+  UNION ALL
+    SELECT cset_id, {{.FormOrdContItem}}, crec_seq,
+      subject_id, 0, pos_cn, old_pos_cn,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      val_type_id, item_id, lang_tag, string_val,
+      ''
+    FROM {{.Prefix}}crec_ordcont
+  UNION ALL
+    SELECT cset_id, {{.FormIDLitBin}}, crec_seq,
+      subject_id, 0, offset_cn, 0,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      0, 0, '', '',
+      bin_val
+    FROM {{.Prefix}}crec_id_lit_bin
+  UNION ALL
+    SELECT cset_id, crec_form, crec_seq,
+      subject_id, prop_id, pos_cn, old_pos_cn,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      val_type_id, object_id, lang_tag, string_val,
+      bin_val
+    FROM {{.Prefix}}crec_info
+`
+
+// Change records from the view, for a range of changeset IDs
+// (both ends inclusive), in the order they were written
+// (by changeset, and by sequence number inside a changeset).
+const viewAllCRecSel = `SELECT
+  cset_id, crec_form,
+  subject_id, prop_id, pos_cn, old_pos_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  val_type_id, object_id, lang_tag, string_val,
+  bin_val
+FROM {{.Prefix}}all_crec
+  WHERE cset_id >= $1 AND cset_id <= $2
+  ORDER BY cset_id, crec_seq
+`
+
+// The last sequence number used in a changeset (-1 if none),
+// in all the change record tables.
+const viewAllCRecSelMaxSeq = `SELECT COALESCE(MAX(crec_seq), -1) FROM {{.Prefix}}all_crec
+  WHERE cset_id = $1
+`
@@ -0,0 +1,208 @@
+package cstorepg0_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstorepg0"
+	"github.com/gimpldo/ba-prototype-go/id"
+	_ "github.com/lib/pq"
+)
+
+// The tests needing a database are skipped unless this environment
+// variable has the data source name of a PostgreSQL database where
+// the test user may create schemas (a throwaway one, preferably), e.g.
+//
+//   CSTOREPG0_TEST_DSN='host=localhost dbname=cstoretest sslmode=disable' go test
+//
+const testDSNEnv = "CSTOREPG0_TEST_DSN"
+
+// openTestDB returns the test database (see testDSNEnv) and the name
+// of a new schema there, dropped with its contents at the end of the test.
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("No PostgreSQL test database (%s not set)", testDSNEnv)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schemaName := fmt.Sprintf("cstorepg0_test_%d", time.Now().UnixNano())
+	_, err = db.Exec("CREATE SCHEMA " + schemaName)
+	if err != nil {
+		t.Fatalf("Failed to create test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + schemaName + " CASCADE")
+		if err != nil {
+			t.Errorf("Failed to drop test schema: %v", err)
+		}
+	})
+	return db, schemaName
+}
+
+// newTestStore returns the definition of a new store (schema elements
+// created) in a new schema of the test database, and the store prefix.
+func newTestStore(t *testing.T) (*sql.DB, cstore.SQLDef, string) {
+	db, schemaName := openTestDB(t)
+	prefix := schemaName + ".test_"
+	sd, err := cstorepg0.SQLDefFactory{}.CreateSQLStore(db, prefix, "")
+	if err != nil {
+		t.Fatalf("CreateSQLStore failed: %v", err)
+	}
+	report, err := sd.CreateCStoreSchemaElements()
+	if err != nil {
+		t.Fatalf("CreateCStoreSchemaElements failed: %v\n%v", err, report)
+	}
+	return db, sd, prefix
+}
+
+func newTestDop(t *testing.T) (*sql.DB, cstore.Dop, string) {
+	db, sd, prefix := newTestStore(t)
+	dop, err := sd.UseCStore()
+	if err != nil {
+		t.Fatalf("UseCStore failed: %v", err)
+	}
+	t.Cleanup(dop.Close)
+	return db, dop, prefix
+}
+
+// The schema check finds the elements in the schema of the prefix,
+// and notices a changed table.
+func TestSchemaCheck(t *testing.T) {
+	db, sd, prefix := newTestStore(t)
+
+	report, err := sd.CheckCStoreSchema()
+	if err != nil {
+		t.Fatalf("CheckCStoreSchema failed: %v\n%v", err, report)
+	}
+	if report.NumExpected == 0 || report.NumMatched != report.NumExpected {
+		t.Fatalf("Not all elements matched in a new store:\n%v", report)
+	}
+
+	// Same local prefix in the current schema: no store there
+	_, err = cstorepg0.SQLDefFactory{}.OpenSQLStore(db, "test_")
+	if err == nil {
+		t.Errorf("Store found outside of its schema")
+	}
+
+	_, err = db.Exec("ALTER TABLE " + prefix + "crec_ordcont DROP COLUMN old_pos_cn")
+	if err != nil {
+		t.Fatalf("Failed to alter table: %v", err)
+	}
+	report, err = sd.CheckCStoreSchema()
+	if err != nil {
+		t.Fatalf("CheckCStoreSchema failed: %v\n%v", err, report)
+	}
+	if report.NumMismatched != 1 || report.NumMatched != report.NumExpected-1 {
+		t.Errorf("Changed table not noticed:\n%v", report)
+	}
+}
+
+// The records refer to their changeset header: a changeset
+// with records cannot be deleted alone.
+func TestForeignKeys(t *testing.T) {
+	db, dop, prefix := newTestDop(t)
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	err = change.PutSet(sink, &change.Set{
+		SetInfo: change.SetInfo{ID: 2001},
+		ChangeRecords: []change.Rec{{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+			SubjectID: 1000, Prop: id.FromID(1001), ObjectID: 1002}},
+	})
+	if err != nil {
+		sink.Abort()
+		t.Fatalf("PutSet failed: %v", err)
+	}
+	sink.End()
+
+	_, err = db.Exec("DELETE FROM " + prefix + "cset_info WHERE cset_id = 2001")
+	if err == nil {
+		t.Errorf("Changeset header with records deleted")
+	}
+}
+
+// The same triple with different record types in the same changeset
+// (as in the 'cstoresqlite0' test), for every triple table.
+func TestSameTripleTypes(t *testing.T) {
+	_, dop, _ := newTestDop(t)
+
+	var recs []change.Rec
+	for _, v := range []change.Rec{
+		{ObjectID: 1002},
+		{ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "label"},
+		{ValueTypeID: 1003, StringVal: "42"},
+	} {
+		v.ChangeSetID = 2001
+		v.Form = change.UsualTriple
+		v.SubjectID = 1000
+		v.Prop = id.FromID(1001)
+		for _, recType := range []change.RecTypeCode{change.TestRT, change.DelRT} {
+			v.ChangeRecType = recType
+			recs = append(recs, v)
+		}
+	}
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	for _, rec := range recs {
+		err = sink.PutChangeRec(rec)
+		if err != nil {
+			sink.Abort()
+			t.Fatalf("Failed to put %v record: %v", rec.ChangeRecType, err)
+		}
+	}
+	sink.End()
+
+	set, found, err := cstore.ReadSet(dop, 2001)
+	if err != nil || !found {
+		t.Fatalf("ReadSet: found %v, error %v", found, err)
+	}
+	if !reflect.DeepEqual(set.ChangeRecords, recs) {
+		t.Errorf("Records read back differ:\n got  %+v\n want %+v", set.ChangeRecords, recs)
+	}
+}
+
+// A property ID out of the packable range (corrupt store) is an error
+// of the pull source, not a panic.
+func TestBadPropID(t *testing.T) {
+	db, dop, prefix := newTestDop(t)
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	err = change.PutSet(sink, &change.Set{
+		SetInfo: change.SetInfo{ID: 2001},
+		ChangeRecords: []change.Rec{{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+			SubjectID: 1000, Prop: id.FromID(1001), ObjectID: 1002}},
+	})
+	if err != nil {
+		sink.Abort()
+		t.Fatalf("PutSet failed: %v", err)
+	}
+	sink.End()
+
+	_, err = db.Exec("UPDATE "+prefix+"crec_idobj SET prop_id = $1 WHERE cset_id = 2001", int64(1)<<60)
+	if err != nil {
+		t.Fatalf("Failed to corrupt the record: %v", err)
+	}
+	_, _, err = cstore.ReadSet(dop, 2001)
+	if err == nil {
+		t.Errorf("ReadSet of a record with a bad property ID succeeded")
+	}
+}
@@ -0,0 +1,27 @@
+package cstoresqlite0_test
+
+import (
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore/cstoretest"
+	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+)
+
+func TestConformance(t *testing.T) {
+	ft := cstoretest.FactoryTest{
+		Factory: cstoresqlite0.SQLDefFactory{},
+		NewDB:   openNewTestDB,
+	}
+	ft.Run(t)
+}
+
+// Same with index-organized change record tables ("WITHOUT ROWID",
+// see the 'IOTL2' conf property).
+func TestConformanceIOT(t *testing.T) {
+	ft := cstoretest.FactoryTest{
+		Factory:               cstoresqlite0.SQLDefFactory{},
+		NewDB:                 openNewTestDB,
+		SchemaCreationOptions: "crec*.IOTL2=Y",
+	}
+	ft.Run(t)
+}
@@ -0,0 +1,45 @@
+// cstoresqlite0/dopimpl.go: changes store Data Operator Implementation
+// for SQLite, v0 design and schema
+
+package cstoresqlite0
+
+import (
+	"database/sql"
+
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+type (
+	cstoreSQLiteReadingDop struct {
+		db     *sql.DB
+		prefix string
+	}
+
+	cstoreSQLiteDop struct {
+		cstoreSQLiteReadingDop
+
+		// INSERT statement text for each table, indexed by Element Index
+		insertSQL [nTables]string
+	}
+)
+
+// Explicitly check that the Data Operator types implement
+// the changes store interfaces.
+var (
+	_ cstore.ReadingDop = (*cstoreSQLiteReadingDop)(nil)
+	_ cstore.Dop        = (*cstoreSQLiteDop)(nil)
+)
+
+func (dop *cstoreSQLiteReadingDop) Close() {
+	// Nothing to release: the *sql.DB belongs to the caller, and
+	// the objects made by this Data Operator have their own Close/End.
+}
+
+func (dop *cstoreSQLiteDop) MakeInternalIDGetCloser() (id.InternalIDGetCloser, error) {
+	return newIntIDGetter(dop.db, dop.prefix), nil
+}
+
+func (dop *cstoreSQLiteDop) MakeExternalIDGetCloser() (id.ExternalIDGetCloser, error) {
+	return newExtIDGetter(dop.db, dop.prefix), nil
+}
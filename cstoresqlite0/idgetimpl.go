@@ -0,0 +1,206 @@
+// cstoresqlite0/idgetimpl.go: Internal and External ID getters for SQLite
+
+package cstoresqlite0
+
+import (
+	"database/sql"
+
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// Number of IDs reserved by an ID getter with one database access.
+//
+// Bigger blocks mean fewer (short, but writing) transactions;
+// smaller blocks mean fewer IDs lost when ID getters are closed.
+const idBlockSize = 256
+
+// Name of the (only) allocation sequence in the 'id_alloc' table
+const mainAllocSeqName = "main"
+
+// The first ID allocated in a new store: right after the reserved range.
+const firstAllocatedID = id.MaxReservedID + 1
+
+// intIDGetter implements id.InternalIDGetCloser.
+//
+// Each block of IDs is reserved in its own short transaction
+// (not the caller's transaction, if any), so an ID is never returned twice
+// even if the changes using it are rolled back.
+// Note that in SQLite this transaction must wait for any other open write
+// transaction on the same database; with a single connection, get the IDs
+// (or at least the first one of a block) before starting to write changes.
+//
+type intIDGetter struct {
+	db *sql.DB
+
+	updateSQL string
+	selectSQL string
+	insertSQL string
+
+	// The current block of IDs: from 'nextID' (inclusive)
+	// to 'limitID' (exclusive); empty when nextID == limitID.
+	nextID  id.IntID
+	limitID id.IntID
+
+	closed bool
+}
+
+func newIntIDGetter(db *sql.DB, prefix string) *intIDGetter {
+	data := sqlTemplateData{Prefix: prefix}
+	return &intIDGetter{
+		db:        db,
+		updateSQL: generateSQL(tableIDAllocUpd, data),
+		selectSQL: generateSQL(tableIDAllocSel, data),
+		insertSQL: generateSQL(tableIDAllocIns, data),
+	}
+}
+
+func (g *intIDGetter) GetNewInternalID() (id.IntID, error) {
+	if g.closed {
+		return id.NoID, errors.Errorf("Internal ID getter already closed")
+	}
+	if g.nextID >= g.limitID {
+		err := g.reserveBlock()
+		if err != nil {
+			return id.NoID, err
+		}
+	}
+	newID := g.nextID
+	g.nextID++
+	return newID, nil
+}
+
+func (g *intIDGetter) reserveBlock() (err error) {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return errors.Wrapf(err, "Cannot begin transaction to reserve IDs")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec(g.updateSQL, idBlockSize, mainAllocSeqName)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to reserve block of %d IDs", idBlockSize)
+	}
+	nAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "Cannot get affected count after reserving IDs")
+	}
+	if nAffected == 0 {
+		// First allocation in this store: the sequence row is created
+		// with the first block already reserved.
+		_, err = tx.Exec(g.insertSQL, mainAllocSeqName, int64(firstAllocatedID+idBlockSize))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to initialize ID allocation sequence")
+		}
+	}
+
+	var nextFree int64
+	err = tx.QueryRow(g.selectSQL, mainAllocSeqName).Scan(&nextFree)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read ID allocation sequence")
+	}
+	if id.IntID(nextFree)-idBlockSize < firstAllocatedID {
+		err = errors.Errorf("Corrupt ID allocation sequence: next free ID %d", nextFree)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to commit reserved block of IDs")
+	}
+
+	g.limitID = id.IntID(nextFree)
+	g.nextID = g.limitID - idBlockSize
+	return nil
+}
+
+func (g *intIDGetter) Close() error {
+	g.closed = true
+	g.nextID = g.limitID
+	return nil
+}
+
+// extIDGetter implements id.ExternalIDGetCloser.
+type extIDGetter struct {
+	db *sql.DB
+
+	insertSQL string
+	selectSQL string
+
+	intIDs *intIDGetter
+
+	// IRIs already looked up (or mapped) by this getter;
+	// a mapping never changes once recorded, so caching is safe.
+	known map[string]id.IntID
+}
+
+func newExtIDGetter(db *sql.DB, prefix string) *extIDGetter {
+	data := sqlTemplateData{Prefix: prefix}
+	return &extIDGetter{
+		db:        db,
+		insertSQL: generateSQL(tableExtIDIns, data),
+		selectSQL: generateSQL(tableExtIDSel, data),
+		intIDs:    newIntIDGetter(db, prefix),
+		known:     make(map[string]id.IntID),
+	}
+}
+
+func (g *extIDGetter) LookupInternalIDForIRI(iri string) (id.IntID, bool, error) {
+	if g.known == nil {
+		return id.NoID, false, errors.Errorf("External ID getter already closed")
+	}
+	if intID, ok := g.known[iri]; ok {
+		return intID, true, nil
+	}
+
+	var intID int64
+	err := g.db.QueryRow(g.selectSQL, iri).Scan(&intID)
+	switch {
+	case err == sql.ErrNoRows:
+		return id.NoID, false, nil
+	case err != nil:
+		return id.NoID, false, errors.Wrapf(err, "Failed to look up IRI <%s>", iri)
+	}
+
+	g.known[iri] = id.IntID(intID)
+	return id.IntID(intID), true, nil
+}
+
+func (g *extIDGetter) GetInternalIDForIRI(iri string) (id.IntID, error) {
+	if iri == "" {
+		return id.NoID, errors.Errorf("Empty IRI")
+	}
+
+	intID, found, err := g.LookupInternalIDForIRI(iri)
+	if err != nil || found {
+		return intID, err
+	}
+
+	newID, err := g.intIDs.GetNewInternalID()
+	if err != nil {
+		return id.NoID, err
+	}
+
+	_, insErr := g.db.Exec(g.insertSQL, iri, int64(newID))
+	if insErr != nil {
+		// Maybe another writer mapped the same IRI meanwhile;
+		// in that case, use its mapping (the new ID is simply lost).
+		intID, found, err = g.LookupInternalIDForIRI(iri)
+		if err == nil && found {
+			return intID, nil
+		}
+		return id.NoID, errors.Wrapf(insErr, "Failed to map IRI <%s> to ID %d", iri, newID)
+	}
+
+	g.known[iri] = newID
+	return newID, nil
+}
+
+func (g *extIDGetter) Close() error {
+	g.known = nil
+	return g.intIDs.Close()
+}
@@ -0,0 +1,283 @@
+package cstoresqlite0_test
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoreconfsql"
+	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// The schema of version 1 (the first one, without schema version entry),
+// as created with prefix "test_" and no options; the view was broken.
+var schemaV1 = []string{`CREATE TABLE test_cstore_conf (
+  cstore_element TEXT NOT NULL,
+  cstore_property TEXT NOT NULL,
+  cstore_value TEXT NOT NULL,
+  PRIMARY KEY (cstore_element, cstore_property)
+) WITHOUT ROWID`,
+	`CREATE TABLE test_cset_info (
+  cset_id INTEGER PRIMARY KEY NOT NULL,
+  cset_todo_property TEXT NOT NULL,
+  cset_todo_value TEXT NOT NULL
+)`,
+	`CREATE TABLE test_crec_idobj (
+  cset_id INTEGER NOT NULL,
+  subject_id INTEGER NOT NULL,
+  prop_id INTEGER NOT NULL,
+  object_id INTEGER NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id INTEGER NOT NULL,
+  edit_op_cid INTEGER NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, prop_id, object_id)
+)`,
+	`CREATE TABLE test_crec_langstring (
+  cset_id INTEGER NOT NULL,
+  subject_id INTEGER NOT NULL,
+  prop_id INTEGER NOT NULL,
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id INTEGER NOT NULL,
+  edit_op_cid INTEGER NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, prop_id, lang_tag, string_val)
+)`,
+	`CREATE TABLE test_crec_litdatatype (
+  cset_id INTEGER NOT NULL,
+  subject_id INTEGER NOT NULL,
+  prop_id INTEGER NOT NULL,
+  val_datatype_id INTEGER NOT NULL,
+  string_val TEXT NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id INTEGER NOT NULL,
+  edit_op_cid INTEGER NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, prop_id, val_datatype_id, string_val)
+)`,
+	`CREATE TABLE test_crec_ordcont (
+  cset_id INTEGER NOT NULL,
+  subject_id INTEGER NOT NULL,
+  pos_cn INTEGER NOT NULL,
+  old_pos_cn INTEGER NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id INTEGER NOT NULL,
+  edit_op_cid INTEGER NOT NULL,
+  val_type_id INTEGER NOT NULL,
+  item_id INTEGER NOT NULL,
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, pos_cn)
+)`,
+	`CREATE TABLE test_crec_id_lit_text (
+  cset_id INTEGER NOT NULL,
+  subject_id INTEGER NOT NULL,
+  offset_cn INTEGER NOT NULL,
+  MAYBE_old_offset_cn INTEGER NOT NULL,
+  crec_type INTEGER NOT NULL,
+  crec_flags INTEGER NOT NULL,
+  crec_context_id INTEGER NOT NULL,
+  edit_op_cid INTEGER NOT NULL,
+  val_datatype_id INTEGER NOT NULL,
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, offset_cn)
+)`,
+	`CREATE VIEW test_all_crec AS
+    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      lang_tag, string_val
+    FROM test_crec_idobj
+  UNION ALL
+    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
+
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      lang_tag, string_val
+    FROM test_crec_langstring
+  UNION ALL
+    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
+
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      lang_tag, string_val
+    FROM test_crec_litdatatype
+  UNION ALL
+    SELECT cset_id, subject_id, pos_cn, old_pos_cn,
+      val_type_id, item_id,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      lang_tag, string_val
+    FROM test_crec_ordcont`,
+	`INSERT INTO test_cstore_conf VALUES ('', 'CStoreImplName', 'cstoresqlite0')`,
+}
+
+// Rows of a version 1 store: changeset 1 with a header (placeholder
+// columns), changeset 2 without; not in the order of the primary keys.
+func insertRowsV1(t *testing.T, db *sql.DB) {
+	pos0, pos1 := int64(id.FromPos(0)), int64(id.FromPos(1))
+	for _, row := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO test_cset_info VALUES (?, ?, ?)`,
+			[]interface{}{1, "origin", "import"}},
+		{`INSERT INTO test_crec_idobj VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, 100, 101, 103, change.DelRT, 0, 0, 0}},
+		{`INSERT INTO test_crec_idobj VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, 100, 101, 102, change.AddRT, 0, 0, 0}},
+		{`INSERT INTO test_crec_langstring VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, 100, 104, "en", "label", change.AddRT, 0, 0, 0}},
+		{`INSERT INTO test_crec_ordcont VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, 110, pos1, 0, change.AppendRT, 0, 0, 0, 0, 112, "", ""}},
+		{`INSERT INTO test_crec_ordcont VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{1, 110, pos0, 0, change.AppendRT, 0, 0, 0, 0, 111, "", ""}},
+		{`INSERT INTO test_crec_litdatatype VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{2, 100, 105, 106, "42", change.AddRT, 0, 0, 0}},
+		{`INSERT INTO test_crec_id_lit_text VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			[]interface{}{2, 120, pos0, 0, change.InsertAtRT, 0, 0, 0, 0, "en", "abc"}},
+	} {
+		_, err := db.Exec(row.query, row.args...)
+		if err != nil {
+			t.Fatalf("Failed to insert version 1 row: %v", err)
+		}
+	}
+}
+
+// schemaOf returns the SQL of the elements with prefix "test_", by name.
+func schemaOf(t *testing.T, db *sql.DB) map[string]string {
+	rows, err := db.Query(`SELECT name, sql FROM sqlite_master
+  WHERE name LIKE 'test\_%' ESCAPE '\' AND sql IS NOT NULL`)
+	if err != nil {
+		t.Fatalf("Failed to query schema: %v", err)
+	}
+	defer rows.Close()
+
+	schema := make(map[string]string)
+	for rows.Next() {
+		var name, sqlText string
+		err = rows.Scan(&name, &sqlText)
+		if err != nil {
+			t.Fatalf("Failed to read schema: %v", err)
+		}
+		schema[name] = sqlText
+	}
+	return schema
+}
+
+func TestMigrateFromVersion1(t *testing.T) {
+	db := openNewTestDB(t)
+	for _, query := range schemaV1 {
+		_, err := db.Exec(query)
+		if err != nil {
+			t.Fatalf("Failed to make version 1 store: %v", err)
+		}
+	}
+	insertRowsV1(t, db)
+
+	sd, err := cstoresqlite0.SQLDefFactory{}.OpenSQLStore(db, "test_")
+	if err != nil {
+		t.Fatalf("OpenSQLStore failed: %v", err)
+	}
+	report, err := sd.MigrateCStoreSchema()
+	if err != nil {
+		t.Fatalf("MigrateCStoreSchema failed: %v\n%v", err, report)
+	}
+	nSteps := cstore.LatestSchemaVersion("cstoresqlite0") - cstoreconfsql.FirstSchemaVersion
+	if len(report.Steps) != nSteps {
+		t.Errorf("%d migration steps done, want %d", len(report.Steps), nSteps)
+	}
+
+	// Same schema and configuration as a new store:
+	newDB := openNewTestDB(t)
+	newSD, err := cstoresqlite0.SQLDefFactory{}.CreateSQLStore(newDB, "test_", "")
+	if err == nil {
+		_, err = newSD.CreateCStoreSchemaElements()
+	}
+	if err != nil {
+		t.Fatalf("Failed to create new store: %v", err)
+	}
+	if got, want := schemaOf(t, db), schemaOf(t, newDB); !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema after migration:\n got  %v\n want %v", got, want)
+	}
+	conf, err := cstoreconfsql.ReadConfFromDB(db, "test_")
+	if err != nil {
+		t.Fatalf("ReadConfFromDB failed: %v", err)
+	}
+	newConf, err := cstoreconfsql.ReadConfFromDB(newDB, "test_")
+	if err != nil {
+		t.Fatalf("ReadConfFromDB failed: %v", err)
+	}
+	if !reflect.DeepEqual(conf, newConf) {
+		t.Errorf("Configuration after migration:\n got  %v\n want %v", conf, newConf)
+	}
+
+	// The rows are kept, the records read in their old order:
+	sd, err = cstoresqlite0.SQLDefFactory{}.OpenSQLStore(db, "test_")
+	if err != nil {
+		t.Fatalf("OpenSQLStore of migrated store failed: %v", err)
+	}
+	dop, err := sd.UseCStore()
+	if err != nil {
+		t.Fatalf("UseCStore failed: %v", err)
+	}
+	defer dop.Close()
+
+	wantSets := []*change.Set{
+		{SetInfo: change.SetInfo{ID: 1,
+			Annotations: []change.Annotation{{Key: "origin", Value: "import"}}},
+			ChangeRecords: []change.Rec{
+				{ChangeSetID: 1, Form: change.UsualTriple, ChangeRecType: change.AddRT,
+					SubjectID: 100, Prop: id.FromID(101), ObjectID: 102},
+				{ChangeSetID: 1, Form: change.UsualTriple, ChangeRecType: change.DelRT,
+					SubjectID: 100, Prop: id.FromID(101), ObjectID: 103},
+				{ChangeSetID: 1, Form: change.UsualTriple, ChangeRecType: change.AddRT,
+					SubjectID: 100, Prop: id.FromID(104),
+					ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "label"},
+				{ChangeSetID: 1, Form: change.OrdContItem, ChangeRecType: change.AppendRT,
+					SubjectID: 110, Prop: id.FromPos(0), ObjectID: 111},
+				{ChangeSetID: 1, Form: change.OrdContItem, ChangeRecType: change.AppendRT,
+					SubjectID: 110, Prop: id.FromPos(1), ObjectID: 112},
+			}},
+		{SetInfo: change.SetInfo{ID: 2},
+			ChangeRecords: []change.Rec{
+				{ChangeSetID: 2, Form: change.UsualTriple, ChangeRecType: change.AddRT,
+					SubjectID: 100, Prop: id.FromID(105), ValueTypeID: 106, StringVal: "42"},
+				{ChangeSetID: 2, Form: change.IDLitBin, ChangeRecType: change.InsertAtRT,
+					SubjectID: 120, Prop: id.FromPos(0), BinVal: []byte("abc")},
+			}},
+	}
+	for _, want := range wantSets {
+		set, found, err := cstore.ReadSet(dop, want.ID)
+		if err != nil || !found {
+			t.Fatalf("ReadSet(%d): found %v, error %v", want.ID, found, err)
+		}
+		if !reflect.DeepEqual(set, want) {
+			t.Errorf("Changeset %d after migration:\n got  %+v\n want %+v", want.ID, set, want)
+		}
+	}
+
+	// More records can be put into a migrated changeset:
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	more := change.Rec{ChangeSetID: 2, Form: change.UsualTriple, ChangeRecType: change.AddRT,
+		SubjectID: 100, Prop: id.FromID(101), ObjectID: 107}
+	err = sink.PutChangeRec(more)
+	if err != nil {
+		sink.Abort()
+		t.Fatalf("PutChangeRec into migrated changeset failed: %v", err)
+	}
+	sink.End()
+	set, _, err := cstore.ReadSet(dop, 2)
+	if err != nil {
+		t.Fatalf("ReadSet(2) failed: %v", err)
+	}
+	if recs := set.ChangeRecords; len(recs) != 3 || !reflect.DeepEqual(recs[2], more) {
+		t.Errorf("Changeset 2 after putting a record: %+v", recs)
+	}
+}
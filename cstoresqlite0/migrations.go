@@ -0,0 +1,165 @@
+// cstoresqlite0/migrations.go: schema migration steps for the SQLite changes store
+
+package cstoresqlite0
+
+import (
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+)
+
+// The schema migration steps, in version order; a new schema version
+// needs a new step here, after the last one (see cstore.RegisterMigrationSteps).
+//
+// The steps use the element definitions of the latest version:
+// an element (re)created by a step must not change afterwards,
+// unless a later step replaces it again.
+// The SELECT statements of the rebuilt tables are templates like
+// the ones in sqltemplates.go (only '.Prefix' is given, see
+// generateMigrationSteps); they select from the tables as they are
+// before the step.
+//
+// Version 1 = the first schema of this store, from before the schema
+// version entry was introduced: changeset headers with placeholder
+// columns only, change records without 'crec_seq', triple tables keyed
+// without the record type, text literals in 'crec_id_lit_text'.
+//
+var migrationSteps = []sqlschema.MigrationStep{
+	{FromVersion: 1,
+		Descr:       "changeset parents and annotations",
+		AddElements: []string{tableCSetParentBN},
+		RebuildTables: []sqlschema.TableRebuild{
+			// The placeholder property and value of the version 1
+			// headers are kept as annotations:
+			{BaseName: tableCSetAnnotBN, SelectSQL: `SELECT
+  cset_id, 0 AS annot_seq,
+  cset_todo_property AS annot_key, cset_todo_value AS annot_value
+FROM {{.Prefix}}cset_info
+  WHERE cset_todo_property <> '' OR cset_todo_value <> ''
+`},
+		},
+	},
+	{FromVersion: 2,
+		Descr:       "changeset headers with author, time and message; ID allocation and external IDs",
+		AddElements: []string{tableIDAllocBN, tableExtIDBN},
+		RebuildTables: []sqlschema.TableRebuild{
+			// With an empty header for the changesets having records
+			// but no header (see tableCSetInfoInsEmpty):
+			{BaseName: tableCSetInfoBN, SelectSQL: `SELECT cset_id, '' AS author, 0 AS created_unix_ns, '' AS message
+FROM {{.Prefix}}cset_info
+UNION SELECT cset_id, '', 0, '' FROM {{.Prefix}}crec_idobj
+UNION SELECT cset_id, '', 0, '' FROM {{.Prefix}}crec_langstring
+UNION SELECT cset_id, '', 0, '' FROM {{.Prefix}}crec_litdatatype
+UNION SELECT cset_id, '', 0, '' FROM {{.Prefix}}crec_ordcont
+UNION SELECT cset_id, '', 0, '' FROM {{.Prefix}}crec_id_lit_text
+`},
+		},
+	},
+	{FromVersion: 3,
+		Descr: "change records in put order ('crec_seq'), record type in the keys of the triple tables, " +
+			"binary literals instead of text literals",
+		// The records of a changeset get their 'crec_seq' table by table
+		// (in the order of the element definitions), each table in
+		// the order of its old primary key: the order the records
+		// were read in. The rebuilt tables before the current one
+		// give the number of records already numbered.
+		RebuildTables: []sqlschema.TableRebuild{
+			{BaseName: tableCRecIDObjBN, SelectSQL: `SELECT
+  cset_id, subject_id, prop_id, object_id,
+  ROW_NUMBER() OVER (PARTITION BY cset_id
+    ORDER BY subject_id, prop_id, object_id) - 1 AS crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
+FROM {{.Prefix}}crec_idobj
+`},
+			{BaseName: tableCRecLangStringBN, SelectSQL: `SELECT
+  r.cset_id, r.subject_id, r.prop_id, r.lang_tag, r.string_val,
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_idobj x WHERE x.cset_id = r.cset_id) +
+  ROW_NUMBER() OVER (PARTITION BY r.cset_id
+    ORDER BY r.subject_id, r.prop_id, r.lang_tag, r.string_val) - 1 AS crec_seq,
+  r.crec_type, r.crec_flags, r.crec_context_id, r.edit_op_cid
+FROM {{.Prefix}}crec_langstring r
+`},
+			{BaseName: tableCRecLitDatatypeBN, SelectSQL: `SELECT
+  r.cset_id, r.subject_id, r.prop_id, r.val_datatype_id, r.string_val,
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_idobj x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_langstring x WHERE x.cset_id = r.cset_id) +
+  ROW_NUMBER() OVER (PARTITION BY r.cset_id
+    ORDER BY r.subject_id, r.prop_id, r.val_datatype_id, r.string_val) - 1 AS crec_seq,
+  r.crec_type, r.crec_flags, r.crec_context_id, r.edit_op_cid
+FROM {{.Prefix}}crec_litdatatype r
+`},
+			{BaseName: tableCRecOrdContBN, SelectSQL: `SELECT
+  r.cset_id, r.subject_id,
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_idobj x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_langstring x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_litdatatype x WHERE x.cset_id = r.cset_id) +
+  ROW_NUMBER() OVER (PARTITION BY r.cset_id
+    ORDER BY r.subject_id, r.pos_cn) - 1 AS crec_seq,
+  r.pos_cn, r.old_pos_cn,
+  r.crec_type, r.crec_flags, r.crec_context_id, r.edit_op_cid,
+  r.val_type_id, r.item_id,
+  r.lang_tag, r.string_val
+FROM {{.Prefix}}crec_ordcont r
+`},
+			// The text becomes the binary value (UTF-8 bytes, as
+			// written by change.TextLiteralBuilder); the language tag
+			// and datatype of the text literal records are not kept
+			// (an identified literal has none in the latest version).
+			{BaseName: tableCRecIDLitBinBN, SelectSQL: `SELECT
+  r.cset_id, r.subject_id,
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_idobj x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_langstring x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_litdatatype x WHERE x.cset_id = r.cset_id) +
+  (SELECT COUNT(*) FROM {{.Prefix}}crec_ordcont x WHERE x.cset_id = r.cset_id) +
+  ROW_NUMBER() OVER (PARTITION BY r.cset_id
+    ORDER BY r.subject_id, r.offset_cn) - 1 AS crec_seq,
+  r.offset_cn,
+  r.crec_type, r.crec_flags, r.crec_context_id, r.edit_op_cid,
+  CAST(r.string_val AS BLOB) AS bin_val
+FROM {{.Prefix}}crec_id_lit_text r
+`},
+		},
+		// The view on the dropped table is replaced by the next step.
+		DropElements: []sqlschema.ElementDef{
+			{ElemType: sqlschema.TableElem, BaseName: tableCRecIDLitTextBN},
+		},
+	},
+	{FromVersion: 4,
+		Descr:           "information records stored apart, in 'crec_info'",
+		AddElements:     []string{tableCRecInfoBN},
+		ReplaceElements: []string{viewAllCRecBN},
+	},
+}
+
+// Base name of the text literal table of version 1
+// (dropped by the migration to version 4)
+const tableCRecIDLitTextBN = "crec_id_lit_text"
+
+// generateMigrationSteps returns a copy of the given steps with the full
+// names of the elements to drop and the SQL of the rebuilt tables,
+// for the store with the given prefix.
+func generateMigrationSteps(steps []sqlschema.MigrationStep, prefix string) []sqlschema.MigrationStep {
+	data := sqlTemplateData{Prefix: prefix}
+
+	generated := make([]sqlschema.MigrationStep, len(steps))
+	for i, step := range steps {
+		rebuilds := make([]sqlschema.TableRebuild, len(step.RebuildTables))
+		for j, rebuild := range step.RebuildTables {
+			rebuilds[j] = sqlschema.TableRebuild{BaseName: rebuild.BaseName,
+				SelectSQL: generateSQL(rebuild.SelectSQL, data)}
+		}
+		drops := make([]sqlschema.ElementDef, len(step.DropElements))
+		for j, elem := range step.DropElements {
+			elem.Name = prefix + elem.BaseName
+			drops[j] = elem
+		}
+
+		generated[i] = step
+		generated[i].RebuildTables = rebuilds
+		generated[i].DropElements = drops
+	}
+	return generated
+}
+
+func init() {
+	cstore.RegisterMigrationSteps(cstoreImplName, migrationSteps...)
+}
@@ -0,0 +1,52 @@
+package cstoresqlite0_test
+
+import (
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+	"github.com/gimpldo/ba-prototype-go/id"
+)
+
+// A property ID out of the packable range (corrupt store) is an error
+// of the pull source, not a panic.
+func TestBadPropID(t *testing.T) {
+	db := openNewTestDB(t)
+	sd, err := cstoresqlite0.SQLDefFactory{}.CreateSQLStore(db, "test_", "")
+	if err == nil {
+		_, err = sd.CreateCStoreSchemaElements()
+	}
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	dop, err := sd.UseCStore()
+	if err != nil {
+		t.Fatalf("UseCStore failed: %v", err)
+	}
+	defer dop.Close()
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	err = change.PutSet(sink, &change.Set{
+		SetInfo: change.SetInfo{ID: 2001},
+		ChangeRecords: []change.Rec{{Form: change.UsualTriple, ChangeRecType: change.AddRT,
+			SubjectID: 1000, Prop: id.FromID(1001), ObjectID: 1002}},
+	})
+	if err != nil {
+		sink.Abort()
+		t.Fatalf("PutSet failed: %v", err)
+	}
+	sink.End()
+
+	_, err = db.Exec("UPDATE test_crec_idobj SET prop_id = ? WHERE cset_id = 2001", int64(1)<<60)
+	if err != nil {
+		t.Fatalf("Failed to corrupt the record: %v", err)
+	}
+	_, _, err = cstore.ReadSet(dop, 2001)
+	if err == nil {
+		t.Errorf("ReadSet of a record with a bad property ID succeeded")
+	}
+}
@@ -0,0 +1,99 @@
+package cstoresqlite0_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/change/cstore"
+	"github.com/gimpldo/ba-prototype-go/cstoresqlite0"
+	"github.com/gimpldo/ba-prototype-go/id"
+	_ "github.com/gimpldo/go-sqlite3"
+)
+
+// openNewTestDB returns a new (empty) database, in a file removed
+// at the end of the test.
+func openNewTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cstore.db"))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestDop returns the Data Operator of a new store,
+// in a new database.
+func newTestDop(t *testing.T) cstore.Dop {
+	sd, err := cstoresqlite0.SQLDefFactory{}.CreateSQLStore(openNewTestDB(t), "test_", "")
+	if err != nil {
+		t.Fatalf("CreateSQLStore failed: %v", err)
+	}
+	report, err := sd.CreateCStoreSchemaElements()
+	if err != nil {
+		t.Fatalf("CreateCStoreSchemaElements failed: %v\n%v", err, report)
+	}
+	dop, err := sd.UseCStore()
+	if err != nil {
+		t.Fatalf("UseCStore failed: %v", err)
+	}
+	t.Cleanup(dop.Close)
+	return dop
+}
+
+// The same triple with different record types in the same changeset
+// ('TestRT' then 'DelRT': the usual way to delete a triple only if it is
+// still there), for every triple table.
+func TestSameTripleTypes(t *testing.T) {
+	dop := newTestDop(t)
+
+	var recs []change.Rec
+	for _, v := range []change.Rec{
+		{ObjectID: 1002},
+		{ValueTypeID: id.RDFLangStringID, LangTag: "en", StringVal: "label"},
+		{ValueTypeID: 1003, StringVal: "42"},
+	} {
+		v.ChangeSetID = 2001
+		v.Form = change.UsualTriple
+		v.SubjectID = 1000
+		v.Prop = id.FromID(1001)
+		for _, recType := range []change.RecTypeCode{change.TestRT, change.DelRT} {
+			v.ChangeRecType = recType
+			recs = append(recs, v)
+		}
+	}
+
+	sink, err := dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	for _, rec := range recs {
+		err = sink.PutChangeRec(rec)
+		if err != nil {
+			sink.Abort()
+			t.Fatalf("Failed to put %v record: %v", rec.ChangeRecType, err)
+		}
+	}
+	sink.End()
+
+	set, found, err := cstore.ReadSet(dop, 2001)
+	if err != nil || !found {
+		t.Fatalf("ReadSet: found %v, error %v", found, err)
+	}
+	if !reflect.DeepEqual(set.ChangeRecords, recs) {
+		t.Errorf("Records read back differ:\n got  %+v\n want %+v", set.ChangeRecords, recs)
+	}
+
+	// Still one record per triple and type:
+	sink, err = dop.MakeCRecPushSinkEnder(nil)
+	if err != nil {
+		t.Fatalf("MakeCRecPushSinkEnder failed: %v", err)
+	}
+	defer sink.Abort()
+	err = sink.PutChangeRec(recs[0])
+	if err == nil {
+		t.Errorf("Same triple and type put twice in changeset 2001")
+	}
+}
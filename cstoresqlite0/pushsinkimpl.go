@@ -0,0 +1,372 @@
+// cstoresqlite0/pushsinkimpl.go: change record Push Sink Implementation
+// for SQLite, v0 design and schema
+
+package cstoresqlite0
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/gimpldo/ba-prototype-go/change"
+	"github.com/gimpldo/ba-prototype-go/id"
+	"github.com/pkg/errors"
+)
+
+// cstoreSQLitePushSink implements change.RecPushSink and,
+// when made by MakeCRecPushSinkEnder, change.RecPushSinkEnder.
+//
+// All the records are written using the same transaction;
+// the prepared statements are cached (one per table, made when
+// first needed) for the duration of the transaction.
+//
+type cstoreSQLitePushSink struct {
+	tx *sql.Tx
+
+	insertSQL          [nTables]string
+	selectMaxSeqSQL    string
+	insertEmptyCSetSQL string
+
+	// Prepared statement cache, indexed by Element Index;
+	// statements prepared in a transaction are closed automatically
+	// when the transaction ends (commit or rollback).
+	stmts [nTables]*sql.Stmt
+
+	// Next 'crec_seq' value for each changeset seen by this sink
+	nextSeq map[id.IntID]int64
+
+	// Changesets whose header is known to be stored
+	// (written by this sink, or found already stored)
+	csetHeaderDone map[id.IntID]bool
+
+	ended bool
+}
+
+// MakeCRecPushSink makes a push sink writing with the given transaction;
+// the records are validated first (see change.ValidatingSink).
+func (dop *cstoreSQLiteDop) MakeCRecPushSink(tx *sql.Tx) (change.RecPushSink, error) {
+	if tx == nil {
+		return nil, errors.Errorf("Push sink needs a transaction (got nil *sql.Tx)")
+	}
+	return change.NewValidatingSink(dop.newPushSink(tx)), nil
+}
+
+// MakeCRecPushSinkEnder makes a push sink that ends the given transaction
+// (commit on End, rollback on Abort); if the given transaction is nil,
+// a new one is started and owned by the push sink.
+// The records are validated first (see change.ValidatingSinkEnder).
+func (dop *cstoreSQLiteDop) MakeCRecPushSinkEnder(tx *sql.Tx) (change.RecPushSinkEnder, error) {
+	if tx == nil {
+		var err error
+		tx, err = dop.db.Begin()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot begin transaction for push sink")
+		}
+	}
+	return change.NewValidatingSinkEnder(dop.newPushSink(tx)), nil
+}
+
+func (dop *cstoreSQLiteDop) newPushSink(tx *sql.Tx) *cstoreSQLitePushSink {
+	sink := &cstoreSQLitePushSink{
+		tx:        tx,
+		insertSQL: dop.insertSQL,
+		nextSeq:   make(map[id.IntID]int64),
+
+		csetHeaderDone: make(map[id.IntID]bool),
+	}
+
+	data := sqlTemplateData{Prefix: dop.prefix}
+	sink.selectMaxSeqSQL = generateSQL(viewAllCRecSelMaxSeq, data)
+	sink.insertEmptyCSetSQL = generateSQL(tableCSetInfoInsEmpty, data)
+
+	return sink
+}
+
+func (sink *cstoreSQLitePushSink) PutChangeRec(rec change.Rec) error {
+	if sink.ended {
+		return errors.Errorf("Push sink already ended")
+	}
+	if rec.ChangeSetID == id.NoID {
+		return errors.Errorf("Change record without changeset ID: %+v", rec)
+	}
+
+	tableEI, err := routeCRec(&rec)
+	if err != nil {
+		return err
+	}
+	if change.IsInfoRecType(rec.ChangeRecType) {
+		// Checked as the other records of the same form,
+		// but stored apart (see 'crec_info'):
+		tableEI = tableCRecInfoEI
+	}
+
+	if !sink.csetHeaderDone[rec.ChangeSetID] {
+		// No header put for this changeset: make sure there is one
+		// (empty, unless it was already stored), before the records.
+		_, err = sink.tx.Exec(sink.insertEmptyCSetSQL, int64(rec.ChangeSetID))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to write header for changeset %d", rec.ChangeSetID)
+		}
+		sink.csetHeaderDone[rec.ChangeSetID] = true
+	}
+
+	stmt, err := sink.getStmt(tableEI)
+	if err != nil {
+		return err
+	}
+	seq, err := sink.takeSeq(rec.ChangeSetID)
+	if err != nil {
+		return err
+	}
+
+	var args []interface{}
+
+	switch tableEI {
+	case tableCRecIDObjEI:
+		propID, _ := rec.Prop.ID()
+		args = []interface{}{
+			int64(rec.ChangeSetID), int64(rec.SubjectID),
+			int64(propID), int64(rec.ObjectID), seq,
+		}
+	case tableCRecLangStringEI:
+		propID, _ := rec.Prop.ID()
+		args = []interface{}{
+			int64(rec.ChangeSetID), int64(rec.SubjectID),
+			int64(propID), rec.LangTag, rec.StringVal, seq,
+		}
+	case tableCRecLitDatatypeEI:
+		propID, _ := rec.Prop.ID()
+		args = []interface{}{
+			int64(rec.ChangeSetID), int64(rec.SubjectID),
+			int64(propID), int64(rec.ValueTypeID), rec.StringVal, seq,
+		}
+	case tableCRecOrdContEI:
+		args = []interface{}{
+			int64(rec.ChangeSetID), int64(rec.SubjectID),
+			seq, int64(rec.Prop), int64(rec.OldProp),
+		}
+	case tableCRecIDLitBinEI:
+		args = []interface{}{
+			int64(rec.ChangeSetID), int64(rec.SubjectID),
+			seq, int64(rec.Prop),
+		}
+	case tableCRecInfoEI:
+		var propID id.IntID
+		var posCN, oldPosCN int64
+		if rec.Form == change.UsualTriple {
+			propID, _ = rec.Prop.ID()
+		} else {
+			posCN, oldPosCN = int64(rec.Prop), int64(rec.OldProp)
+		}
+		args = []interface{}{
+			int64(rec.ChangeSetID), seq, int64(rec.Form),
+			int64(rec.SubjectID), int64(propID), posCN, oldPosCN,
+		}
+	default:
+		panic("Unexpected table routing")
+	}
+
+	args = append(args,
+		int64(rec.ChangeRecType), int64(rec.ChangeRecFlags),
+		int64(rec.ChangeRecContextID), int64(rec.EditOpCID))
+
+	switch tableEI {
+	case tableCRecOrdContEI:
+		args = append(args,
+			int64(rec.ValueTypeID), int64(rec.ObjectID),
+			rec.LangTag, rec.StringVal)
+	case tableCRecIDLitBinEI:
+		args = append(args, notNullBytes(rec.BinVal))
+	case tableCRecInfoEI:
+		args = append(args,
+			int64(rec.ValueTypeID), int64(rec.ObjectID),
+			rec.LangTag, rec.StringVal,
+			notNullBytes(rec.BinVal))
+	}
+
+	_, err = stmt.Exec(args...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to insert change record into %s: %+v",
+			elementTemplates[tableEI].BaseName, rec)
+	}
+	return nil
+}
+
+// PutChangeSetInfo writes the changeset header (metadata);
+// must be called before putting the changeset's records, and
+// fails if the changeset's header is already stored.
+func (sink *cstoreSQLitePushSink) PutChangeSetInfo(info change.SetInfo) error {
+	if sink.ended {
+		return errors.Errorf("Push sink already ended")
+	}
+	if info.ID == id.NoID {
+		return errors.Errorf("Changeset header without ID")
+	}
+	if sink.csetHeaderDone[info.ID] {
+		return errors.Errorf("Header for changeset %d already written (records put before header?)",
+			info.ID)
+	}
+
+	var createdUnixNs int64
+	if !info.CreatedAt.IsZero() {
+		createdUnixNs = info.CreatedAt.UnixNano()
+	}
+
+	stmt, err := sink.getStmt(tableCSetInfoEI)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(int64(info.ID), info.Author, createdUnixNs, info.Message)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write header for changeset %d", info.ID)
+	}
+
+	if len(info.ParentIDs) != 0 {
+		stmt, err = sink.getStmt(tableCSetParentEI)
+		if err != nil {
+			return err
+		}
+		for i, parentID := range info.ParentIDs {
+			_, err = stmt.Exec(int64(info.ID), i, int64(parentID))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to write parent %d/%d for changeset %d",
+					i, len(info.ParentIDs), info.ID)
+			}
+		}
+	}
+
+	if len(info.Annotations) != 0 {
+		stmt, err = sink.getStmt(tableCSetAnnotEI)
+		if err != nil {
+			return err
+		}
+		for i, annot := range info.Annotations {
+			_, err = stmt.Exec(int64(info.ID), i, annot.Key, annot.Value)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to write annotation %d/%d for changeset %d",
+					i, len(info.Annotations), info.ID)
+			}
+		}
+	}
+
+	sink.csetHeaderDone[info.ID] = true
+	return nil
+}
+
+// routeCRec decides which table should store the given change record
+// (based on its Form, ValueTypeID and ObjectID) and returns its Element Index.
+func routeCRec(rec *change.Rec) (int, error) {
+	switch rec.Form {
+	case change.UsualTriple:
+		if rec.Prop.IsPos() {
+			return 0, errors.Errorf("Usual triple with position instead of property: %+v", *rec)
+		}
+		switch rec.ValueTypeID {
+		case id.NoID:
+			if rec.ObjectID == id.NoID {
+				return 0, errors.Errorf("Usual triple without object or value type: %+v", *rec)
+			}
+			if rec.LangTag != "" || rec.StringVal != "" {
+				return 0, errors.Errorf("Usual triple with both object ID and string value: %+v", *rec)
+			}
+			return tableCRecIDObjEI, nil
+		case id.RDFLangStringID:
+			if rec.ObjectID != id.NoID {
+				return 0, errors.Errorf("Language-tagged string with object ID: %+v", *rec)
+			}
+			return tableCRecLangStringEI, nil
+		default:
+			if rec.ObjectID != id.NoID {
+				return 0, errors.Errorf("Literal with datatype and object ID: %+v", *rec)
+			}
+			if rec.LangTag != "" {
+				return 0, errors.Errorf("Literal with datatype (not langString) and language tag: %+v", *rec)
+			}
+			return tableCRecLitDatatypeEI, nil
+		}
+	case change.OrdContItem:
+		if !rec.Prop.IsPos() {
+			return 0, errors.Errorf("Container item without position: %+v", *rec)
+		}
+		if rec.ValueTypeID != id.NoID && rec.ObjectID != id.NoID {
+			return 0, errors.Errorf("Container item with both value type and item ID: %+v", *rec)
+		}
+		return tableCRecOrdContEI, nil
+	case change.IDLitBin:
+		if !rec.Prop.IsPos() {
+			return 0, errors.Errorf("Binary literal record without offset: %+v", *rec)
+		}
+		if rec.ValueTypeID != id.NoID || rec.ObjectID != id.NoID ||
+			rec.LangTag != "" || rec.StringVal != "" {
+			return 0, errors.Errorf("Binary literal record with non-binary value: %+v", *rec)
+		}
+		return tableCRecIDLitBinEI, nil
+	default:
+		return 0, errors.Errorf("Unsupported change record form %d: %+v", rec.Form, *rec)
+	}
+}
+
+// notNullBytes returns the given bytes, or an empty slice instead of nil
+// (for NOT NULL columns).
+func notNullBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func (sink *cstoreSQLitePushSink) getStmt(tableEI int) (*sql.Stmt, error) {
+	stmt := sink.stmts[tableEI]
+	if stmt != nil {
+		return stmt, nil
+	}
+
+	stmt, err := sink.tx.Prepare(sink.insertSQL[tableEI])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to prepare insert for %s",
+			elementTemplates[tableEI].BaseName)
+	}
+	sink.stmts[tableEI] = stmt
+	return stmt, nil
+}
+
+// takeSeq returns the next record sequence number for the given changeset
+// (shared by all the change record tables); the first time a changeset
+// is seen, continues after the records already stored (the changeset
+// may be written using several transactions).
+func (sink *cstoreSQLitePushSink) takeSeq(csetID id.IntID) (int64, error) {
+	seq, ok := sink.nextSeq[csetID]
+	if !ok {
+		var maxSeq int64
+		err := sink.tx.QueryRow(sink.selectMaxSeqSQL, int64(csetID)).Scan(&maxSeq)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Failed to get record sequence for changeset %d", csetID)
+		}
+		seq = maxSeq + 1
+	}
+	sink.nextSeq[csetID] = seq + 1
+	return seq, nil
+}
+
+func (sink *cstoreSQLitePushSink) End() {
+	if sink.ended {
+		return
+	}
+	sink.ended = true
+
+	err := sink.tx.Commit()
+	if err != nil {
+		log.Printf("Push sink failed to commit: %v", err)
+	}
+}
+
+func (sink *cstoreSQLitePushSink) Abort() {
+	if sink.ended {
+		return
+	}
+	sink.ended = true
+
+	err := sink.tx.Rollback()
+	if err != nil {
+		log.Printf("Push sink failed to roll back: %v", err)
+	}
+}
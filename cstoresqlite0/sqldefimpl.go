@@ -23,6 +23,10 @@ const cstoreImplName = "cstoresqlite0"
 
 type SQLDefFactory struct{}
 
+func init() {
+	cstore.Register(cstoreImplName, SQLDefFactory{})
+}
+
 type (
 	commonDef struct {
 		db     *sql.DB
@@ -65,6 +69,14 @@ func (SQLDefFactory) OpenSQLStoreReadOnly(db *sql.DB, storePrefix string) (cstor
 	if err != nil {
 		return nil, err
 	}
+	err = cstoreconfsql.CheckImplName(confEntries, cstoreImplName)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSchemaVersion(confEntries)
+	if err != nil {
+		return nil, err
+	}
 
 	sd := &cstoreSQLiteReadingDef{
 		commonDef: commonDef{db: db, prefix: storePrefix, dbConf: confEntries},
@@ -92,6 +104,14 @@ func (SQLDefFactory) OpenSQLStore(db *sql.DB, storePrefix string) (cstore.SQLDef
 	if err != nil {
 		return nil, err
 	}
+	err = cstoreconfsql.CheckImplName(confEntries, cstoreImplName)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSchemaVersion(confEntries)
+	if err != nil {
+		return nil, err
+	}
 
 	sd := &cstoreSQLiteDef{
 		commonDef: commonDef{db: db, prefix: storePrefix, dbConf: confEntries},
@@ -134,6 +154,15 @@ func (SQLDefFactory) CreateSQLStore(db *sql.DB, storePrefix, schemaCreationOptio
 		return nil, err
 	}
 
+	for _, entry := range creationConfList {
+		if entry.ConfElement == "" && (entry.ConfProperty == cstoreconfsql.ImplNameProperty ||
+			entry.ConfProperty == cstoreconfsql.SchemaVersionProperty) {
+			return nil, errors.Errorf("Reserved property in schema creation options: %#v", entry)
+		}
+	}
+	creationConfList = append(creationConfList,
+		cstoreconfsql.SchemaVersionEntry(cstore.LatestSchemaVersion(cstoreImplName)))
+
 	sort.Sort(geconf.CanonicalOrder(creationConfList))
 
 	extendedConf := prependImplInfoToConf(creationConfList)
@@ -253,6 +282,64 @@ func (sd *cstoreSQLiteDef) DropCStoreSchemaElements() (sqlschema.OpReport, error
 	return sd.report, err
 }
 
+// MigrateCStoreSchema runs the migration steps from the store's
+// schema version (see migrations.go); the element statuses in
+// the report come from a check done before the migration.
+func (sd *cstoreSQLiteDef) MigrateCStoreSchema() (sqlschema.OpReport, error) {
+	version, err := cstoreconfsql.SchemaVersion(sd.dbConf)
+	if err != nil {
+		return sd.report, err
+	}
+	steps, err := cstore.MigrationSteps(cstoreImplName, version)
+	if err != nil {
+		return sd.report, err
+	}
+	steps = generateMigrationSteps(steps, sd.prefix)
+
+	dbElementsFound, err := sqlite3schema.ReadFromDB(sd.db, sd.prefix, "")
+	if err != nil {
+		sd.report.LastOp = sqlschema.OpCheckFailDB
+		return sd.report, err
+	}
+	err = checkCStoreSchema(&sd.commonDef, dbElementsFound)
+	if err != nil {
+		return sd.report, err
+	}
+
+	if len(steps) == 0 { // up to date
+		sd.report.NumCreated, sd.report.NumDropped, sd.report.NumFailed = 0, 0, 0
+		sd.report.LastOp = sqlschema.OpMigrate
+		sd.report.Steps = nil
+		return sd.report, nil
+	}
+
+	tx, err := sd.db.Begin()
+	if err != nil {
+		return sd.report, errors.Wrapf(err, "Failed to begin schema migration")
+	}
+
+	newVersion := version + len(steps)
+	err = sqlschema.MigrateElements(tx, &sd.report, sd.elementDefs[:], steps)
+	if err == nil {
+		err = cstoreconfsql.WriteSchemaVersion(tx, sd.prefix, newVersion)
+	}
+	if err != nil {
+		tx.Rollback()
+		return sd.report, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return sd.report, errors.Wrapf(err, "Failed to commit schema migration to version %d",
+			newVersion)
+	}
+
+	sd.dbConf = cstoreconfsql.SetSchemaVersion(sd.dbConf, newVersion)
+	regeneratedConf, _ := geconf.List(sd.dbConf).MarshalText()
+	sd.report.Conf = string(regeneratedConf)
+
+	return sd.report, nil
+}
+
 func (sd *cstoreSQLiteReadingDef) CheckCStoreSchema() (sqlschema.OpReport, error) {
 	dbElementsFound, err := sqlite3schema.ReadFromDB(sd.db, sd.prefix, "")
 	if err != nil {
@@ -344,24 +431,37 @@ func checkCStoreSchema(c *commonDef, dbElementsFound []sqlite3schema.ElementFoun
 }
 
 func (sd *cstoreSQLiteReadingDef) UseCStoreReadOnly() (cstore.ReadingDop, error) {
-	dop := &cstoreSQLiteReadingDop{db: sd.db}
+	dop := &cstoreSQLiteReadingDop{db: sd.db, prefix: sd.prefix}
 	return dop, nil
 }
 
 func (sd *cstoreSQLiteDef) UseCStoreReadOnly() (cstore.ReadingDop, error) {
-	dop := &cstoreSQLiteReadingDop{db: sd.db}
+	dop := &cstoreSQLiteReadingDop{db: sd.db, prefix: sd.prefix}
 	return dop, nil
 }
 
 func (sd *cstoreSQLiteDef) UseCStore() (cstore.Dop, error) {
-	dop := &cstoreSQLiteDop{db: sd.db}
+	dop := &cstoreSQLiteDop{
+		cstoreSQLiteReadingDop: cstoreSQLiteReadingDop{db: sd.db, prefix: sd.prefix},
+	}
 	generateInsertSQLForAllTables(dop.insertSQL[:], insertSQLTemplates[:], sd.prefix)
 	return dop, nil
 }
 
+// checkSchemaVersion makes sure that the store's schema version
+// is known (the store may need migration, but it is not newer).
+func checkSchemaVersion(confEntries []geconf.Entry) error {
+	version, err := cstoreconfsql.SchemaVersion(confEntries)
+	if err != nil {
+		return err
+	}
+	_, err = cstore.MigrationSteps(cstoreImplName, version)
+	return err
+}
+
 func prependImplInfoToConf(confEntries []geconf.Entry) []geconf.Entry {
 	cstoreImplNameEntry := geconf.Entry{
-		ConfProperty: "CStoreImplName",
+		ConfProperty: cstoreconfsql.ImplNameProperty,
 		ConfValue:    cstoreImplName,
 	}
 
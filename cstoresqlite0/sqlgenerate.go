@@ -9,7 +9,9 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/gimpldo/ba-prototype-go/change"
 	"github.com/gimpldo/ba-prototype-go/geconf"
+	"github.com/gimpldo/ba-prototype-go/id"
 	"github.com/gimpldo/ba-prototype-go/sqlschema"
 )
 
@@ -57,6 +59,11 @@ func generateDefsForAllElements(
 
 		data.Prefix = prefix
 
+		data.FormUsualTriple = int(change.UsualTriple)
+		data.FormOrdContItem = int(change.OrdContItem)
+		data.FormIDLitBin = int(change.IDLitBin)
+		data.LangStringID = int64(id.RDFLangStringID)
+
 		generated[i].CreateSQL = generateSQL(templates[i].CreateSQL, data)
 		generated[i].ElemType = templates[i].ElemType
 		generated[i].BaseName = baseName
@@ -142,9 +149,11 @@ func organizeConfEntries(confEntries []geconf.Entry) []geconf.Entry {
 
 	sort.Sort(geconf.RankOrder(confEntries))
 
-	var iSkip int
-	for iSkip = range confEntries {
-		if confEntries[iSkip].Rank >= 0 {
+	// If no entry has a non-negative rank, all are skipped:
+	iSkip := len(confEntries)
+	for i := range confEntries {
+		if confEntries[i].Rank >= 0 {
+			iSkip = i
 			break
 		}
 	}
@@ -35,12 +35,17 @@ import (
 //
 const (
 	tableCStoreConfEI = iota
+	tableIDAllocEI
+	tableExtIDEI
 	tableCSetInfoEI
+	tableCSetParentEI
+	tableCSetAnnotEI
 	tableCRecIDObjEI
 	tableCRecLangStringEI
 	tableCRecLitDatatypeEI
 	tableCRecOrdContEI
-	tableCRecIDLitTextEI
+	tableCRecIDLitBinEI
+	tableCRecInfoEI
 	viewAllCRecEI
 	nElements // must be last ConstSpec in the const block
 )
@@ -50,17 +55,33 @@ const (
 // (1) the Element Index for the last table (adding one to it), or
 // (2) the constant immediately after the last table (first view element),
 //      in this case without '+ 1'.
-const nTables = tableCRecIDLitTextEI + 1
+const nTables = tableCRecInfoEI + 1
 
 var elementTemplates = [nElements]sqlschema.ElementTemplate{
 	tableCStoreConfEI: {ElemType: sqlschema.TableElem,
 		BaseName:  tableCStoreConfBN,
 		CreateSQL: tableCStoreConfCre,
 	},
+	tableIDAllocEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableIDAllocBN,
+		CreateSQL: tableIDAllocCre,
+	},
+	tableExtIDEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableExtIDBN,
+		CreateSQL: tableExtIDCre,
+	},
 	tableCSetInfoEI: {ElemType: sqlschema.TableElem,
 		BaseName:  tableCSetInfoBN,
 		CreateSQL: tableCSetInfoCre,
 	},
+	tableCSetParentEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCSetParentBN,
+		CreateSQL: tableCSetParentCre,
+	},
+	tableCSetAnnotEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCSetAnnotBN,
+		CreateSQL: tableCSetAnnotCre,
+	},
 	tableCRecIDObjEI: {ElemType: sqlschema.TableElem,
 		BaseName:  tableCRecIDObjBN,
 		CreateSQL: tableCRecIDObjCre,
@@ -77,9 +98,13 @@ var elementTemplates = [nElements]sqlschema.ElementTemplate{
 		BaseName:  tableCRecOrdContBN,
 		CreateSQL: tableCRecOrdContCre,
 	},
-	tableCRecIDLitTextEI: {ElemType: sqlschema.TableElem,
-		BaseName:  tableCRecIDLitTextBN,
-		CreateSQL: tableCRecIDLitTextCre,
+	tableCRecIDLitBinEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecIDLitBinBN,
+		CreateSQL: tableCRecIDLitBinCre,
+	},
+	tableCRecInfoEI: {ElemType: sqlschema.TableElem,
+		BaseName:  tableCRecInfoBN,
+		CreateSQL: tableCRecInfoCre,
 	},
 	viewAllCRecEI: {ElemType: sqlschema.ViewElem,
 		BaseName:  viewAllCRecBN,
@@ -89,10 +114,15 @@ var elementTemplates = [nElements]sqlschema.ElementTemplate{
 
 var insertSQLTemplates = [nTables]string{
 	tableCStoreConfEI:      tableCStoreConfIns,
+	tableIDAllocEI:         tableIDAllocIns,
+	tableExtIDEI:           tableExtIDIns,
 	tableCSetInfoEI:        tableCSetInfoIns,
+	tableCSetParentEI:      tableCSetParentIns,
+	tableCSetAnnotEI:       tableCSetAnnotIns,
 	tableCRecIDObjEI:       tableCRecIDObjIns,
 	tableCRecLangStringEI:  tableCRecLangStringIns,
 	tableCRecLitDatatypeEI: tableCRecLitDatatypeIns,
 	tableCRecOrdContEI:     tableCRecOrdContIns,
-	tableCRecIDLitTextEI:   tableCRecIDLitTextIns,
+	tableCRecIDLitBinEI:    tableCRecIDLitBinIns,
+	tableCRecInfoEI:        tableCRecInfoIns,
 }
@@ -77,6 +77,13 @@ This template argument (.IndexOrganizedTableL2) is for the "Level two" of
 using index-organized tables: cases which might benefit or not from it
 (there are possible problems as well as possible benefits).
 
+.FormUsualTriple, .FormOrdContItem, .FormIDLitBin, .LangStringID: integers
+
+Fixed values (not configurable, always set by the generator):
+change.RecFormCode values and the ID for 'rdf:langString',
+used by the view definitions to fill in columns for tables where
+the value is implied.
+
 */
 type sqlTemplateData struct {
 	Prefix string
@@ -89,6 +96,12 @@ type sqlTemplateData struct {
 
 	IndexOrganizedTableL1 bool
 	IndexOrganizedTableL2 bool
+
+	// Fixed values (not configurable) used in the view definitions
+	FormUsualTriple int
+	FormOrdContItem int
+	FormIDLitBin    int
+	LangStringID    int64
 }
 
 // The head table: by checking it we can say whether we got a valid CStore;
@@ -117,23 +130,148 @@ const tableCStoreConfIns = `INSERT INTO {{.Prefix}}cstore_conf (
 ) VALUES (?, ?, ?)
 `
 
+// ID allocation table: the next free internal ID, for each named
+// allocation sequence (only one sequence is used for now: 'main').
+//
+// IDs are allocated in blocks: an ID getter reserves a block of IDs
+// with a single UPDATE (short transaction) and hands them out
+// without touching the database until the block is exhausted,
+// so concurrent writers don't hit the database for each new ID.
+// The unused rest of a block is simply lost when the ID getter is closed.
+//
+// This is an index-organized table ("WITHOUT ROWID" in SQLite3) for
+// the same reason as the conf table: it's very small.
+//
+const tableIDAllocBN = "id_alloc"
+const tableIDAllocCre = `CREATE TABLE {{.Prefix}}id_alloc (
+  alloc_seq_name TEXT NOT NULL,
+  next_free_id INTEGER NOT NULL,
+  PRIMARY KEY (alloc_seq_name)
+) WITHOUT ROWID
+`
+const tableIDAllocIns = `INSERT INTO {{.Prefix}}id_alloc (
+  alloc_seq_name, next_free_id
+) VALUES (?, ?)
+`
+const tableIDAllocUpd = `UPDATE {{.Prefix}}id_alloc
+  SET next_free_id = next_free_id + ?
+  WHERE alloc_seq_name = ?
+`
+const tableIDAllocSel = `SELECT next_free_id FROM {{.Prefix}}id_alloc
+  WHERE alloc_seq_name = ?
+`
+
+// External ID table: maps external identifiers (IRIs) to internal IDs.
+//
+// An internal ID appears at most once (UNIQUE) so the mapping
+// can be used in both directions.
+//
+// It's on "Level two" of using index-organized tables:
+// (1) Possible problem: row size has no specified limit
+//      (IRIs are usually short, but there is no limit)
+// (2) Possible benefits of IOTs: reduced storage requirements
+//      by avoiding a separate index for the primary key
+//
+const tableExtIDBN = "ext_id"
+const tableExtIDCre = `CREATE TABLE {{.Prefix}}ext_id (
+  ext_iri TEXT NOT NULL,
+  int_id INTEGER NOT NULL UNIQUE{{.ReferencesIDTable}},
+  PRIMARY KEY (ext_iri)
+) {{if .IndexOrganizedTableL2}} WITHOUT ROWID {{end}}
+`
+const tableExtIDIns = `INSERT INTO {{.Prefix}}ext_id (
+  ext_iri, int_id
+) VALUES (?, ?)
+`
+const tableExtIDSel = `SELECT int_id FROM {{.Prefix}}ext_id
+  WHERE ext_iri = ?
+`
+
 // The main changeset table: contains one row for each changeset
+// (the changeset header = metadata, except the multi-valued parts:
+// see the 'cset_parent' and 'cset_annot' tables below).
 //
 // Note that the primary key is *not* followed by 'ReferencesChangeSetIDTable'
 // because *this* table is intended to *be* the "ChangeSet ID Table"
 // (so it would refer to itself).
 //
+// 'created_unix_ns' is the creation time as Unix time in nanoseconds
+// (zero for unknown creation time).
+//
+// A header row may be written by the push sink with empty metadata
+// (for changesets whose records are put without a header),
+// so the change record tables can always refer to this table.
+//
 const tableCSetInfoBN = "cset_info"
 const tableCSetInfoCre = `CREATE TABLE {{.Prefix}}cset_info (
   cset_id INTEGER PRIMARY KEY NOT NULL{{.ReferencesIDTable}},
-  cset_todo_property TEXT NOT NULL,
-  cset_todo_value TEXT NOT NULL
+  author TEXT NOT NULL,
+  created_unix_ns INTEGER NOT NULL,
+  message TEXT NOT NULL
 )
 `
 const tableCSetInfoIns = `INSERT INTO {{.Prefix}}cset_info (
-  cset_id, cset_todo_property, cset_todo_value
+  cset_id, author, created_unix_ns, message
+) VALUES (?, ?, ?, ?)
+`
+const tableCSetInfoInsEmpty = `INSERT OR IGNORE INTO {{.Prefix}}cset_info (
+  cset_id, author, created_unix_ns, message
+) VALUES (?, '', 0, '')
+`
+const tableCSetInfoSel = `SELECT
+  author, created_unix_ns, message
+FROM {{.Prefix}}cset_info
+  WHERE cset_id = ?
+`
+
+// Changeset parents: the changeset(s) each changeset was made against.
+// 'parent_seq' preserves the order of parents (significant for merges).
+//
+const tableCSetParentBN = "cset_parent"
+const tableCSetParentCre = `CREATE TABLE {{.Prefix}}cset_parent (
+  cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
+  parent_seq INTEGER NOT NULL,
+  parent_cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
+  PRIMARY KEY (cset_id, parent_seq)
+) {{if .IndexOrganizedTableL1}} WITHOUT ROWID {{end}}
+`
+const tableCSetParentIns = `INSERT INTO {{.Prefix}}cset_parent (
+  cset_id, parent_seq, parent_cset_id
 ) VALUES (?, ?, ?)
 `
+const tableCSetParentSel = `SELECT parent_cset_id
+FROM {{.Prefix}}cset_parent
+  WHERE cset_id = ?
+  ORDER BY parent_seq
+`
+
+// Changeset annotations: arbitrary key/value pairs.
+// Keys need not be unique; 'annot_seq' preserves the order.
+//
+// It's on "Level two" of using index-organized tables:
+// (1) Possible problem: row size has no specified limit
+//      (contains string fields which can be arbitrarily big)
+// (2) Possible benefits of IOTs: reduced storage requirements
+//      by avoiding a separate index for the primary key
+//
+const tableCSetAnnotBN = "cset_annot"
+const tableCSetAnnotCre = `CREATE TABLE {{.Prefix}}cset_annot (
+  cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
+  annot_seq INTEGER NOT NULL,
+  annot_key TEXT NOT NULL,
+  annot_value TEXT NOT NULL,
+  PRIMARY KEY (cset_id, annot_seq)
+) {{if .IndexOrganizedTableL2}} WITHOUT ROWID {{end}}
+`
+const tableCSetAnnotIns = `INSERT INTO {{.Prefix}}cset_annot (
+  cset_id, annot_seq, annot_key, annot_value
+) VALUES (?, ?, ?, ?)
+`
+const tableCSetAnnotSel = `SELECT annot_key, annot_value
+FROM {{.Prefix}}cset_annot
+  WHERE cset_id = ?
+  ORDER BY annot_seq
+`
 
 const tableBN = ""
 const tableCre = `CREATE TABLE {{.Prefix}} (
@@ -148,25 +286,28 @@ const tableCre = `CREATE TABLE {{.Prefix}} (
 // Expected benefit of IOTs: reduced storage requirements
 // by avoiding a separate index for the primary key.
 //
+// The record type is part of the primary key (here and in the other
+// triple tables): a changeset may have several records for the same
+// triple ('TestRT' then 'DelRT', for instance), not two of the same type.
+//
 const tableCRecIDObjBN = "crec_idobj"
 const tableCRecIDObjCre = `CREATE TABLE {{.Prefix}}crec_idobj (
   cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
   subject_id INTEGER NOT NULL{{.ReferencesIDTable}},
   prop_id INTEGER NOT NULL{{.ReferencesIDTable}},
   object_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  crec_seq INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
   crec_flags INTEGER NOT NULL,
   crec_context_id INTEGER NOT NULL{{.ReferencesIDTable}},
   edit_op_cid INTEGER NOT NULL{{.ReferencesIDTable}},
-  PRIMARY KEY (cset_id, subject_id, prop_id, object_id)
+  PRIMARY KEY (cset_id, subject_id, prop_id, object_id, crec_type)
 ) {{if .IndexOrganizedTableL1}} WITHOUT ROWID {{end}}
 `
 const tableCRecIDObjIns = `INSERT INTO {{.Prefix}}crec_idobj (
-  cset_id, subject_id, pos_cn, old_pos_cn,
-  crec_type, crec_flags, crec_context_id, edit_op_cid,
-  val_type_id, item_id,
-  lang_tag, string_val
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+  cset_id, subject_id, prop_id, object_id, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 // Table for change records with value = Language-tagged String
@@ -210,18 +351,17 @@ const tableCRecLangStringCre = `CREATE TABLE {{.Prefix}}crec_langstring (
   prop_id INTEGER NOT NULL{{.ReferencesIDTable}},
   lang_tag TEXT NOT NULL,
   string_val TEXT NOT NULL,
+  crec_seq INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
   crec_flags INTEGER NOT NULL,
   crec_context_id INTEGER NOT NULL{{.ReferencesIDTable}},
   edit_op_cid INTEGER NOT NULL{{.ReferencesIDTable}},
-  PRIMARY KEY (cset_id, subject_id, prop_id, lang_tag, string_val)
+  PRIMARY KEY (cset_id, subject_id, prop_id, lang_tag, string_val, crec_type)
 ) {{if .IndexOrganizedTableL2}} WITHOUT ROWID {{end}}
 `
 const tableCRecLangStringIns = `INSERT INTO {{.Prefix}}crec_langstring (
-  cset_id, subject_id, pos_cn, old_pos_cn,
-  crec_type, crec_flags, crec_context_id, edit_op_cid,
-  val_type_id, item_id,
-  lang_tag, string_val
+  cset_id, subject_id, prop_id, lang_tag, string_val, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
@@ -243,18 +383,17 @@ const tableCRecLitDatatypeCre = `CREATE TABLE {{.Prefix}}crec_litdatatype (
   prop_id INTEGER NOT NULL{{.ReferencesIDTable}},
   val_datatype_id INTEGER NOT NULL{{.ReferencesIDTable}},
   string_val TEXT NOT NULL,
+  crec_seq INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
   crec_flags INTEGER NOT NULL,
   crec_context_id INTEGER NOT NULL{{.ReferencesIDTable}},
   edit_op_cid INTEGER NOT NULL{{.ReferencesIDTable}},
-  PRIMARY KEY (cset_id, subject_id, prop_id, val_datatype_id, string_val)
+  PRIMARY KEY (cset_id, subject_id, prop_id, val_datatype_id, string_val, crec_type)
 ) {{if .IndexOrganizedTableL2}} WITHOUT ROWID {{end}}
 `
 const tableCRecLitDatatypeIns = `INSERT INTO {{.Prefix}}crec_litdatatype (
-  cset_id, subject_id, pos_cn, old_pos_cn,
-  crec_type, crec_flags, crec_context_id, edit_op_cid,
-  val_type_id, item_id,
-  lang_tag, string_val
+  cset_id, subject_id, prop_id, val_datatype_id, string_val, crec_seq,
+  crec_type, crec_flags, crec_context_id, edit_op_cid
 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
@@ -301,6 +440,14 @@ const tableCRecIns = `INSERT INTO {{.Prefix}}(
 // 'val_type_id' corresponds to 'val_datatype_id' from 'crec_litdatatype'.
 // In this case, the 'item_id' column must contain zero (id.NoID).
 //
+// Positions ('pos_cn', 'old_pos_cn') are indexes in the container
+// as it is when the record is applied, so the records for a container
+// must be applied in the order they were written in the changeset
+// (kept by 'crec_seq', see the 'all_crec' view).
+// The position cannot be part of the primary key: a changeset may contain
+// several records for the same position (example: prepend two items =
+// two 'InsertAtRT' records with position zero).
+//
 // It's on "Level two" of using index-organized tables:
 // (1) Possible problem: row size has no specified limit ---
 //      contains string field which can be arbitrarily big
@@ -314,6 +461,7 @@ const tableCRecOrdContBN = "crec_ordcont"
 const tableCRecOrdContCre = `CREATE TABLE {{.Prefix}}crec_ordcont (
   cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
   subject_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  crec_seq INTEGER NOT NULL,
   pos_cn INTEGER NOT NULL,
   old_pos_cn INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
@@ -324,20 +472,20 @@ const tableCRecOrdContCre = `CREATE TABLE {{.Prefix}}crec_ordcont (
   item_id INTEGER NOT NULL{{.ReferencesIDTable}},
   lang_tag TEXT NOT NULL,
   string_val TEXT NOT NULL,
-  PRIMARY KEY (cset_id, subject_id, pos_cn)
+  PRIMARY KEY (cset_id, subject_id, crec_seq)
 ) {{if .IndexOrganizedTableL2}} WITHOUT ROWID {{end}}
 `
 const tableCRecOrdContIns = `INSERT INTO {{.Prefix}}crec_ordcont (
-  cset_id, subject_id, pos_cn, old_pos_cn,
+  cset_id, subject_id, crec_seq, pos_cn, old_pos_cn,
   crec_type, crec_flags, crec_context_id, edit_op_cid,
   val_type_id, item_id,
   lang_tag, string_val
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-// Table for change records for IDentified Literal nodes containing Text data.
+// Table for change records for IDentified Literal nodes containing Binary data.
 //
-// The goal is to have an ID corresponding to a potentially big text
+// The goal is to have an ID corresponding to a potentially big binary
 // so the "big literal" can be:
 //  - shared = referred by multiple triples (in the object position);
 //  - annotated, tagged (by triples having it in the subject position);
@@ -352,115 +500,162 @@ const tableCRecOrdContIns = `INSERT INTO {{.Prefix}}crec_ordcont (
 // our node may "be" simultaneously an Order-preserving Container).
 //
 // This is why it would be *misleading* to say that the goal is to
-// "provide an ID for a potentially big text": it would be too limiting.
-//
-// On the other hand, it would make little sense to use the same ID
-// for a big text and a big binary = to have for the *long* term
-// same 'subject_id' in both 'crec_id_lit_text' and 'crec_id_lit_bin' tables.
-// *Short-term* coexistence (example: moving/converting from one to the other)
-// seems useful; a changeset could contain records saying:
-//  - delete text literal and
-//  - insert equivalent data into a new binary literal using the same ID.
-//
-// Trying to prevent unexpected uses seems complicated and unnecessary.
-// There is no clear risk/problem that would require such restrictions.
-// TODO: document risks/problems related to this (if any).
-//
-const tableCRecIDLitTextBN = "crec_id_lit_text"
-const tableCRecIDLitTextCre = `CREATE TABLE {{.Prefix}}crec_id_lit_text (
+// "provide an ID for a potentially big binary": it would be too limiting.
+//
+// There is no table for IDentified Literal nodes containing Text data
+// ('crec_id_lit_text' in the first design): a big text is stored here,
+// as UTF-8 bytes (see change.TextLiteralBuilder), so text and binary
+// edits work the same way, with byte offsets.
+//
+// The byte offset ('offset_cn', packed id.PosOrID position) is relative to
+// the literal as it is when the record is applied, like the positions in
+// the 'crec_ordcont' table, therefore the order of the records is needed
+// here too (see 'crec_ordcont' for details); 'bin_val' is the data appended,
+// prepended, inserted, removed or written over (its length gives
+// the length of the byte range affected).
+//
+const tableCRecIDLitBinBN = "crec_id_lit_bin"
+const tableCRecIDLitBinCre = `CREATE TABLE {{.Prefix}}crec_id_lit_bin (
   cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
   subject_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  crec_seq INTEGER NOT NULL,
   offset_cn INTEGER NOT NULL,
-  MAYBE_old_offset_cn INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
   crec_flags INTEGER NOT NULL,
   crec_context_id INTEGER NOT NULL{{.ReferencesIDTable}},
   edit_op_cid INTEGER NOT NULL{{.ReferencesIDTable}},
-  val_datatype_id INTEGER NOT NULL{{.ReferencesIDTable}},
-  lang_tag TEXT NOT NULL,
-  string_val TEXT NOT NULL,
-  PRIMARY KEY (cset_id, subject_id, offset_cn)
+  bin_val BLOB NOT NULL,
+  PRIMARY KEY (cset_id, subject_id, crec_seq)
 )
 `
-const tableCRecIDLitTextIns = `INSERT INTO {{.Prefix}}crec_id_lit_text (
-  cset_id, subject_id, offset_cn, MAYBE_old_offset_cn,
+const tableCRecIDLitBinIns = `INSERT INTO {{.Prefix}}crec_id_lit_bin (
+  cset_id, subject_id, crec_seq, offset_cn,
   crec_type, crec_flags, crec_context_id, edit_op_cid,
-  val_type_id, item_id,
-  lang_tag, string_val
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+  bin_val
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-// Table for change records for IDentified Literal nodes containing Binary data.
+// Table for the information records: types 'ContextRT', 'IdentRT'
+// and 'MetaRT' (see change.IsInfoRecType), of any form.
 //
-// The goal is to have an ID corresponding to a potentially big binary
-// so the "big literal" can be:
-//  - shared = referred by multiple triples (in the object position);
-//  - annotated, tagged (by triples having it in the subject position);
-//  - modified incrementally:
-//      a change record does *not* need to always specify the whole value ---
-//      can insert/append/prepend, remove, or replace *parts* of the literal.
+// They do not change the state (they are not part of the triples,
+// container items or literals), so they are kept apart from the records
+// that do: the same triple may be both added and described in a changeset,
+// and there is no uniqueness constraint on the content.
 //
-// The node/thing identified by the value in the 'subject_id' column
-// is *not* necessarily specialized or restricted to "be" a "big literal":
-// the same ID may appear, even in same changeset, in the 'subject_id' column
-// of other tables with change records (for example, 'crec_ordcont' ---
-// our node may "be" simultaneously an Order-preserving Container).
-//
-// This is why it would be *misleading* to say that the goal is to
-// "provide an ID for a potentially big binary": it would be too limiting.
+// Columns as in the 'all_crec' view: 'prop_id' for usual triples,
+// 'pos_cn' and 'old_pos_cn' for the other forms; 'crec_context_id'
+// of a 'ContextRT' record is the ID of the context it describes.
 //
-// On the other hand, it would make little sense to use the same ID
-// for a big text and a big binary = to have for the *long* term
-// same 'subject_id' in both 'crec_id_lit_text' and 'crec_id_lit_bin' tables.
-// *Short-term* coexistence (example: moving/converting from one to the other)
-// seems useful; a changeset could contain records saying:
-//  - delete text literal and
-//  - insert equivalent data into a new binary literal using the same ID.
-//
-// Trying to prevent unexpected uses seems complicated and unnecessary.
-// There is no clear risk/problem that would require such restrictions.
-// TODO: document risks/problems related to this (if any).
-//
-const tableCRecIDLitBinBN = "crec_id_lit_bin"
-const tableCRecIDLitBinCre = `CREATE TABLE {{.Prefix}}crec_id_lit_bin (
+const tableCRecInfoBN = "crec_info"
+const tableCRecInfoCre = `CREATE TABLE {{.Prefix}}crec_info (
   cset_id INTEGER NOT NULL{{.ReferencesChangeSetIDTable}},
+  crec_seq INTEGER NOT NULL,
+  crec_form INTEGER NOT NULL,
   subject_id INTEGER NOT NULL{{.ReferencesIDTable}},
-  offset_cn INTEGER NOT NULL,
-
+  prop_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  pos_cn INTEGER NOT NULL,
+  old_pos_cn INTEGER NOT NULL,
   crec_type INTEGER NOT NULL,
   crec_flags INTEGER NOT NULL,
   crec_context_id INTEGER NOT NULL{{.ReferencesIDTable}},
   edit_op_cid INTEGER NOT NULL{{.ReferencesIDTable}},
-
+  val_type_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  object_id INTEGER NOT NULL{{.ReferencesIDTable}},
+  lang_tag TEXT NOT NULL,
+  string_val TEXT NOT NULL,
   bin_val BLOB NOT NULL,
-  PRIMARY KEY (cset_id, subject_id, offset_cn)
+  PRIMARY KEY (cset_id, crec_seq)
 )
 `
+const tableCRecInfoIns = `INSERT INTO {{.Prefix}}crec_info (
+  cset_id, crec_seq, crec_form,
+  subject_id, prop_id, pos_cn, old_pos_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  val_type_id, object_id, lang_tag, string_val,
+  bin_val
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
 
-// TODO: crec_id_lit_bin
-
+// View with all the change records, as a uniform projection:
+// every change record table maps into the same column list, so
+// ad-hoc SQL and the pull source can treat the store uniformly.
+//
+// Columns not applicable to a table's records contain zero (IDs, positions)
+// or the empty string (text):
+//  - 'crec_form' is the change.RecFormCode;
+//  - 'crec_seq' is the record's sequence number in its changeset,
+//      unique among all the records of the changeset (in all the tables),
+//      so the records can be read back in the order they were written;
+//  - 'prop_id' is set for usual triples, 'pos_cn' and 'old_pos_cn'
+//      for container items (packed id.PosOrID values);
+//  - 'val_type_id' is zero (id.NoID) when the value is 'object_id',
+//      the ID for 'rdf:langString' for language-tagged strings,
+//      the datatype ID for other literals;
+//  - 'bin_val' is only set for identified binary literals
+//      ('pos_cn' contains the byte offset, from 'offset_cn').
+//
 const viewAllCRecBN = "all_crec"
 const viewAllCRecCre = `CREATE VIEW {{.Prefix}}all_crec AS
-    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
+    SELECT cset_id, {{.FormUsualTriple}} AS crec_form, crec_seq,
+      subject_id, prop_id, 0 AS pos_cn, 0 AS old_pos_cn,
       crec_type, crec_flags, crec_context_id, edit_op_cid,
-      lang_tag, string_val
+      0 AS val_type_id, object_id, '' AS lang_tag, '' AS string_val,
+      X'' AS bin_val
     FROM {{.Prefix}}crec_idobj
   UNION ALL
-    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
-
+    SELECT cset_id, {{.FormUsualTriple}}, crec_seq,
+      subject_id, prop_id, 0, 0,
       crec_type, crec_flags, crec_context_id, edit_op_cid,
-      lang_tag, string_val
+      {{.LangStringID}}, 0, lang_tag, string_val,
+      X''
     FROM {{.Prefix}}crec_langstring
   UNION ALL
-    SELECT cset_id, subject_id, prop_id AS prop, 0 AS old_prop,
-
+    SELECT cset_id, {{.FormUsualTriple}}, crec_seq,
+      subject_id, prop_id, 0, 0,
       crec_type, crec_flags, crec_context_id, edit_op_cid,
-      lang_tag, string_val
+      val_datatype_id, 0, '', string_val,
+      X''
     FROM {{.Prefix}}crec_litdatatype
   UNION ALL
-    SELECT cset_id, subject_id, pos_cn, old_pos_cn,
-      val_type_id, item_id,
+    SELECT cset_id, {{.FormOrdContItem}}, crec_seq,
+      subject_id, 0, pos_cn, old_pos_cn,
       crec_type, crec_flags, crec_context_id, edit_op_cid,
-      lang_tag, string_val
+      val_type_id, item_id, lang_tag, string_val,
+      X''
     FROM {{.Prefix}}crec_ordcont
+  UNION ALL
+    SELECT cset_id, {{.FormIDLitBin}}, crec_seq,
+      subject_id, 0, offset_cn, 0,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      0, 0, '', '',
+      bin_val
+    FROM {{.Prefix}}crec_id_lit_bin
+  UNION ALL
+    SELECT cset_id, crec_form, crec_seq,
+      subject_id, prop_id, pos_cn, old_pos_cn,
+      crec_type, crec_flags, crec_context_id, edit_op_cid,
+      val_type_id, object_id, lang_tag, string_val,
+      bin_val
+    FROM {{.Prefix}}crec_info
+`
+
+// Change records from the view, for a range of changeset IDs
+// (both ends inclusive), in the order they were written:
+// by changeset, and by sequence number inside a changeset.
+const viewAllCRecSel = `SELECT
+  cset_id, crec_form,
+  subject_id, prop_id, pos_cn, old_pos_cn,
+  crec_type, crec_flags, crec_context_id, edit_op_cid,
+  val_type_id, object_id, lang_tag, string_val,
+  bin_val
+FROM {{.Prefix}}all_crec
+  WHERE cset_id >= ? AND cset_id <= ?
+  ORDER BY cset_id, crec_seq
+`
+
+// The last sequence number used in a changeset (-1 if none),
+// in all the change record tables.
+const viewAllCRecSelMaxSeq = `SELECT COALESCE(MAX(crec_seq), -1) FROM {{.Prefix}}all_crec
+  WHERE cset_id = ?
 `
@@ -0,0 +1,176 @@
+/*
+Package id defines the internal identifier types used by the changes store
+and the interfaces for getting (allocating or mapping) such identifiers.
+*/
+package id
+
+import (
+	"fmt"
+
+	"github.com/gimpldo/ba-prototype-go/util/ecc/hamming57secded"
+)
+
+// IntID = Internal ID: identifies a node/thing (RDF resource, property,
+// changeset, etc.) inside a changes store and the systems using it.
+//
+// Internal IDs are not meaningful outside the store where they were allocated;
+// an external identifier (IRI) must be mapped to an internal ID
+// (see ExternalIDGetter below) before being used in change records.
+type IntID int64
+
+// NoID is the reserved "no identifier" value; it's the zero value of IntID
+// so a change record field which was not set reads as NoID.
+const NoID IntID = 0
+
+// MaxReservedID is the largest ID in the range reserved for
+// well-known IDs (special values, vocabulary terms which must have
+// the same internal ID in all stores, etc.).
+//
+// ID allocators must never return IDs in the reserved range
+// (from NoID to MaxReservedID, inclusive).
+const MaxReservedID IntID = 1023
+
+// Well-known IDs (from the reserved range)
+const (
+	// RDFLangStringID is the ID for 'rdf:langString', the datatype IRI of
+	// language-tagged strings; used as 'ValueTypeID' in change records
+	// having a language-tagged string as value.
+	RDFLangStringID IntID = 1
+)
+
+// PosOrID = Position or ID: a property ID (for usual triples), or
+// a position in an order-preserving container (for container items).
+//
+// The value is packed together with a tag bit (saying which of the two
+// it contains) and check bits (Hamming SEC-DED code), so it can be
+// stored as a single integer --- the '_cn' ("Checked Number") columns
+// in the change record tables --- and a corrupted value or
+// a mix-up between positions and IDs can be detected.
+//
+// The zero value contains NoID (it is a valid packed value: all the
+// check bits and the parity bit computed for zero are zero).
+type PosOrID int64
+
+// Tag bit values for PosOrID
+const (
+	idTagBit  = 0
+	posTagBit = 1
+)
+
+// Only the least significant 56 bits are preserved by
+// the Hamming (64, 57) packing; the limit is checked to avoid
+// silently storing a different value.
+const (
+	maxPackable = 1<<55 - 1
+	minPackable = -(1 << 55)
+)
+
+// FromID returns the PosOrID containing the given property ID.
+func FromID(propID IntID) PosOrID {
+	if propID < minPackable || propID > maxPackable {
+		panic(fmt.Sprintf("ID out of packable range: %d", propID))
+	}
+	return PosOrID(hamming57secded.PackWithCheckBits(int64(propID), idTagBit))
+}
+
+// CheckedFromID is like FromID, but returns an error instead of panicking
+// if the ID is out of the packable range (for IDs read from a store).
+func CheckedFromID(propID IntID) (PosOrID, error) {
+	if propID < minPackable || propID > maxPackable {
+		return 0, fmt.Errorf("ID out of packable range: %d", propID)
+	}
+	return FromID(propID), nil
+}
+
+// FromPos returns the PosOrID containing the given container position.
+func FromPos(pos int64) PosOrID {
+	if pos < minPackable || pos > maxPackable {
+		panic(fmt.Sprintf("Position out of packable range: %d", pos))
+	}
+	return PosOrID(hamming57secded.PackWithCheckBits(pos, posTagBit))
+}
+
+// IsPos reports whether the value contains a container position
+// (as opposed to a property ID).
+func (p PosOrID) IsPos() bool {
+	return p&1 == posTagBit
+}
+
+// ID returns the property ID; the second result is false if
+// the value contains a position instead.
+func (p PosOrID) ID() (IntID, bool) {
+	if p.IsPos() {
+		return NoID, false
+	}
+	return IntID(int64(p) >> 8), true
+}
+
+// Pos returns the container position; the second result is false if
+// the value contains a property ID instead.
+func (p PosOrID) Pos() (int64, bool) {
+	if !p.IsPos() {
+		return 0, false
+	}
+	return int64(p) >> 8, true
+}
+
+// Checked verifies the check bits and returns the value,
+// corrected if there was a single-bit error.
+// Returns error if the value is uncorrectable (two bit errors or more,
+// though not all multiple-bit errors can be detected).
+func (p PosOrID) Checked() (PosOrID, error) {
+	nBitErrors, corrected := hamming57secded.Correct(int64(p))
+	if nBitErrors > 1 {
+		return p, fmt.Errorf("Uncorrectable PosOrID value %x", int64(p))
+	}
+	return PosOrID(corrected), nil
+}
+
+// String method is for display and debugging purpose
+func (p PosOrID) String() string {
+	if pos, ok := p.Pos(); ok {
+		return fmt.Sprintf("pos:%d", pos)
+	}
+	propID, _ := p.ID()
+	return fmt.Sprintf("id:%d", propID)
+}
+
+// InternalIDGetter = source of new Internal IDs (allocator).
+//
+// Each ID returned is unique in the store (never returned again,
+// not even after the ID getter is closed and a new one is made)
+// and outside the reserved range (greater than MaxReservedID).
+// The IDs need not be consecutive: implementations may allocate
+// blocks of IDs and discard the unused part of a block on Close().
+type InternalIDGetter interface {
+	GetNewInternalID() (IntID, error)
+}
+
+// InternalIDGetCloser = InternalIDGetter + the ability to dispose/finish
+type InternalIDGetCloser interface {
+	InternalIDGetter
+
+	Close() error
+}
+
+// ExternalIDGetter = mapper from External IDs (IRIs) to Internal IDs.
+type ExternalIDGetter interface {
+	// GetInternalIDForIRI returns the internal ID that the given IRI
+	// is mapped to, allocating a new internal ID and recording
+	// the mapping if the IRI was not known yet.
+	// The same IRI always gets the same internal ID in a given store.
+	//
+	GetInternalIDForIRI(iri string) (IntID, error)
+
+	// LookupInternalIDForIRI is like GetInternalIDForIRI but does not
+	// allocate: 'found' is false (and the ID is NoID) for an unknown IRI.
+	//
+	LookupInternalIDForIRI(iri string) (intID IntID, found bool, err error)
+}
+
+// ExternalIDGetCloser = ExternalIDGetter + the ability to dispose/finish
+type ExternalIDGetCloser interface {
+	ExternalIDGetter
+
+	Close() error
+}
@@ -0,0 +1,261 @@
+package sqlschema
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MigrationStep = one step of a schema migration: upgrades a schema
+// from version FromVersion to the next one, by (re)creating elements
+// with their definitions from the new version, and dropping the elements
+// no longer defined.
+//
+// The elements to add, replace or rebuild are given by base name;
+// they are processed in the order of the element definitions (creation
+// order), then the elements to drop, in the given order.
+//
+type MigrationStep struct {
+	FromVersion int
+
+	// Short description of the schema change, for reports
+	Descr string
+
+	// Elements added by the step: created if missing; if found,
+	// they must match their definition (the step was already done,
+	// maybe by a version that did not record the schema version).
+	AddElements []string
+
+	// Elements changed by the step: dropped if found, then created
+	ReplaceElements []string
+
+	// Tables changed by the step, keeping their rows (see TableRebuild)
+	RebuildTables []TableRebuild
+
+	// Elements no longer defined (type and name, without CreateSQL):
+	// dropped if found
+	DropElements []ElementDef
+}
+
+// TableRebuild = a table (re)created by a migration step, with the rows
+// selected from the tables as they are before: the rows are kept
+// in a temporary table while the table is dropped (if found) and
+// created with its new definition.
+//
+// SelectSQL gives the rows in the column order of the new definition;
+// like ElementDef.CreateSQL, it has the full names of the elements
+// (generated from a template with the store prefix, for instance).
+// It may select from other tables than the rebuilt one, which need not
+// exist (a new table made from the rows of a table dropped by the step),
+// and from the tables rebuilt before it by the same step.
+//
+// A table found matching its new definition is left as it is
+// (the step was already done, as for AddElements).
+//
+type TableRebuild struct {
+	BaseName  string
+	SelectSQL string
+}
+
+// Name of the temporary table keeping the rows of a rebuilt table
+const rebuildRowsTableName = "sqlschema_rebuild_rows"
+
+// StepStatus = status record for a schema migration step
+type StepStatus struct {
+	FromVersion int
+	ToVersion   int
+	Descr       string
+
+	Done bool
+	Err  error
+}
+
+// MigrateElements runs the given migration steps in the given transaction,
+// on the schema elements described by the given definitions (the ones of
+// the last version) and by the report of a check done before, in the same
+// order; the report is updated with the status of each step and element
+// (the elements of DropElements, no longer defined, are not in the report).
+//
+// Stop and return error at first failure; then the transaction should be
+// rolled back (the report tells what failed, not what is in the database).
+//
+// This is not a method of *OpReport for the same reason as CreateElements.
+//
+func MigrateElements(tx *sql.Tx, r *OpReport, defs []ElementDef, steps []MigrationStep) error {
+	r.NumCreated = 0
+	r.NumDropped = 0
+	r.NumFailed = 0
+
+	r.LastOp = OpMigrate
+
+	r.Steps = make([]StepStatus, len(steps))
+	for i, step := range steps {
+		r.Steps[i] = StepStatus{FromVersion: step.FromVersion,
+			ToVersion: step.FromVersion + 1, Descr: step.Descr}
+	}
+
+	for i, step := range steps {
+		stepStatus := &r.Steps[i]
+
+		err := migrateStep(tx, r, defs, &step)
+		if err != nil {
+			stepStatus.Err = errors.Wrapf(err, "failed migration step %d/%d from version %d",
+				i, len(steps), step.FromVersion)
+			return stepStatus.Err
+		}
+		stepStatus.Done = true
+	}
+
+	return nil
+}
+
+func migrateStep(tx *sql.Tx, r *OpReport, defs []ElementDef, step *MigrationStep) error {
+	isIn := func(baseName string, baseNames []string) bool {
+		for _, name := range baseNames {
+			if name == baseName {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, elem := range defs {
+		statusRec := &r.Elements[i]
+
+		if statusRec.BaseName != elem.BaseName {
+			panic(fmt.Sprintf("different BaseName at %d", i))
+		}
+
+		adding := isIn(elem.BaseName, step.AddElements)
+		replacing := isIn(elem.BaseName, step.ReplaceElements)
+		var rebuild *TableRebuild
+		for j := range step.RebuildTables {
+			if step.RebuildTables[j].BaseName == elem.BaseName {
+				rebuild = &step.RebuildTables[j]
+			}
+		}
+		if !adding && !replacing && rebuild == nil {
+			continue
+		}
+
+		if rebuild != nil {
+			if statusRec.Status == MatchedES {
+				continue // already rebuilt
+			}
+			_, execErr := tx.Exec("CREATE TEMPORARY TABLE " + rebuildRowsTableName +
+				" AS " + rebuild.SelectSQL)
+			if execErr != nil {
+				statusRec.Err = errors.Wrapf(execErr, "failed to keep the rows of element %d/%d = %s [%s]",
+					i, len(defs), elem.ElemType.String(), elem.Name)
+				statusRec.Status = CreateFailedES
+				r.NumFailed++
+				return statusRec.Err
+			}
+		}
+
+		switch statusRec.Status {
+		case MissingES, DroppedES:
+			// to be created
+		case MatchedES, MismatchedES, CreatedES:
+			if adding {
+				if statusRec.Status == MismatchedES {
+					r.NumFailed++
+					return errors.Errorf("element to add %s [%s] exists, with another definition",
+						elem.ElemType.String(), elem.Name)
+				}
+				continue // already added
+			}
+
+			dropSQL := fmt.Sprintf("DROP %s %s", elem.ElemType.SQLKeyword(), elem.Name)
+			_, execErr := tx.Exec(dropSQL)
+			if execErr != nil {
+				statusRec.Err = errors.Wrapf(execErr, "failed to drop element %d/%d = %s [%s]",
+					i, len(defs), elem.ElemType.String(), elem.Name)
+				statusRec.Status = DropFailedES
+				r.NumFailed++
+				return statusRec.Err
+			}
+			statusRec.Status = DroppedES
+			r.NumDropped++
+		default:
+			panic(fmt.Sprintf(
+				"Unexpected schema element status code %x for element %d before migration (no check?)",
+				uint(statusRec.Status), i))
+		}
+
+		_, execErr := tx.Exec(elem.CreateSQL)
+		if execErr != nil {
+			statusRec.Err = errors.Wrapf(execErr, "failed to create element %d/%d = %s [%s]",
+				i, len(defs), elem.ElemType.String(), elem.Name)
+			statusRec.Status = CreateFailedES
+			r.NumFailed++
+			return statusRec.Err
+		}
+		statusRec.Err = nil
+		statusRec.Status = CreatedES
+		r.NumCreated++
+
+		if rebuild != nil {
+			execErr = copyRebuildRows(tx, elem.Name)
+			if execErr != nil {
+				statusRec.Err = errors.Wrapf(execErr, "failed to copy the rows of element %d/%d = %s [%s]",
+					i, len(defs), elem.ElemType.String(), elem.Name)
+				statusRec.Status = CreateFailedES
+				r.NumFailed++
+				return statusRec.Err
+			}
+		}
+	}
+
+	for _, elem := range step.DropElements {
+		dropSQL := fmt.Sprintf("DROP %s IF EXISTS %s", elem.ElemType.SQLKeyword(), elem.Name)
+		_, execErr := tx.Exec(dropSQL)
+		if execErr != nil {
+			r.NumFailed++
+			return errors.Wrapf(execErr, "failed to drop element no longer defined %s [%s]",
+				elem.ElemType.String(), elem.Name)
+		}
+	}
+
+	return nil
+}
+
+// copyRebuildRows copies the rows kept for a rebuilt table into it,
+// then drops the temporary table.
+func copyRebuildRows(tx *sql.Tx, tableName string) error {
+	_, err := tx.Exec("INSERT INTO " + tableName + " SELECT * FROM " + rebuildRowsTableName)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE " + rebuildRowsTableName)
+	return err
+}
+
+// String method is for display and debugging purpose
+func (ss StepStatus) String() string {
+	var buf bytes.Buffer
+	ss.Dump(&buf, 0)
+	return buf.String()
+}
+
+// Dump method is for display and debugging purpose
+func (ss StepStatus) Dump(w io.Writer, detailLevel int) {
+	status := "Not done"
+	if ss.Done {
+		status = "Done"
+	}
+	fmt.Fprintf(w, "%s: version %d -> %d {%s}", status, ss.FromVersion, ss.ToVersion, ss.Descr)
+
+	if ss.Err != nil {
+		if detailLevel > 1 {
+			fmt.Fprintf(w, ": %#+v", ss.Err)
+		} else {
+			fmt.Fprintf(w, ": %v", ss.Err)
+		}
+	} else {
+		fmt.Fprintf(w, ".")
+	}
+}
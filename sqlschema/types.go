@@ -75,9 +75,10 @@ type ElementStatus struct {
 const (
 	OpCheckFailDB = "check failed to get DB schema"
 
-	OpCheck  = "check"
-	OpCreate = "create"
-	OpDrop   = "drop"
+	OpCheck   = "check"
+	OpCreate  = "create"
+	OpDrop    = "drop"
+	OpMigrate = "migrate"
 )
 
 // OpReport = database (SQL) schema operations report,
@@ -93,11 +94,15 @@ type OpReport struct {
 	// its value should be a short lowercase verb (especially
 	// in case of success; failure can and maybe should look ugly).
 	//
-	// Suggested values: "check", "create", "drop"; there are constants
-	// defined for this purpose (OpCheck, OpCreate, OpDrop).
+	// Suggested values: "check", "create", "drop", "migrate"; there are
+	// constants defined for this purpose (OpCheck, OpCreate, OpDrop, OpMigrate).
 	//
 	LastOp string
 
+	// Schema migration steps, in the order they were (or would have been)
+	// run by the last migration (see MigrateElements)
+	Steps []StepStatus
+
 	NumExpected   int
 	NumFound      int
 	NumMatched    int
@@ -145,6 +150,11 @@ func (r OpReport) Dump(w io.Writer, detailLevel int) {
 		fmt.Fprintf(w, ", %d failed", r.NumFailed)
 	}
 
+	for i, step := range r.Steps {
+		fmt.Fprintf(w, "\n[step %d/%d] ", i, len(r.Steps))
+		step.Dump(w, detailLevel)
+	}
+
 	nElems := len(r.Elements)
 	if nElems != 0 {
 		fmt.Fprintf(w, ":")
@@ -0,0 +1,199 @@
+package pgschema
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+
+	"github.com/gimpldo/ba-prototype-go/sqlschema"
+	"github.com/pkg/errors"
+)
+
+// ElementFound = PostgreSQL database schema element found (after a search).
+// Schema elements are also known as "schema objects".
+//
+// PostgreSQL does not keep the text of the CREATE statements, so
+// the description is made from the system catalogs: the columns for
+// tables and views (from 'information_schema.columns'), and the definition
+// rebuilt by PostgreSQL for views and indexes ('pg_get_viewdef',
+// 'pg_get_indexdef'), which is normalized and cannot be compared
+// with the original statement text.
+//
+type ElementFound struct {
+	ElemType sqlschema.ElemTypeCode
+
+	// Names are not schema-qualified (all elements found are
+	// in the schema that was searched).
+	Name      string
+	TableName string
+
+	Columns []Column
+
+	// For views and indexes only (empty for tables)
+	Definition string
+}
+
+// Column = table or view column, as described by 'information_schema.columns'
+type Column struct {
+	Name string
+
+	// Lowercase type name as in 'information_schema.columns.data_type'
+	// (example: "bigint", "text", "bytea")
+	DataType string
+
+	NotNull bool
+}
+
+func (c Column) String() string {
+	if c.NotNull {
+		return c.Name + " " + c.DataType + " NOT NULL"
+	}
+	return c.Name + " " + c.DataType
+}
+
+// ReadFromDB returns the tables, views and indexes in the given schema
+// ("" for the current schema, as given by 'current_schema()') with
+// names starting with the given prefix.
+func ReadFromDB(db *sql.DB, schemaName, namePrefix string) ([]ElementFound, error) {
+	// Must be the same character as in the SQL 'ESCAPE' clauses below:
+	const escapeCharForLike = '!'
+
+	escPrefix, err := checkEscapeForLike(namePrefix, escapeCharForLike)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Prefix not acceptable")
+	}
+	if schemaName != "" {
+		_, err = checkEscapeForLike(schemaName, escapeCharForLike)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Schema name not acceptable")
+		}
+	}
+
+	rows, err := db.Query(`SELECT c.relname, c.relkind, COALESCE(t.relname, c.relname),
+    CASE c.relkind
+      WHEN 'v' THEN pg_catalog.pg_get_viewdef(c.oid)
+      WHEN 'i' THEN pg_catalog.pg_get_indexdef(c.oid)
+      ELSE ''
+    END
+  FROM pg_catalog.pg_class c
+    JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+    LEFT JOIN pg_catalog.pg_index i ON i.indexrelid = c.oid
+    LEFT JOIN pg_catalog.pg_class t ON t.oid = i.indrelid
+  WHERE n.nspname = COALESCE(NULLIF($1, ''), current_schema())
+    AND c.relkind IN ('r', 'v', 'i')
+    AND c.relname LIKE $2 ESCAPE '!'
+  ORDER BY c.relname`,
+		schemaName, escPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	result, err := readPGClassRows(rows)
+	rows.Close()
+	if err != nil {
+		return result, err
+	}
+
+	rows, err = db.Query(`SELECT table_name, column_name, data_type, is_nullable
+  FROM information_schema.columns
+  WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema())
+    AND table_name LIKE $2 ESCAPE '!'
+  ORDER BY table_name, ordinal_position`,
+		schemaName, escPrefix+"%")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	return result, readColumnRows(rows, result)
+}
+
+func readPGClassRows(rows *sql.Rows) ([]ElementFound, error) {
+	var result []ElementFound
+
+	for rows.Next() {
+		var (
+			relKind   string
+			elemFound ElementFound
+		)
+		err := rows.Scan(&elemFound.Name, &relKind,
+			&elemFound.TableName, &elemFound.Definition)
+		if err != nil {
+			return result, err
+		}
+
+		switch relKind {
+		case "r":
+			elemFound.ElemType = sqlschema.TableElem
+		case "v":
+			elemFound.ElemType = sqlschema.ViewElem
+		case "i":
+			elemFound.ElemType = sqlschema.IndexElem
+		default:
+			return result, fmt.Errorf(
+				"Unexpected relation kind %q (name %q)",
+				relKind, elemFound.Name)
+		}
+
+		result = append(result, elemFound)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// readColumnRows adds the columns to the tables and views found
+// (rows for other relations are ignored).
+func readColumnRows(rows *sql.Rows, elems []ElementFound) error {
+	byName := make(map[string]*ElementFound)
+	for i := range elems {
+		if elems[i].ElemType != sqlschema.IndexElem {
+			byName[elems[i].Name] = &elems[i]
+		}
+	}
+
+	for rows.Next() {
+		var (
+			tableName, isNullable string
+			col                   Column
+		)
+		err := rows.Scan(&tableName, &col.Name, &col.DataType, &isNullable)
+		if err != nil {
+			return err
+		}
+		col.NotNull = (isNullable == "NO")
+
+		elem, ok := byName[tableName]
+		if ok {
+			elem.Columns = append(elem.Columns, col)
+		}
+	}
+	return rows.Err()
+}
+
+// checkEscapeForLike uses a very strict (whitelist) approach to validation,
+// like in package 'sqlite3schema': unquoted PostgreSQL identifiers are
+// folded to lowercase, so uppercase letters would not match anyway.
+//
+func checkEscapeForLike(nameFragment string, escapeChar rune) (escaped string, err error) {
+	var buf bytes.Buffer
+
+	for i, ch := range nameFragment {
+		switch {
+		case ch == escapeChar: // must be first case
+			return "", fmt.Errorf("Name fragment contains the escape char (%x) at %d.", ch, i)
+		case 'a' <= ch && ch <= 'z':
+			buf.WriteRune(ch)
+		case '0' <= ch && ch <= '9':
+			buf.WriteRune(ch)
+		case ch == '_': // Underscore would be misinterpreted as wildcard, escape it:
+			buf.WriteRune(escapeChar)
+			buf.WriteRune(ch)
+		default:
+			return "", fmt.Errorf("Name fragment is not safe: %x at %d.", ch, i)
+		}
+	}
+
+	return buf.String(), nil
+}